@@ -1,4 +1,243 @@
 package main
 
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jaeiya/billbank/lib"
+	"github.com/jaeiya/billbank/lib/commands"
+	"github.com/jaeiya/billbank/lib/db/sqlite"
+	"github.com/jaeiya/billbank/lib/ui/components"
+)
+
+const (
+	appName             = "billbank"
+	dbFileName          = "billbank.db"
+	idleTimeout         = 5 * time.Minute
+	maxPasswordAttempts = 3
+)
+
+var (
+	errCancelled        = fmt.Errorf("cancelled")
+	errPasswordMismatch = fmt.Errorf("passwords do not match")
+)
+
 func main() {
+	dbPath := flag.String("db", "", "path to the database `file` (default is in the user data directory)")
+	currency := flag.String("currency", lib.USD.String(), "base `currency` of a new database, like USD or EUR")
+	encrypt := flag.Bool("encrypt", false, "encrypt the whole database file with the master password")
+	version := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+
+	if *version {
+		fmt.Println(appName, lib.Version())
+		return
+	}
+
+	if err := run(*dbPath, *currency, *encrypt); err != nil && !errors.Is(err, errCancelled) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", appName, err)
+		os.Exit(1)
+	}
+}
+
+func run(dbPath, currency string, encrypt bool) (err error) {
+	cc, err := lib.ParseCurrencyCode(currency)
+	if err != nil {
+		return err
+	}
+
+	if dbPath == "" {
+		dir, err := dataDir()
+		if err != nil {
+			return fmt.Errorf("cannot find data directory: %w", err)
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("cannot create data directory: %w", err)
+		}
+		dbPath = filepath.Join(dir, dbFileName)
+	}
+
+	db, password, err := openDb(dbPath, cc, encrypt)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// An encrypted database is written out one last time when it's closed
+		if closeErr := db.Close(); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+	}()
+
+	session := lib.NewSession(db, idleTimeout)
+	if password != "" {
+		// Already unlocked while opening it, this only starts the idle timeout
+		if err := session.Unlock(password); err != nil {
+			return err
+		}
+	}
+
+	commander := components.NewCommander(components.WithCommands(newCommands()...))
+	_, err = tea.NewProgram(components.NewSessionModel(session, commander)).Run()
+	return err
+}
+
+/*
+openDb opens the database at path, asking for the password first if the
+whole database is encrypted. A database without a master password gets one
+before anything else, and with encrypt the whole file is encrypted with it
+if it isn't already. The password is returned when it was needed to open
+the database, which leaves it unlocked.
+*/
+func openDb(path string, cc lib.CurrencyCode, encrypt bool) (*sqlite.SqliteDb, string, error) {
+	db, err := sqlite.NewSqliteDb(path, cc)
+	if errors.Is(err, sqlite.ErrEncryptedDb) {
+		return openEncryptedDb(path, cc, "Enter your password to decrypt the database")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	hasPassword, err := db.HasPassword()
+	if err != nil {
+		_ = db.Close()
+		return nil, "", err
+	}
+
+	if encrypt {
+		// The file is encrypted by opening it again with the master password
+		if err := db.Close(); err != nil {
+			return nil, "", err
+		}
+		if hasPassword {
+			return openEncryptedDb(path, cc, "Enter your password to encrypt the database")
+		}
+
+		password, err := newPassword()
+		if err != nil {
+			return nil, "", err
+		}
+		db, err = sqlite.NewEncryptedSqliteDb(path, password, cc)
+		return db, password, err
+	}
+
+	if hasPassword {
+		return db, "", nil
+	}
+
+	password, err := newPassword()
+	if err == nil {
+		err = db.SetPassword(password)
+	}
+	if err != nil {
+		_ = db.Close()
+		return nil, "", err
+	}
+	return db, password, nil
+}
+
+// openEncryptedDb asks for the master password until it opens the database
+func openEncryptedDb(
+	path string,
+	cc lib.CurrencyCode,
+	title string,
+) (*sqlite.SqliteDb, string, error) {
+	var problem error
+	for range maxPasswordAttempts {
+		password, err := promptPassword(title, problem)
+		if err != nil {
+			return nil, "", err
+		}
+
+		db, err := sqlite.NewEncryptedSqliteDb(path, password, cc)
+		if !errors.Is(err, lib.ErrWrongPassword) {
+			return db, password, err
+		}
+		problem = lib.ErrWrongPassword
+	}
+	return nil, "", lib.ErrWrongPassword
+}
+
+/*
+newPassword asks for a new master password twice, until both entries
+match. The password can't be recovered, so a typo would lock the user out
+of every encrypted field for good.
+*/
+func newPassword() (string, error) {
+	var problem error
+	for {
+		password, err := promptPassword("Choose a master password to protect your data", problem)
+		if err != nil {
+			return "", err
+		}
+		if password == "" {
+			problem = lib.ErrEmptyPassword
+			continue
+		}
+
+		confirmed, err := promptPassword("Enter the same password again to confirm it", nil)
+		if err != nil {
+			return "", err
+		}
+		if confirmed != password {
+			problem = errPasswordMismatch
+			continue
+		}
+		return password, nil
+	}
+}
+
+// promptPassword asks for a password, showing problem if it's asked for again
+func promptPassword(title string, problem error) (string, error) {
+	model, err := tea.NewProgram(components.NewPasswordModel(title).WithError(problem)).Run()
+	if err != nil {
+		return "", err
+	}
+
+	password, ok := model.(components.PasswordModel).Password()
+	if !ok {
+		return "", errCancelled
+	}
+	return password, nil
+}
+
+/*
+dataDir is where the database is kept by default: $XDG_DATA_HOME/billbank
+on Linux and other unix systems, falling back to ~/.local/share, and the
+user's application data directory on Windows and macOS.
+*/
+func dataDir() (string, error) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, appName), nil
+	}
+
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, appName), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", appName), nil
+}
+
+func newCommands() []commands.Command {
+	return []commands.Command{
+		commands.NewCommand(commands.CommandConfig{
+			Tree: [][]string{
+				{"show", "view"},
+				{"bills", "income", "cards", "accounts"},
+			},
+		}),
+	}
 }
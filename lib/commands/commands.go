@@ -37,7 +37,15 @@ type CommandStatus struct {
 }
 
 type CommandConfig struct {
-	Command
+	// The command tree, see Command
+	Tree [][]string
+	// Whether the command takes an argument after the last tree position
+	HasArg bool
+	// Validates the argument, required when HasArg is set
+	InputValidation func(arg string) error
+	// Restricts which keys can be typed into the argument
+	KeyValidation func(key rune) bool
+	Exec          func(args ...string) tea.Model
 }
 
 type Command struct {
@@ -59,15 +67,15 @@ type Command struct {
 }
 
 func NewCommand(config CommandConfig) Command {
-	if config.hasArg && config.inputValidationFunc == nil {
+	if config.HasArg && config.InputValidation == nil {
 		panic("command arguments need a validation function")
 	}
 	return Command{
-		tree:                config.tree,
-		inputValidationFunc: config.inputValidationFunc,
-		hasArg:              config.hasArg,
-		execFunc:            config.execFunc,
-		keyValidationFunc:   config.keyValidationFunc,
+		tree:                config.Tree,
+		inputValidationFunc: config.InputValidation,
+		hasArg:              config.HasArg,
+		execFunc:            config.Exec,
+		keyValidationFunc:   config.KeyValidation,
 	}
 }
 
@@ -1,8 +1,14 @@
 package lib
 
 import (
+	"cmp"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
+	"math/big"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -15,33 +21,107 @@ const (
 	JPY
 )
 
+/*
+String returns the ISO 4217 code for the currency, which is also how the
+code is stored.
+*/
+func (cc CurrencyCode) String() string {
+	switch cc {
+	case USD:
+		return "USD"
+	case EUR:
+		return "EUR"
+	case JPY:
+		return "JPY"
+	default:
+		return fmt.Sprintf("CurrencyCode(%d)", cc)
+	}
+}
+
+// ParseCurrencyCode returns the currency for an ISO 4217 code, like "EUR"
+func ParseCurrencyCode(code string) (CurrencyCode, error) {
+	for cc := range currencyFormats {
+		if strings.EqualFold(cc.String(), code) {
+			return cc, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrCurrency, code)
+}
+
+/*
+currencyFormat describes how a currency is written. Decimals is the number
+of minor units digits, so USD has 2 for its cents, while JPY has none.
+*/
+type currencyFormat struct {
+	symbol    string
+	decimals  int
+	thousands string
+	decimal   string
+}
+
+var currencyFormats = map[CurrencyCode]currencyFormat{
+	USD: {symbol: "$", decimals: 2, thousands: ",", decimal: "."},
+	EUR: {symbol: "€", decimals: 2, thousands: ".", decimal: ","},
+	JPY: {symbol: "¥", decimals: 0, thousands: ","},
+}
+
+/*
+Decimals returns how many digits of minor units the currency has.
+
+🟠 Panics if the currency is unsupported
+*/
+func (cc CurrencyCode) Decimals() int {
+	return cc.format().decimals
+}
+
+func (cc CurrencyCode) format() currencyFormat {
+	format, ok := currencyFormats[cc]
+	if !ok {
+		panic(ErrCurrency)
+	}
+	return format
+}
+
 var (
-	ErrCurrencyFloat = fmt.Errorf("failed to parse input as float")
-	ErrCurrencyInt   = fmt.Errorf("failed to parse input as int")
-	ErrUSDDollar     = fmt.Errorf("not a valid dollar amount")
-	ErrUSDCents      = fmt.Errorf("too precise; round to cents only")
-	ErrCurrency      = fmt.Errorf("using an unsupported currency")
-	ErrCurrencyKind  = fmt.Errorf("cannot combine currencies of different kinds")
+	ErrCurrencyFloat     = fmt.Errorf("failed to parse input as float")
+	ErrCurrencyInt       = fmt.Errorf("failed to parse input as int")
+	ErrCurrencyAmount    = fmt.Errorf("not a valid amount")
+	ErrCurrencyPrecision = fmt.Errorf("too precise for the currency")
+	ErrUSDDollar         = fmt.Errorf("not a valid dollar amount")
+	ErrUSDCents          = fmt.Errorf("too precise; round to cents only")
+	ErrCurrency          = fmt.Errorf("using an unsupported currency")
+	ErrCurrencyKind      = fmt.Errorf("cannot combine currencies of different kinds")
+	ErrExchangeRate      = fmt.Errorf("not a valid exchange rate")
+	ErrCurrencyEmpty     = fmt.Errorf("no amount to parse")
+	ErrCurrencySymbol    = fmt.Errorf("written in a different currency")
+	ErrCurrencyGrouping  = fmt.Errorf("misplaced thousands separator")
+	ErrCurrencySign      = fmt.Errorf("too many signs")
+	ErrCurrencyRatio     = fmt.Errorf("ratios must be positive and add up to more than zero")
+	ErrCurrencyOverflow  = fmt.Errorf("amount is too large")
 )
 
 type Currency struct {
-	amount int
+	amount int64
 	code   CurrencyCode
 }
 
+/*
+NewCurrency creates a currency from an amount written in the currency's
+major units, like "12.50" for USD or "1250" for JPY.
+
+🟠 Panics if the amount can't be parsed or the currency is unsupported
+*/
 func NewCurrency(amount string, code CurrencyCode) Currency {
-	c := Currency{}
-	switch code {
-	case USD:
-		if amount != "" {
-			err := c.Add(amount)
-			if err != nil {
-				panic(err)
-			}
-		}
-	default:
+	if _, ok := currencyFormats[code]; !ok {
 		panic(ErrCurrency)
+	}
 
+	c := Currency{code: code}
+	if amount != "" {
+		err := c.Add(amount)
+		if err != nil {
+			panic(err)
+		}
 	}
 	return c
 }
@@ -49,85 +129,256 @@ func NewCurrency(amount string, code CurrencyCode) Currency {
 /*
 NewCurrencyFromStore creates a new currency with an amount as the lowest possible
 denomination of the specified currency code. For example, if the currency
-code is USD, then the amount is interpreted as Cents.
+code is USD, then the amount is interpreted as Cents, while JPY has no
+smaller denomination than the Yen.
 
 🟠 As the name suggests, this method should be used strictly for loading
 stored amounts
 */
-func NewCurrencyFromStore(amount int, code CurrencyCode) Currency {
-	c := Currency{}
-	c.amount = amount
-	return c
+func NewCurrencyFromStore(amount int64, code CurrencyCode) Currency {
+	return Currency{amount: amount, code: code}
 }
 
-func (c *Currency) Add(amount string) error {
-	switch c.code {
-
-	case USD:
-		err := verifyUSDAmount(amount)
-		if err != nil {
-			return err
-		}
+/*
+ParseCurrency parses an amount the way people write it, like "$1,234.50",
+"1234.5 USD", "(12.00)" or "€1.234,56". Separators follow the currency's
+format, so anything String writes can be parsed back. The symbol or ISO
+code is optional, but has to belong to the currency when it's there.
+Accounting parentheses and a leading minus both make the amount negative.
+*/
+func ParseCurrency(input string, code CurrencyCode) (Currency, error) {
+	format, ok := currencyFormats[code]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w: %s", ErrCurrency, code)
+	}
 
-		intAmount, err := toUSDCents(amount)
-		if err != nil {
-			return err
-		}
-		c.amount += intAmount
+	amount, err := parseWritten(input, format)
+	if err != nil {
+		return Currency{}, fmt.Errorf("cannot parse %q as %s: %w", input, code, err)
+	}
+	return Currency{amount: amount, code: code}, nil
+}
 
-	default:
-		panic(ErrCurrency)
+func (c *Currency) Add(amount string) error {
+	minorUnits, err := c.parseAmount(amount)
+	if err != nil {
+		return err
+	}
 
+	sum, ok := addInt64(c.amount, minorUnits)
+	if !ok {
+		return ErrCurrencyOverflow
 	}
+	c.amount = sum
 	return nil
 }
 
+/*
+AddCurrency adds every currency to c.
+
+🟠 Panics if the currencies are of different kinds or the sum overflows.
+Use CheckedAdd to get an error instead.
+*/
 func (c *Currency) AddCurrency(currencies ...Currency) {
 	for _, c2 := range currencies {
 		if c.code != c2.code {
 			panic(ErrCurrencyKind)
 		}
-		c.amount += c2.amount
+
+		sum, ok := addInt64(c.amount, c2.amount)
+		if !ok {
+			panic(ErrCurrencyOverflow)
+		}
+		c.amount = sum
 	}
 }
 
 func (c *Currency) Subtract(amount string) error {
-	err := c.Add("-" + amount)
+	minorUnits, err := c.parseAmount(amount)
 	if err != nil {
 		return err
 	}
+
+	diff, ok := subInt64(c.amount, minorUnits)
+	if !ok {
+		return ErrCurrencyOverflow
+	}
+	c.amount = diff
 	return nil
 }
 
+/*
+SubtractCurrency subtracts every currency from c.
+
+🟠 Panics if the currencies are of different kinds or the difference
+overflows. Use CheckedSub to get an error instead.
+*/
 func (c *Currency) SubtractCurrency(currencies ...Currency) {
 	for _, c2 := range currencies {
 		if c.code != c2.code {
 			panic(ErrCurrencyKind)
 		}
-		c.amount -= c2.amount
+
+		diff, ok := subInt64(c.amount, c2.amount)
+		if !ok {
+			panic(ErrCurrencyOverflow)
+		}
+		c.amount = diff
 	}
 }
 
-func (c *Currency) Set(amount string) error {
-	switch c.code {
-	case USD:
-		if err := verifyUSDAmount(amount); err != nil {
-			return err
-		}
+// CheckedAdd returns c + c2, or an error if they can't be added safely
+func (c Currency) CheckedAdd(c2 Currency) (Currency, error) {
+	if err := c.requireKind(c2); err != nil {
+		return Currency{}, err
+	}
 
-		cents, err := toUSDCents(amount)
-		if err != nil {
-			return err
-		}
-		c.amount = cents
+	sum, ok := addInt64(c.amount, c2.amount)
+	if !ok {
+		return Currency{}, fmt.Errorf(
+			"%w: cannot add %d to %d",
+			ErrCurrencyOverflow,
+			c2.amount,
+			c.amount,
+		)
+	}
+	return Currency{amount: sum, code: c.code}, nil
+}
 
-	default:
+// CheckedSub returns c - c2, or an error if they can't be subtracted safely
+func (c Currency) CheckedSub(c2 Currency) (Currency, error) {
+	if err := c.requireKind(c2); err != nil {
+		return Currency{}, err
+	}
+
+	diff, ok := subInt64(c.amount, c2.amount)
+	if !ok {
+		return Currency{}, fmt.Errorf(
+			"%w: cannot subtract %d from %d",
+			ErrCurrencyOverflow,
+			c2.amount,
+			c.amount,
+		)
+	}
+	return Currency{amount: diff, code: c.code}, nil
+}
+
+// CheckedMul returns c * n, or an error if the product overflows
+func (c Currency) CheckedMul(n int64) (Currency, error) {
+	product, ok := mulInt64(c.amount, n)
+	if !ok {
+		return Currency{}, fmt.Errorf(
+			"%w: cannot multiply %d by %d",
+			ErrCurrencyOverflow,
+			c.amount,
+			n,
+		)
+	}
+	return Currency{amount: product, code: c.code}, nil
+}
+
+func (c Currency) requireKind(c2 Currency) error {
+	if c.code != c2.code {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyKind, c.code, c2.code)
+	}
+	return nil
+}
+
+/*
+Compare returns -1 if c is less than c2, 0 if they're equal and +1 if c is
+more than c2.
+
+🟠 Panics if the currencies are of different kinds
+*/
+func (c Currency) Compare(c2 Currency) int {
+	if c.code != c2.code {
 		panic(ErrCurrencyKind)
 	}
+	return cmp.Compare(c.amount, c2.amount)
+}
+
+func (c Currency) IsNegative() bool {
+	return c.amount < 0
+}
+
+func (c Currency) IsZero() bool {
+	return c.amount == 0
+}
 
+/*
+Abs returns the amount without its sign.
+
+🟠 Panics if the amount is the most negative int64, which has no positive
+*/
+func (c Currency) Abs() Currency {
+	if c.amount < 0 {
+		return c.Negate()
+	}
+	return c
+}
+
+/*
+Negate returns the amount with its sign flipped.
+
+🟠 Panics if the amount is the most negative int64, which has no positive
+*/
+func (c Currency) Negate() Currency {
+	if c.amount == math.MinInt64 {
+		panic(ErrCurrencyOverflow)
+	}
+	return Currency{amount: -c.amount, code: c.code}
+}
+
+/*
+Max returns the larger of c and c2.
+
+🟠 Panics if the currencies are of different kinds
+*/
+func (c Currency) Max(c2 Currency) Currency {
+	if c.Compare(c2) < 0 {
+		return c2
+	}
+	return c
+}
+
+/*
+Min returns the smaller of c and c2.
+
+🟠 Panics if the currencies are of different kinds
+*/
+func (c Currency) Min(c2 Currency) Currency {
+	if c.Compare(c2) > 0 {
+		return c2
+	}
+	return c
+}
+
+func (c *Currency) Set(amount string) error {
+	minorUnits, err := c.parseAmount(amount)
+	if err != nil {
+		return err
+	}
+	c.amount = minorUnits
 	return nil
 }
 
+/*
+parseAmount converts an amount written in major units into the minor units
+of the currency.
+
+🟠 Panics if the currency is unsupported
+*/
+func (c Currency) parseAmount(amount string) (int64, error) {
+	decimals := c.code.Decimals()
+	if err := verifyAmount(amount, decimals); err != nil {
+		if c.code == USD {
+			return 0, toUSDErr(err)
+		}
+		return 0, fmt.Errorf("%w for %s", err, c.code)
+	}
+	return toMinorUnits(amount, decimals)
+}
+
 func (c *Currency) SetCurrency(c2 Currency) {
 	if c.code != c2.code {
 		panic(ErrCurrencyKind)
@@ -139,17 +390,156 @@ func (c Currency) GetCode() CurrencyCode {
 	return c.code
 }
 
-func (c Currency) GetPercentage(p int) int {
-	return int(math.Round(float64(c.amount*p) / 100))
+/*
+GetPercentage returns p percent of the amount in minor units, rounded to the
+nearest minor unit.
+
+🟠 Panics if the percentage doesn't fit in an int64
+*/
+func (c Currency) GetPercentage(p int) int64 {
+	return c.GetBasisPoints(p * 100).amount
+}
+
+/*
+GetBasisPoints returns a portion of the amount, where each basis point is
+0.01%, so 12.5% is 1250. The portion is rounded to the nearest minor unit,
+with halves rounded away from zero.
+
+🟠 Panics if the portion doesn't fit in an int64
+*/
+func (c Currency) GetBasisPoints(bp int) Currency {
+	portion := divRound(
+		new(big.Int).Mul(big.NewInt(c.amount), big.NewInt(int64(bp))),
+		big.NewInt(10_000),
+	)
+	if !portion.IsInt64() {
+		panic(ErrCurrencyOverflow)
+	}
+	return Currency{amount: portion.Int64(), code: c.code}
+}
+
+/*
+Allocate divides the amount into one part per ratio, like Allocate(70, 30)
+for a 70/30 split. The parts always add up to the amount. Minor units that
+can't be divided evenly go to the parts that lost the most to rounding,
+with ties going to the earlier part.
+
+🟠 Panics with ErrCurrencyRatio if a ratio is negative or they're all zero
+*/
+func (c Currency) Allocate(ratios ...int) []Currency {
+	total := new(big.Int)
+	for _, ratio := range ratios {
+		if ratio < 0 {
+			panic(ErrCurrencyRatio)
+		}
+		total.Add(total, big.NewInt(int64(ratio)))
+	}
+	if total.Sign() == 0 {
+		panic(ErrCurrencyRatio)
+	}
+
+	// Negative amounts are allocated like positive ones, then flipped back.
+	// Shares are worked out in big ints, so amount * ratio can't overflow.
+	amount := new(big.Int).Abs(big.NewInt(c.amount))
+
+	shares := make([]*big.Int, len(ratios))
+	remainders := make([]*big.Int, len(ratios))
+	left := new(big.Int).Set(amount)
+	for i, ratio := range ratios {
+		shares[i], remainders[i] = new(big.Int).QuoRem(
+			new(big.Int).Mul(amount, big.NewInt(int64(ratio))),
+			total,
+			new(big.Int),
+		)
+		left.Sub(left, shares[i])
+	}
+
+	order := make([]int, len(ratios))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]].Cmp(remainders[order[j]]) > 0
+	})
+	for _, i := range order[:left.Int64()] {
+		shares[i].Add(shares[i], big.NewInt(1))
+	}
+
+	parts := make([]Currency, len(shares))
+	for i, share := range shares {
+		if c.amount < 0 {
+			share.Neg(share)
+		}
+		parts[i] = Currency{amount: share.Int64(), code: c.code}
+	}
+	return parts
+}
+
+/*
+Split divides the amount into n parts that are as even as possible, like
+$100.00 into $33.34, $33.33 and $33.33.
+
+🟠 Panics with ErrCurrencyRatio if n is less than 1
+*/
+func (c Currency) Split(n int) []Currency {
+	if n < 1 {
+		panic(ErrCurrencyRatio)
+	}
+
+	ratios := make([]int, n)
+	for i := range ratios {
+		ratios[i] = 1
+	}
+	return c.Allocate(ratios...)
 }
 
+/*
+String formats the currency the way it's written where it's used, like
+$1,234.56, €1.234,56 or ¥1,234. Negative amounts are prefixed with a minus.
+
+🟠 Panics if the currency is unsupported
+*/
 func (c Currency) String() string {
-	switch c.code {
-	case USD:
-		return fmt.Sprintf("$%d.%02d", c.amount/100, c.amount%100)
-	default:
-		panic(ErrCurrency)
+	format := c.code.format()
+	sign, major, minor := c.splitDigits()
+
+	// Thousands separators are added from the right, every 3 digits
+	var sb strings.Builder
+	for i, digit := range major {
+		if i > 0 && (len(major)-i)%3 == 0 {
+			sb.WriteString(format.thousands)
+		}
+		sb.WriteRune(digit)
 	}
+
+	if format.decimals > 0 {
+		sb.WriteString(format.decimal)
+		sb.WriteString(minor)
+	}
+
+	return sign + format.symbol + sb.String()
+}
+
+/*
+splitDigits splits the amount into its sign and the digits on either side
+of the decimal separator, padding with zeros so cents always have 2 digits.
+
+🟠 Panics if the currency is unsupported
+*/
+func (c Currency) splitDigits() (sign, major, minor string) {
+	decimals := c.code.Decimals()
+
+	digits := strconv.FormatInt(c.amount, 10)
+	if c.amount < 0 {
+		sign = "-"
+		digits = digits[1:]
+	}
+
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+
+	return sign, digits[:len(digits)-decimals], digits[len(digits)-decimals:]
 }
 
 /*
@@ -160,7 +550,7 @@ then it would return the total Cents.
 🟠 As the name suggests, it should only be used when needing
 to store the value.
 */
-func (c *Currency) GetStoredValue() int {
+func (c *Currency) GetStoredValue() int64 {
 	return c.amount
 }
 
@@ -174,30 +564,176 @@ func ToCurrency(v any) (Currency, error) {
 	return Currency{}, fmt.Errorf("not a currency")
 }
 
+/*
+Scan loads a stored amount into c. Only the amount is stored, so c keeps
+the code it already has, which means the code needs to be set before
+scanning, like with NewCurrency("", code).
+
+Use NullCurrency for columns that can be NULL.
+*/
+func (c *Currency) Scan(src any) error {
+	switch v := src.(type) {
+	case int64:
+		c.amount = v
+		return nil
+	case nil:
+		return fmt.Errorf("%w: cannot scan NULL into a currency", ErrCurrencyAmount)
+	default:
+		return fmt.Errorf("%w: cannot scan %T into a currency", ErrCurrencyAmount, src)
+	}
+}
+
+// Value stores the amount in its lowest denomination, the same as GetStoredValue
+func (c Currency) Value() (driver.Value, error) {
+	return c.amount, nil
+}
+
+// currencyJSON is how a currency is exported, with its code alongside it
+type currencyJSON struct {
+	Amount string `json:"amount"`
+	Code   string `json:"code"`
+}
+
+/*
+MarshalJSON writes the currency as its amount and code, like
+{"amount":"1234.56","code":"EUR"}. The amount is a string in major units
+with a plain decimal point, so it can't lose precision as a float.
+*/
+func (c Currency) MarshalJSON() ([]byte, error) {
+	if _, ok := currencyFormats[c.code]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrCurrency, c.code)
+	}
+
+	sign, major, minor := c.splitDigits()
+	amount := sign + major
+	if minor != "" {
+		amount += "." + minor
+	}
+	return json.Marshal(currencyJSON{Amount: amount, Code: c.code.String()})
+}
+
+// UnmarshalJSON reads a currency written by MarshalJSON
+func (c *Currency) UnmarshalJSON(data []byte) error {
+	var v currencyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	code, err := ParseCurrencyCode(v.Code)
+	if err != nil {
+		return err
+	}
+
+	parsed := Currency{code: code}
+	if err := parsed.Set(v.Amount); err != nil {
+		return fmt.Errorf("cannot unmarshal %q as %s: %w", v.Amount, code, err)
+	}
+
+	*c = parsed
+	return nil
+}
+
+/*
+NullCurrency is a currency that may be NULL, like sql.NullInt64. As with
+Currency, the code needs to be set before scanning.
+*/
+type NullCurrency struct {
+	Currency Currency
+	Valid    bool
+}
+
+// NewNullCurrency creates a NullCurrency that's only valid when c isn't nil
+func NewNullCurrency(c *Currency) NullCurrency {
+	if c == nil {
+		return NullCurrency{}
+	}
+	return NullCurrency{Currency: *c, Valid: true}
+}
+
+func (nc *NullCurrency) Scan(src any) error {
+	if src == nil {
+		nc.Currency.amount = 0
+		nc.Valid = false
+		return nil
+	}
+
+	if err := nc.Currency.Scan(src); err != nil {
+		return err
+	}
+	nc.Valid = true
+	return nil
+}
+
+func (nc NullCurrency) Value() (driver.Value, error) {
+	if !nc.Valid {
+		return nil, nil
+	}
+	return nc.Currency.Value()
+}
+
+// Ptr returns the currency, or nil if it's NULL
+func (nc NullCurrency) Ptr() *Currency {
+	if !nc.Valid {
+		return nil
+	}
+	c := nc.Currency
+	return &c
+}
+
+// MarshalJSON writes null when the currency is NULL
+func (nc NullCurrency) MarshalJSON() ([]byte, error) {
+	if !nc.Valid {
+		return []byte("null"), nil
+	}
+	return nc.Currency.MarshalJSON()
+}
+
+func (nc *NullCurrency) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*nc = NullCurrency{}
+		return nil
+	}
+
+	if err := nc.Currency.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	nc.Valid = true
+	return nil
+}
+
+/*
+CalcAvg returns the average of the currencies, rounded to the nearest minor
+unit with halves rounded away from zero.
+
+🟠 Panics if there are no currencies or their codes are not consistent
+*/
 func CalcAvg(currencies ...Currency) Currency {
 	expectedCode := currencies[0].GetCode()
-	total := NewCurrency("0", expectedCode)
-	avg := NewCurrency("0", expectedCode)
 
+	// The total can outgrow an int64 even when the average doesn't
+	total := new(big.Int)
 	for _, c := range currencies {
 		if expectedCode != c.GetCode() {
 			panic("currency codes are not consistent")
 		}
-		total.AddCurrency(c)
+		total.Add(total, big.NewInt(c.amount))
 	}
 
-	// All but two countries have a 100 to 1 conversion rate between
-	// the lowest and highest denomination of their currencies.
-	avg.Set(
-		fmt.Sprintf("%.2f", float64(total.amount)/float64(len(currencies))/100),
-	)
-	return avg
+	avg := divRound(total, big.NewInt(int64(len(currencies))))
+	return Currency{amount: avg.Int64(), code: expectedCode}
 }
 
-func verifyUSDAmount(amount string) error {
+/*
+verifyAmount makes sure the amount is a plain number with no more
+fractional digits than the currency's minor units allow.
+*/
+func verifyAmount(amount string, decimals int) error {
 	if !strings.Contains(amount, ".") {
 		if _, err := strconv.ParseInt(amount, 10, 64); err != nil {
-			return ErrUSDDollar
+			if errors.Is(err, strconv.ErrRange) {
+				return ErrCurrencyOverflow
+			}
+			return ErrCurrencyAmount
 		}
 		return nil
 	}
@@ -207,31 +743,350 @@ func verifyUSDAmount(amount string) error {
 	}
 
 	s := strings.Split(amount, ".")[1]
-	if len(s) > 2 {
-		return ErrUSDCents
+	if len(s) > decimals {
+		return ErrCurrencyPrecision
 	}
 	return nil
 }
 
-func toUSDCents(amount string) (int, error) {
-	if !strings.Contains(amount, ".") {
-		intAmount, err := strconv.Atoi(amount)
-		if err != nil {
-			return 0, ErrCurrencyInt
-		}
-		return intAmount * 100, nil
+// toUSDErr keeps the dollar specific errors that USD has always returned
+func toUSDErr(err error) error {
+	switch err {
+	case ErrCurrencyAmount:
+		return ErrUSDDollar
+	case ErrCurrencyPrecision:
+		return ErrUSDCents
+	default:
+		return err
+	}
+}
+
+/*
+toMinorUnits converts an amount into the lowest denomination of a currency
+with the given number of decimals.
+*/
+func toMinorUnits(amount string, decimals int) (int64, error) {
+	negative := strings.HasPrefix(amount, "-")
+	if negative || strings.HasPrefix(amount, "+") {
+		amount = amount[1:]
 	}
 
-	// Add hundredths placeholder
-	parts := strings.Split(amount, ".")
-	if len(parts[1]) == 1 {
-		parts[1] += "0"
+	whole, fraction, _ := strings.Cut(amount, ".")
+	minorUnits, err := joinMinorUnits(whole, fraction, decimals)
+	if err != nil {
+		return 0, err
 	}
-	amount = parts[0] + parts[1]
 
-	intAmount, err := strconv.Atoi(amount)
+	if negative {
+		return -minorUnits, nil
+	}
+	return minorUnits, nil
+}
+
+/*
+joinMinorUnits combines the digits on either side of the decimal separator.
+The fraction is padded with placeholders, so ".5" becomes ".50" for cents.
+*/
+func joinMinorUnits(whole, fraction string, decimals int) (int64, error) {
+	if len(fraction) > decimals {
+		return 0, ErrCurrencyPrecision
+	}
+
+	digits := whole + fraction + strings.Repeat("0", decimals-len(fraction))
+	if !isDigits(digits) {
+		return 0, ErrCurrencyInt
+	}
+
+	minorUnits, err := strconv.ParseInt(digits, 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, ErrCurrencyOverflow
+	}
 	if err != nil {
 		return 0, ErrCurrencyInt
 	}
-	return intAmount, nil
+	return minorUnits, nil
+}
+
+/*
+parseWritten converts a written amount into minor units. Signs, symbols and
+codes are peeled off the outside first, so "-$12", "$-12" and "($12)" are
+all read the same way, then the digits are checked against the format.
+*/
+func parseWritten(input string, format currencyFormat) (int64, error) {
+	s := strings.TrimSpace(input)
+	if s == "" {
+		return 0, ErrCurrencyEmpty
+	}
+
+	hasOpen, hasClose := strings.HasPrefix(s, "("), strings.HasSuffix(s, ")")
+	if hasOpen != hasClose {
+		return 0, ErrCurrencyAmount
+	}
+	parens := hasOpen
+	if parens {
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
+
+	sign, s := cutSign(s)
+	s, err := cutMarker(s, format, strings.CutPrefix)
+	if err != nil {
+		return 0, err
+	}
+	if sign == "" {
+		sign, s = cutSign(s)
+	}
+	if s, err = cutMarker(s, format, strings.CutSuffix); err != nil {
+		return 0, err
+	}
+
+	if extra, _ := cutSign(s); extra != "" || (parens && sign != "") {
+		return 0, ErrCurrencySign
+	}
+
+	minorUnits, err := parseDigits(s, format)
+	if err != nil {
+		return 0, err
+	}
+
+	if parens || sign == "-" {
+		return -minorUnits, nil
+	}
+	return minorUnits, nil
+}
+
+// cutSign removes a leading plus or minus, returning it separately
+func cutSign(s string) (string, string) {
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		return s[:1], strings.TrimSpace(s[1:])
+	}
+	return "", s
+}
+
+/*
+cutMarker removes the currency's symbol or ISO code from one end of s, with
+cut deciding which end. A marker of any other currency is an error, since
+the amount was written in a currency it isn't being parsed as.
+*/
+func cutMarker(
+	s string,
+	format currencyFormat,
+	cut func(s, marker string) (string, bool),
+) (string, error) {
+	for cc, other := range currencyFormats {
+		markers := []string{other.symbol, cc.String(), strings.ToLower(cc.String())}
+		for _, marker := range markers {
+			rest, found := cut(s, marker)
+			if !found {
+				continue
+			}
+			if other != format {
+				return "", fmt.Errorf("%w: %s", ErrCurrencySymbol, marker)
+			}
+			return strings.TrimSpace(rest), nil
+		}
+	}
+	return s, nil
+}
+
+/*
+parseDigits converts digits written with the format's separators into minor
+units. Thousands separators are optional, but have to separate groups of
+exactly 3 digits.
+*/
+func parseDigits(s string, format currencyFormat) (int64, error) {
+	// Yen have no decimal separator, but "12.5" is still yen that are too precise
+	decimal := format.decimal
+	if decimal == "" {
+		decimal = "."
+	}
+
+	whole, fraction, hasDecimal := strings.Cut(s, decimal)
+	if whole == "" && fraction == "" {
+		return 0, ErrCurrencyAmount
+	}
+	if hasDecimal && !isDigits(fraction) {
+		return 0, ErrCurrencyAmount
+	}
+
+	if strings.Contains(whole, format.thousands) {
+		groups := strings.Split(whole, format.thousands)
+		for i, group := range groups {
+			if len(group) > 3 || (i > 0 && len(group) != 3) || group == "" {
+				return 0, ErrCurrencyGrouping
+			}
+		}
+		whole = strings.Join(groups, "")
+	}
+	if whole != "" && !isDigits(whole) {
+		return 0, ErrCurrencyAmount
+	}
+
+	minorUnits, err := joinMinorUnits(whole, fraction, format.decimals)
+	if errors.Is(err, ErrCurrencyInt) {
+		return 0, ErrCurrencyAmount
+	}
+	return minorUnits, err
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Exchange rates are stored with 6 decimals, which is how precise banks quote them
+const rateDecimals = 6
+
+/*
+ExchangeRate is how many units of To one unit of From is worth. Rates are
+kept as integers, so converting an amount never goes through a float.
+*/
+type ExchangeRate struct {
+	From CurrencyCode
+	To   CurrencyCode
+	rate int64
+}
+
+/*
+NewExchangeRate parses a rate, like "1.08" for 1 EUR being worth 1.08 USD.
+Rates can have up to 6 decimals and must be more than zero.
+*/
+func NewExchangeRate(from, to CurrencyCode, rate string) (ExchangeRate, error) {
+	for _, cc := range []CurrencyCode{from, to} {
+		if _, ok := currencyFormats[cc]; !ok {
+			return ExchangeRate{}, fmt.Errorf("%w: %s", ErrCurrency, cc)
+		}
+	}
+
+	if err := verifyAmount(rate, rateDecimals); err != nil {
+		return ExchangeRate{}, fmt.Errorf("%w %q: %w", ErrExchangeRate, rate, err)
+	}
+
+	stored, err := toMinorUnits(rate, rateDecimals)
+	if err != nil {
+		return ExchangeRate{}, fmt.Errorf("%w %q: %w", ErrExchangeRate, rate, err)
+	}
+	if stored <= 0 {
+		return ExchangeRate{}, fmt.Errorf("%w %q: must be more than zero", ErrExchangeRate, rate)
+	}
+
+	return ExchangeRate{From: from, To: to, rate: stored}, nil
+}
+
+/*
+NewExchangeRateFromStore creates an exchange rate from its stored value.
+
+🟠 As the name suggests, this method should be used strictly for loading
+stored rates
+*/
+func NewExchangeRateFromStore(from, to CurrencyCode, rate int64) ExchangeRate {
+	return ExchangeRate{From: from, To: to, rate: rate}
+}
+
+/*
+GetStoredValue returns the rate as an integer with 6 implied decimals.
+
+🟠 As the name suggests, it should only be used when needing
+to store the value.
+*/
+func (r ExchangeRate) GetStoredValue() int64 {
+	return r.rate
+}
+
+func (r ExchangeRate) String() string {
+	whole := r.rate / int64(math.Pow10(rateDecimals))
+	fraction := r.rate % int64(math.Pow10(rateDecimals))
+	digits := strings.TrimRight(fmt.Sprintf("%0*d", rateDecimals, fraction), "0")
+	if digits == "" {
+		return fmt.Sprintf("1 %s = %d %s", r.From, whole, r.To)
+	}
+	return fmt.Sprintf("1 %s = %d.%s %s", r.From, whole, digits, r.To)
+}
+
+/*
+Convert exchanges c into the other currency of the rate, in either
+direction. The result is rounded to the nearest minor unit, with halves
+rounded away from zero.
+
+Returns ErrCurrencyKind if c is in neither currency of the rate, or
+ErrCurrencyOverflow if the result doesn't fit.
+*/
+func (r ExchangeRate) Convert(c Currency) (Currency, error) {
+	scale := func(cc CurrencyCode) *big.Int {
+		return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(cc.Decimals())), nil)
+	}
+	rate := big.NewInt(r.rate)
+	rateScale := new(big.Int).Exp(big.NewInt(10), big.NewInt(rateDecimals), nil)
+
+	num := big.NewInt(c.amount)
+	den := big.NewInt(1)
+	var to CurrencyCode
+
+	switch c.code {
+	case r.From:
+		to = r.To
+		num.Mul(num, rate).Mul(num, scale(r.To))
+		den.Mul(rateScale, scale(r.From))
+	case r.To:
+		to = r.From
+		num.Mul(num, rateScale).Mul(num, scale(r.From))
+		den.Mul(rate, scale(r.To))
+	default:
+		return Currency{}, fmt.Errorf(
+			"%w: cannot convert %s with a %s to %s rate",
+			ErrCurrencyKind,
+			c.code,
+			r.From,
+			r.To,
+		)
+	}
+
+	converted := divRound(num, den)
+	if !converted.IsInt64() {
+		return Currency{}, fmt.Errorf("%w: cannot convert %s to %s", ErrCurrencyOverflow, c, to)
+	}
+	return Currency{amount: converted.Int64(), code: to}, nil
+}
+
+// divRound divides and rounds halves away from zero
+func divRound(num, den *big.Int) *big.Int {
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Sign() == 0 {
+		return quo
+	}
+
+	twiceRem := new(big.Int).Abs(rem)
+	twiceRem.Lsh(twiceRem, 1)
+	if twiceRem.Cmp(new(big.Int).Abs(den)) >= 0 {
+		if num.Sign()*den.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	return quo
+}
+
+// addInt64 returns a + b, and false if the sum doesn't fit in an int64
+func addInt64(a, b int64) (int64, bool) {
+	sum := a + b
+	return sum, (b >= 0) == (sum >= a)
+}
+
+// subInt64 returns a - b, and false if the difference doesn't fit in an int64
+func subInt64(a, b int64) (int64, bool) {
+	diff := a - b
+	return diff, (b >= 0) == (diff <= a)
+}
+
+// mulInt64 returns a * b, and false if the product doesn't fit in an int64
+func mulInt64(a, b int64) (int64, bool) {
+	product := new(big.Int).Mul(big.NewInt(a), big.NewInt(b))
+	return product.Int64(), product.IsInt64()
 }
@@ -1,6 +1,8 @@
 package lib
 
 import (
+	"encoding/json"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -151,7 +153,7 @@ func TestCurrency(t *testing.T) {
 	t.Run("should panic with unsupported currency", func(t *testing.T) {
 		t.Parallel()
 		a := assert.New(t)
-		a.PanicsWithError(ErrCurrency.Error(), func() { NewCurrency("", EUR) })
+		a.PanicsWithError(ErrCurrency.Error(), func() { NewCurrency("", CurrencyCode(99)) })
 
 		c := NewCurrency("", USD)
 		c.code = EUR
@@ -171,10 +173,802 @@ func TestCurrency(t *testing.T) {
 	t.Run("should error if conversion to cents is non-int USD amount", func(t *testing.T) {
 		t.Parallel()
 		a := assert.New(t)
-		_, err := toUSDCents("abc")
+		_, err := toMinorUnits("abc", 2)
 		a.ErrorIs(err, ErrCurrencyInt)
 
-		_, err = toUSDCents("a.b")
+		_, err = toMinorUnits("a.b", 2)
 		a.ErrorIs(err, ErrCurrencyInt)
 	})
 }
+
+func TestCurrencyCodes(t *testing.T) {
+	type MockTable struct {
+		should   string
+		code     CurrencyCode
+		amounts  []string
+		expected string
+		stored   int64
+	}
+
+	table := []MockTable{
+		{
+			should:   "format dollars with thousands separators",
+			code:     USD,
+			amounts:  []string{"1234.56"},
+			expected: "$1,234.56",
+			stored:   123456,
+		},
+		{
+			should:   "format millions of dollars",
+			code:     USD,
+			amounts:  []string{"1234567.8"},
+			expected: "$1,234,567.80",
+			stored:   123456780,
+		},
+		{
+			should:   "format euros with dots and a decimal comma",
+			code:     EUR,
+			amounts:  []string{"1000", "234.56"},
+			expected: "€1.234,56",
+			stored:   123456,
+		},
+		{
+			should:   "format euro cents",
+			code:     EUR,
+			amounts:  []string{".5"},
+			expected: "€0,50",
+			stored:   50,
+		},
+		{
+			should:   "format yen without decimals",
+			code:     JPY,
+			amounts:  []string{"1000", "234"},
+			expected: "¥1,234",
+			stored:   1234,
+		},
+		{
+			should:   "format small yen amounts",
+			code:     JPY,
+			amounts:  []string{"5"},
+			expected: "¥5",
+			stored:   5,
+		},
+		{
+			should:   "format negative amounts",
+			code:     EUR,
+			amounts:  []string{"-1234.5"},
+			expected: "-€1.234,50",
+			stored:   -123450,
+		},
+	}
+
+	for _, mock := range table {
+		t.Run("should "+mock.should, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			c := NewCurrency("", mock.code)
+
+			for _, amount := range mock.amounts {
+				require.NoError(t, c.Add(amount))
+			}
+
+			a.Equal(mock.expected, c.String())
+			a.Equal(mock.stored, c.GetStoredValue())
+			a.Equal(mock.code, c.GetCode())
+		})
+	}
+
+	t.Run("should keep the code of stored amounts", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		c := NewCurrencyFromStore(1234, JPY)
+		a.Equal(JPY, c.GetCode())
+		a.Equal("¥1,234", c.String())
+		a.Equal(NewCurrency("1234", JPY), c)
+
+		c = NewCurrencyFromStore(123456, EUR)
+		a.Equal(EUR, c.GetCode())
+		a.Equal("€1.234,56", c.String())
+	})
+
+	t.Run("should error on minor units the currency doesn't have", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		yen := NewCurrency("", JPY)
+		a.ErrorIs(yen.Add("12.5"), ErrCurrencyPrecision)
+
+		euro := NewCurrency("", EUR)
+		a.ErrorIs(euro.Add("1.234"), ErrCurrencyPrecision)
+		a.ErrorIs(euro.Add("euro"), ErrCurrencyAmount)
+	})
+
+	t.Run("should not combine different currencies", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		c := NewCurrency("10", USD)
+		a.PanicsWithError(ErrCurrencyKind.Error(), func() { c.AddCurrency(NewCurrency("10", EUR)) })
+	})
+
+	t.Run("should average in minor units", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		a.Equal(NewCurrency("2", JPY), CalcAvg(NewCurrency("1", JPY), NewCurrency("2", JPY)))
+		a.Equal(NewCurrency("1.50", EUR), CalcAvg(NewCurrency("1", EUR), NewCurrency("2", EUR)))
+	})
+}
+
+func TestExchangeRate(t *testing.T) {
+	type MockTable struct {
+		should   string
+		from     CurrencyCode
+		to       CurrencyCode
+		rate     string
+		amount   Currency
+		expected Currency
+	}
+
+	table := []MockTable{
+		{
+			should:   "convert euros to dollars",
+			from:     EUR,
+			to:       USD,
+			rate:     "1.08",
+			amount:   NewCurrency("100", EUR),
+			expected: NewCurrency("108", USD),
+		},
+		{
+			should:   "convert back the other way",
+			from:     EUR,
+			to:       USD,
+			rate:     "1.08",
+			amount:   NewCurrency("108", USD),
+			expected: NewCurrency("100", EUR),
+		},
+		{
+			should:   "convert yen that has no minor units",
+			from:     JPY,
+			to:       USD,
+			rate:     "0.0067",
+			amount:   NewCurrency("1000", JPY),
+			expected: NewCurrency("6.70", USD),
+		},
+		{
+			should:   "convert into yen",
+			from:     USD,
+			to:       JPY,
+			rate:     "149.325",
+			amount:   NewCurrency("12.34", USD),
+			expected: NewCurrency("1843", JPY),
+		},
+		{
+			should:   "round halves away from zero",
+			from:     USD,
+			to:       EUR,
+			rate:     "0.5",
+			amount:   NewCurrency("-0.05", USD),
+			expected: NewCurrency("-0.03", EUR),
+		},
+	}
+
+	for _, mock := range table {
+		t.Run("should "+mock.should, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+
+			rate, err := NewExchangeRate(mock.from, mock.to, mock.rate)
+			r.NoError(err)
+
+			converted, err := rate.Convert(mock.amount)
+			r.NoError(err)
+			a.Equal(mock.expected, converted)
+		})
+	}
+
+	t.Run("should refuse currencies that aren't part of the rate", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		rate, err := NewExchangeRate(EUR, USD, "1.08")
+		r.NoError(err)
+
+		_, err = rate.Convert(NewCurrency("100", JPY))
+		a.ErrorIs(err, ErrCurrencyKind)
+	})
+
+	t.Run("should error on invalid rates", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		for _, rate := range []string{"0", "-1.5", "abc", "1.0000001"} {
+			_, err := NewExchangeRate(EUR, USD, rate)
+			a.ErrorIs(err, ErrExchangeRate, "rate: %s", rate)
+		}
+
+		_, err := NewExchangeRate(EUR, CurrencyCode(99), "1")
+		a.ErrorIs(err, ErrCurrency)
+	})
+
+	t.Run("should store and format rates", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		rate, err := NewExchangeRate(EUR, USD, "1.08")
+		r.NoError(err)
+		a.Equal(int64(1080000), rate.GetStoredValue())
+		a.Equal(rate, NewExchangeRateFromStore(EUR, USD, 1080000))
+		a.Equal("1 EUR = 1.08 USD", rate.String())
+	})
+
+	t.Run("should parse currency codes", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		for _, cc := range []CurrencyCode{USD, EUR, JPY} {
+			parsed, err := ParseCurrencyCode(cc.String())
+			a.NoError(err)
+			a.Equal(cc, parsed)
+		}
+
+		_, err := ParseCurrencyCode("GBP")
+		a.ErrorIs(err, ErrCurrency)
+	})
+}
+
+func TestParseCurrency(t *testing.T) {
+	type MockTable struct {
+		should        string
+		input         string
+		code          CurrencyCode
+		expected      Currency
+		expectedError error
+	}
+
+	table := []MockTable{
+		{
+			should:   "parse a plain amount",
+			input:    "1234.5",
+			code:     USD,
+			expected: NewCurrency("1234.50", USD),
+		},
+		{
+			should:   "parse symbols and thousands separators",
+			input:    "$1,234.50",
+			code:     USD,
+			expected: NewCurrency("1234.50", USD),
+		},
+		{
+			should:   "parse a trailing currency code",
+			input:    "1234.5 USD",
+			code:     USD,
+			expected: NewCurrency("1234.50", USD),
+		},
+		{
+			should:   "parse a leading lowercase currency code",
+			input:    "usd 12",
+			code:     USD,
+			expected: NewCurrency("12", USD),
+		},
+		{
+			should:   "parse accounting negatives",
+			input:    "(12.00)",
+			code:     USD,
+			expected: NewCurrency("-12", USD),
+		},
+		{
+			should:   "parse accounting negatives with a symbol",
+			input:    "($1,000)",
+			code:     USD,
+			expected: NewCurrency("-1000", USD),
+		},
+		{
+			should:   "parse a leading plus",
+			input:    "+12.34",
+			code:     USD,
+			expected: NewCurrency("12.34", USD),
+		},
+		{
+			should:   "parse negative cents",
+			input:    "-0.50",
+			code:     USD,
+			expected: NewCurrencyFromStore(-50, USD),
+		},
+		{
+			should:   "parse a minus before the symbol",
+			input:    "-$.5",
+			code:     USD,
+			expected: NewCurrencyFromStore(-50, USD),
+		},
+		{
+			should:   "parse a minus after the symbol",
+			input:    "$-1,000.01",
+			code:     USD,
+			expected: NewCurrencyFromStore(-100001, USD),
+		},
+		{
+			should:   "ignore surrounding whitespace",
+			input:    "  $ 12.30 \t",
+			code:     USD,
+			expected: NewCurrency("12.30", USD),
+		},
+		{
+			should:   "parse euros with dots and a decimal comma",
+			input:    "€1.234,56",
+			code:     EUR,
+			expected: NewCurrency("1234.56", EUR),
+		},
+		{
+			should:   "parse a trailing euro symbol",
+			input:    "12,5 €",
+			code:     EUR,
+			expected: NewCurrency("12.50", EUR),
+		},
+		{
+			should:   "parse yen with thousands separators",
+			input:    "¥1,234,567",
+			code:     JPY,
+			expected: NewCurrency("1234567", JPY),
+		},
+		{
+			should:        "error on empty input",
+			input:         "   ",
+			code:          USD,
+			expectedError: ErrCurrencyEmpty,
+		},
+		{
+			should:        "error on another currency's symbol",
+			input:         "€12",
+			code:          USD,
+			expectedError: ErrCurrencySymbol,
+		},
+		{
+			should:        "error on another currency's code",
+			input:         "12 JPY",
+			code:          EUR,
+			expectedError: ErrCurrencySymbol,
+		},
+		{
+			should:        "error on misplaced thousands separators",
+			input:         "1,23,456",
+			code:          USD,
+			expectedError: ErrCurrencyGrouping,
+		},
+		{
+			should:        "error on the wrong decimal separator for euros",
+			input:         "1234.50",
+			code:          EUR,
+			expectedError: ErrCurrencyGrouping,
+		},
+		{
+			should:        "error on more than one sign",
+			input:         "--12",
+			code:          USD,
+			expectedError: ErrCurrencySign,
+		},
+		{
+			should:        "error on a sign inside accounting negatives",
+			input:         "(-12)",
+			code:          USD,
+			expectedError: ErrCurrencySign,
+		},
+		{
+			should:        "error on unbalanced parentheses",
+			input:         "(12",
+			code:          USD,
+			expectedError: ErrCurrencyAmount,
+		},
+		{
+			should:        "error on letters",
+			input:         "12abc",
+			code:          USD,
+			expectedError: ErrCurrencyAmount,
+		},
+		{
+			should:        "error on a lone decimal separator",
+			input:         "$.",
+			code:          USD,
+			expectedError: ErrCurrencyAmount,
+		},
+		{
+			should:        "error on too many decimals",
+			input:         "$1.234",
+			code:          USD,
+			expectedError: ErrCurrencyPrecision,
+		},
+		{
+			should:        "error on yen with decimals",
+			input:         "¥12.5",
+			code:          JPY,
+			expectedError: ErrCurrencyPrecision,
+		},
+		{
+			should:        "error on unsupported currencies",
+			input:         "12",
+			code:          CurrencyCode(99),
+			expectedError: ErrCurrency,
+		},
+	}
+
+	for _, mock := range table {
+		t.Run("should "+mock.should, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			c, err := ParseCurrency(mock.input, mock.code)
+			if mock.expectedError != nil {
+				a.ErrorIs(err, mock.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			a.Equal(mock.expected, c)
+		})
+	}
+
+	t.Run("should parse what String writes", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		for _, c := range []Currency{
+			NewCurrency("-1234567.89", USD),
+			NewCurrency("1234567.89", EUR),
+			NewCurrency("-1234567", JPY),
+			NewCurrency("0.05", EUR),
+		} {
+			parsed, err := ParseCurrency(c.String(), c.GetCode())
+			a.NoError(err)
+			a.Equal(c, parsed)
+		}
+	})
+
+	t.Run("should subtract negative amounts", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		c := NewCurrency("1", USD)
+		a.NoError(c.Subtract("-0.50"))
+		a.Equal("$1.50", c.String())
+	})
+}
+
+func TestAllocate(t *testing.T) {
+	type MockTable struct {
+		should   string
+		amount   Currency
+		ratios   []int
+		expected []Currency
+	}
+
+	table := []MockTable{
+		{
+			should: "split a bill three ways without losing a cent",
+			amount: NewCurrency("100", USD),
+			ratios: []int{1, 1, 1},
+			expected: []Currency{
+				NewCurrency("33.34", USD),
+				NewCurrency("33.33", USD),
+				NewCurrency("33.33", USD),
+			},
+		},
+		{
+			should: "give leftovers to the parts that lost the most",
+			amount: NewCurrency("0.07", USD),
+			ratios: []int{20, 80},
+			expected: []Currency{
+				NewCurrency("0.01", USD),
+				NewCurrency("0.06", USD),
+			},
+		},
+		{
+			should: "give tied leftovers to the earlier parts",
+			amount: NewCurrency("0.05", USD),
+			ratios: []int{70, 20, 10},
+			expected: []Currency{
+				NewCurrency("0.04", USD),
+				NewCurrency("0.01", USD),
+				NewCurrency("0", USD),
+			},
+		},
+		{
+			should: "skip parts with a zero ratio",
+			amount: NewCurrency("10", JPY),
+			ratios: []int{0, 1, 2},
+			expected: []Currency{
+				NewCurrency("0", JPY),
+				NewCurrency("3", JPY),
+				NewCurrency("7", JPY),
+			},
+		},
+		{
+			should: "allocate negative amounts",
+			amount: NewCurrency("-100", EUR),
+			ratios: []int{1, 1, 1},
+			expected: []Currency{
+				NewCurrency("-33.34", EUR),
+				NewCurrency("-33.33", EUR),
+				NewCurrency("-33.33", EUR),
+			},
+		},
+	}
+
+	for _, mock := range table {
+		t.Run("should "+mock.should, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			parts := mock.amount.Allocate(mock.ratios...)
+			a.Equal(mock.expected, parts)
+
+			total := NewCurrency("", mock.amount.GetCode())
+			total.AddCurrency(parts...)
+			a.Equal(mock.amount, total)
+		})
+	}
+
+	t.Run("should split evenly", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		a.Equal([]Currency{
+			NewCurrency("3.34", USD),
+			NewCurrency("3.33", USD),
+			NewCurrency("3.33", USD),
+		}, NewCurrency("10", USD).Split(3))
+		a.Equal([]Currency{NewCurrency("5", JPY)}, NewCurrency("5", JPY).Split(1))
+	})
+
+	t.Run("should panic on invalid ratios", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		c := NewCurrency("10", USD)
+
+		a.PanicsWithError(ErrCurrencyRatio.Error(), func() { c.Allocate() })
+		a.PanicsWithError(ErrCurrencyRatio.Error(), func() { c.Allocate(0, 0) })
+		a.PanicsWithError(ErrCurrencyRatio.Error(), func() { c.Allocate(2, -1) })
+		a.PanicsWithError(ErrCurrencyRatio.Error(), func() { c.Split(0) })
+	})
+
+	t.Run("should take percentages in basis points", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		c := NewCurrency("100.10", USD)
+
+		a.Equal(int64(1001), c.GetPercentage(10))
+		a.Equal(NewCurrency("12.51", USD), c.GetBasisPoints(1250))
+		a.Equal(NewCurrency("-0.01", USD), NewCurrency("-0.05", USD).GetBasisPoints(1000))
+	})
+
+	t.Run("should average without floats", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		a.Equal(
+			NewCurrency("33.33", USD),
+			CalcAvg(NewCurrency("33.33", USD), NewCurrency("33.33", USD), NewCurrency("33.34", USD)),
+		)
+		a.Equal(NewCurrency("-2", JPY), CalcAvg(NewCurrency("-1", JPY), NewCurrency("-2", JPY)))
+	})
+}
+
+func TestCheckedArithmetic(t *testing.T) {
+	maxUSD := NewCurrencyFromStore(math.MaxInt64, USD)
+	minUSD := NewCurrencyFromStore(math.MinInt64, USD)
+	one := NewCurrencyFromStore(1, USD)
+
+	t.Run("should add, subtract and multiply", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		sum, err := NewCurrency("1.50", USD).CheckedAdd(NewCurrency("2.25", USD))
+		r.NoError(err)
+		a.Equal(NewCurrency("3.75", USD), sum)
+
+		diff, err := NewCurrency("1.50", USD).CheckedSub(NewCurrency("2.25", USD))
+		r.NoError(err)
+		a.Equal(NewCurrency("-0.75", USD), diff)
+
+		product, err := NewCurrency("1.50", USD).CheckedMul(-3)
+		r.NoError(err)
+		a.Equal(NewCurrency("-4.50", USD), product)
+	})
+
+	t.Run("should error on overflow", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		_, err := maxUSD.CheckedAdd(one)
+		a.ErrorIs(err, ErrCurrencyOverflow)
+		_, err = minUSD.CheckedSub(one)
+		a.ErrorIs(err, ErrCurrencyOverflow)
+		_, err = maxUSD.CheckedMul(2)
+		a.ErrorIs(err, ErrCurrencyOverflow)
+		_, err = minUSD.CheckedMul(-1)
+		a.ErrorIs(err, ErrCurrencyOverflow)
+
+		c := maxUSD
+		a.ErrorIs(c.Add("0.01"), ErrCurrencyOverflow)
+		c = minUSD
+		a.ErrorIs(c.Subtract("0.01"), ErrCurrencyOverflow)
+		a.ErrorIs(c.Set("99999999999999999999"), ErrCurrencyOverflow)
+		a.Equal(minUSD, c)
+
+		_, err = ParseCurrency("$99,999,999,999,999,999.99", USD)
+		a.ErrorIs(err, ErrCurrencyOverflow)
+	})
+
+	t.Run("should panic on overflow without an error to return", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		c := maxUSD
+		a.PanicsWithError(ErrCurrencyOverflow.Error(), func() { c.AddCurrency(one) })
+		c = minUSD
+		a.PanicsWithError(ErrCurrencyOverflow.Error(), func() { c.SubtractCurrency(one) })
+		a.PanicsWithError(ErrCurrencyOverflow.Error(), func() { minUSD.Negate() })
+		a.PanicsWithError(ErrCurrencyOverflow.Error(), func() { minUSD.Abs() })
+	})
+
+	t.Run("should error on different currencies", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		_, err := one.CheckedAdd(NewCurrency("1", EUR))
+		a.ErrorIs(err, ErrCurrencyKind)
+		_, err = one.CheckedSub(NewCurrency("1", EUR))
+		a.ErrorIs(err, ErrCurrencyKind)
+		a.PanicsWithError(ErrCurrencyKind.Error(), func() { one.Compare(NewCurrency("1", EUR)) })
+	})
+
+	t.Run("should compare amounts", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		low := NewCurrency("-5", EUR)
+		high := NewCurrency("5", EUR)
+
+		a.Equal(-1, low.Compare(high))
+		a.Equal(0, low.Compare(NewCurrency("-5", EUR)))
+		a.Equal(1, high.Compare(low))
+		a.Equal(high, low.Max(high))
+		a.Equal(low, low.Min(high))
+		a.Equal(high, low.Abs())
+		a.Equal(high, high.Abs())
+		a.Equal(low, high.Negate())
+		a.True(low.IsNegative())
+		a.False(high.IsNegative())
+		a.True(NewCurrency("", JPY).IsZero())
+		a.False(high.IsZero())
+	})
+
+	t.Run("should format the largest amounts", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		a.Equal("$92,233,720,368,547,758.07", maxUSD.String())
+		a.Equal("-$92,233,720,368,547,758.08", minUSD.String())
+	})
+
+	t.Run("should average and allocate the largest amounts", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		a.Equal(maxUSD, CalcAvg(maxUSD, maxUSD))
+		a.Equal([]Currency{minUSD}, minUSD.Split(1))
+
+		parts := maxUSD.Allocate(1, 1)
+		a.Equal(NewCurrencyFromStore(math.MaxInt64/2+1, USD), parts[0])
+		a.Equal(NewCurrencyFromStore(math.MaxInt64/2, USD), parts[1])
+	})
+}
+
+func TestCurrencyEncoding(t *testing.T) {
+	t.Run("should scan amounts while keeping the code", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		c := NewCurrency("", EUR)
+		r.NoError(c.Scan(int64(123456)))
+		a.Equal(NewCurrency("1234.56", EUR), c)
+
+		value, err := c.Value()
+		r.NoError(err)
+		a.Equal(int64(123456), value)
+
+		a.ErrorIs(c.Scan(nil), ErrCurrencyAmount)
+		a.ErrorIs(c.Scan("12"), ErrCurrencyAmount)
+	})
+
+	t.Run("should scan nullable amounts", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		nc := NullCurrency{Currency: NewCurrency("", JPY)}
+		r.NoError(nc.Scan(int64(500)))
+		a.True(nc.Valid)
+		a.Equal(NewCurrency("500", JPY), *nc.Ptr())
+
+		value, err := nc.Value()
+		r.NoError(err)
+		a.Equal(int64(500), value)
+
+		r.NoError(nc.Scan(nil))
+		a.False(nc.Valid)
+		a.Nil(nc.Ptr())
+		a.Equal(JPY, nc.Currency.GetCode())
+
+		value, err = nc.Value()
+		r.NoError(err)
+		a.Nil(value)
+
+		a.Equal(NullCurrency{}, NewNullCurrency(nil))
+		c := NewCurrency("1", USD)
+		a.Equal(NullCurrency{Currency: c, Valid: true}, NewNullCurrency(&c))
+	})
+
+	t.Run("should marshal amounts with their code", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		for c, expected := range map[Currency]string{
+			NewCurrency("1234.56", EUR): `{"amount":"1234.56","code":"EUR"}`,
+			NewCurrency("-0.05", USD):   `{"amount":"-0.05","code":"USD"}`,
+			NewCurrency("1234", JPY):    `{"amount":"1234","code":"JPY"}`,
+		} {
+			data, err := json.Marshal(c)
+			r.NoError(err)
+			a.JSONEq(expected, string(data))
+
+			var unmarshaled Currency
+			r.NoError(json.Unmarshal(data, &unmarshaled))
+			a.Equal(c, unmarshaled)
+		}
+
+		_, err := json.Marshal(Currency{code: CurrencyCode(99)})
+		a.ErrorIs(err, ErrCurrency)
+	})
+
+	t.Run("should error on invalid json", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		var c Currency
+		a.ErrorIs(json.Unmarshal([]byte(`{"amount":"1","code":"GBP"}`), &c), ErrCurrency)
+		a.ErrorIs(json.Unmarshal([]byte(`{"amount":"1.5","code":"JPY"}`), &c), ErrCurrencyPrecision)
+		a.ErrorIs(json.Unmarshal([]byte(`{"amount":"","code":"USD"}`), &c), ErrUSDDollar)
+		a.Error(json.Unmarshal([]byte(`12`), &c))
+	})
+
+	t.Run("should marshal nullable amounts", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		type record struct {
+			Limit NullCurrency `json:"limit"`
+		}
+
+		data, err := json.Marshal(record{})
+		r.NoError(err)
+		a.JSONEq(`{"limit":null}`, string(data))
+
+		c := NewCurrency("10", USD)
+		data, err = json.Marshal(record{Limit: NewNullCurrency(&c)})
+		r.NoError(err)
+		a.JSONEq(`{"limit":{"amount":"10.00","code":"USD"}}`, string(data))
+
+		var unmarshaled record
+		r.NoError(json.Unmarshal(data, &unmarshaled))
+		a.Equal(NewNullCurrency(&c), unmarshaled.Limit)
+
+		r.NoError(json.Unmarshal([]byte(`{"limit":null}`), &unmarshaled))
+		a.False(unmarshaled.Limit.Valid)
+	})
+}
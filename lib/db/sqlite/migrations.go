@@ -0,0 +1,177 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed sql/migrations/*.sqlite
+var migrationFiles embed.FS
+
+var (
+	ErrSchemaTooNew    = fmt.Errorf("database schema is newer than this version of billbank")
+	ErrMigrationName   = fmt.Errorf("migration file name must start with a version number")
+	ErrMigrationOrder  = fmt.Errorf("migration versions must be sequential starting at 1")
+	ErrMigrationFailed = fmt.Errorf("failed to apply migration")
+)
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+/*
+loadMigrations reads every embedded migration file and returns them sorted
+by version. Migration files are named "<version>_<description>.sqlite",
+where the version is the value stored in "PRAGMA user_version" once that
+migration has been applied.
+*/
+func loadMigrations() ([]migration, error) {
+	files, err := fs.Glob(migrationFiles, "sql/migrations/*.sqlite")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migration, 0, len(files))
+	for _, file := range files {
+		name := path.Base(file)
+		prefix, _, found := strings.Cut(name, "_")
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrMigrationName, name)
+		}
+
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrMigrationName, name)
+		}
+
+		data, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{version, name, string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("%w: %s", ErrMigrationOrder, m.name)
+		}
+	}
+
+	return migrations, nil
+}
+
+/*
+migrate brings the database up to the latest version in migrations. Each
+migration is applied inside its own transaction along with the new
+user_version, so a failed migration leaves the database at the last
+version that succeeded.
+
+Foreign keys are disabled while migrating so that tables can be rebuilt,
+which is the only way sqlite allows constraints to be changed. Any foreign
+key violations left behind by a migration cause it to be rolled back.
+*/
+func migrate(db *sql.DB, migrations []migration) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	version, err := schemaVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	latest := len(migrations)
+	if version > latest {
+		return fmt.Errorf(
+			"%w: database is at v%d, but the latest supported is v%d",
+			ErrSchemaTooNew,
+			version,
+			latest,
+		)
+	}
+
+	if version == latest {
+		return nil
+	}
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF;"); err != nil {
+		return err
+	}
+
+	for _, m := range migrations[version:] {
+		if err := applyMigration(ctx, conn, m); err != nil {
+			return fmt.Errorf("%w %s: %w", ErrMigrationFailed, m.name, err)
+		}
+	}
+
+	_, err = conn.ExecContext(ctx, "PRAGMA foreign_keys = ON;")
+	return err
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return err
+	}
+
+	// PRAGMA statements do not accept bound parameters
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d;", m.version)); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, "PRAGMA foreign_key_check;")
+	if err != nil {
+		return err
+	}
+	hasViolations := rows.Next()
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if hasViolations {
+		return ErrForeignKey
+	}
+
+	return tx.Commit()
+}
+
+func schemaVersion(ctx context.Context, conn *sql.Conn) (int, error) {
+	var version int
+	err := conn.QueryRowContext(ctx, "PRAGMA user_version;").Scan(&version)
+	return version, err
+}
+
+/*
+SchemaVersion returns the migration version the database is currently at.
+*/
+func (sdb SqliteDb) SchemaVersion() (int, error) {
+	ctx := context.Background()
+	conn, err := sdb.handle.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return schemaVersion(ctx, conn)
+}
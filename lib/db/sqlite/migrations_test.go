@@ -0,0 +1,302 @@
+package sqlite
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaeiya/billbank/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrations(t *testing.T) {
+	t.Run("should load migrations in sequential order", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		migrations, err := loadMigrations()
+		r.NoError(err)
+		r.NotEmpty(migrations)
+
+		for i, m := range migrations {
+			a.Equal(i+1, m.version)
+			a.NotEmpty(m.sql)
+		}
+	})
+
+	t.Run("should migrate a new database to the latest version", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		migrations, err := loadMigrations()
+		r.NoError(err)
+
+		db := newMockDb(t)
+
+		version, err := db.SchemaVersion()
+		r.NoError(err)
+		a.Equal(len(migrations), version)
+	})
+
+	t.Run("should upgrade a v1 database to the latest version", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "mock.db")
+
+		migrations, err := loadMigrations()
+		r.NoError(err)
+
+		fixture := openRawDb(t, dbPath)
+		r.NoError(migrate(fixture, migrations[:1]))
+		_, err = fixture.Exec(
+			"INSERT INTO bills (name, amount, due_day, period) VALUES ('rent', 150000, 1, 'monthly')",
+		)
+		r.NoError(err)
+		r.NoError(fixture.Close())
+
+		db, err := NewSqliteDb(dbPath, lib.USD)
+		r.NoError(err)
+		defer db.Close()
+
+		version, err := db.SchemaVersion()
+		r.NoError(err)
+		a.Equal(len(migrations), version)
+
+		bills, err := db.QueryBills(QueryMap{})
+		r.NoError(err)
+		r.Len(bills, 1)
+		a.Equal("rent", bills[0].Name)
+		a.Equal(lib.NewCurrency("1500", lib.USD), bills[0].Amount)
+	})
+
+	t.Run("should leave yearly bills created before bill periods without an anchor", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		dbPath := filepath.Join(t.TempDir(), "mock.db")
+
+		migrations, err := loadMigrations()
+		r.NoError(err)
+
+		fixture := openRawDb(t, dbPath)
+		r.NoError(migrate(fixture, migrations[:2]))
+		_, err = fixture.Exec(
+			"INSERT INTO bills (name, amount, due_day, period) VALUES ('domain', 1200, 1, 'yearly')",
+		)
+		r.NoError(err)
+		r.NoError(fixture.Close())
+
+		db, err := NewSqliteDb(dbPath, lib.USD)
+		r.NoError(err)
+		defer db.Close()
+
+		bills, err := db.QueryBills(QueryMap{})
+		r.NoError(err)
+		r.Len(bills, 1)
+		a.True(bills[0].AnchorMonth.IsZero())
+
+		missing, err := db.QueryBillsWithoutAnchor()
+		r.NoError(err)
+		a.Equal(bills, missing)
+
+		// The rest of the month still rolls over without the bill
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+		may := time.Date(2024, time.May, 1, 0, 0, 0, 0, time.Local)
+		_, err = db.StartNewMonth(may)
+		r.NoError(err)
+
+		_, err = db.QueryBillHistory(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+		accounts, err := db.QueryBankAccountHistory(QueryMap{})
+		r.NoError(err)
+		a.Len(accounts, 1)
+
+		bills[0].AnchorMonth = may
+		r.NoError(db.UpdateBill(bills[0].ID, bills[0].BillsConfig))
+		_, err = db.QueryBillsWithoutAnchor()
+		a.ErrorIs(err, ErrNoRows)
+
+		_, err = db.StartNewMonth(may)
+		r.NoError(err)
+
+		history, err := db.QueryBillHistory(QueryMap{})
+		r.NoError(err)
+		a.Len(history, 1)
+	})
+
+	t.Run("should merge months that were created more than once", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		dbPath := filepath.Join(t.TempDir(), "mock.db")
+
+		migrations, err := loadMigrations()
+		r.NoError(err)
+
+		fixture := openRawDb(t, dbPath)
+		r.NoError(migrate(fixture, migrations[:7]))
+		for _, query := range []string{
+			"INSERT INTO months (year, month) VALUES (2024, 5), (2024, 6), (2024, 5)",
+			"INSERT INTO bank (id, currency_code, current_month_id) VALUES (1, 'USD', 3)",
+			"INSERT INTO bank_accounts (name) VALUES ('checking')",
+			// Month 2 had two rows before any month was copied
+			`INSERT INTO bank_account_history (account_id, month_id, balance)
+			 VALUES (1, 1, 100), (1, 3, 200), (1, 2, 300), (1, 2, 400)`,
+			`INSERT INTO transfers (history_id, month_id, name, amount, due_day, transfer_type)
+			 VALUES (1, 1, 'rent', 1000, 1, 'withdrawal'), (2, 3, 'paycheck', 5000, 15, 'deposit')`,
+			"INSERT INTO income (name, amount, period) VALUES ('salary', 5000, 'monthly')",
+			"INSERT INTO income_history (income_id, month_id, amount) VALUES (1, 3, 5000), (1, 1, 4000)",
+			"INSERT INTO income_affixes (history_id, name, amount) VALUES (2, 'bonus', 100)",
+			"INSERT INTO bills (name, amount, due_day, period) VALUES ('rent', 1000, 1, 'monthly')",
+			`INSERT INTO bill_history (bill_id, month_id, amount, due_day, paid_amount, paid_date, notes)
+			 VALUES (1, 1, 1000, 1, 1000, NULL, 'autopay'), (1, 3, 500, 1, 0, '2024-05-03', 'late fee')`,
+			"INSERT INTO credit_cards (name, due_day, last_four_digits) VALUES ('travel', 5, '1234')",
+			`INSERT INTO credit_card_history
+			 (card_id, month_id, balance, credit_limit, paid_amount, paid_day, due_day, period)
+			 VALUES (1, 3, 50, NULL, 0, NULL, 5, 'monthly'), (1, 1, 60, 100000, 60, 4, 5, 'monthly')`,
+			`INSERT INTO exchange_rates (month_id, from_code, to_code, rate)
+			 VALUES (1, 'USD', 'EUR', 900000), (3, 'USD', 'EUR', 950000), (3, 'USD', 'JPY', 150000000)`,
+		} {
+			_, err = fixture.Exec(query)
+			r.NoError(err, query)
+		}
+		r.NoError(fixture.Close())
+
+		db, err := NewSqliteDb(dbPath, lib.USD)
+		r.NoError(err)
+		defer db.Close()
+
+		months, err := db.QueryMonths(QueryMap{})
+		r.NoError(err)
+		a.Equal([]MonthRecord{{ID: 1, Year: 2024, Month: 5}, {ID: 2, Year: 2024, Month: 6}}, months)
+
+		current, err := db.GetCurrentMonth()
+		r.NoError(err)
+		a.Equal(1, current.ID)
+
+		// History in the copies is merged into the oldest row of the month,
+		// which adds up the amounts and keeps everything that was set
+		history, err := db.QueryBankAccountHistory(QueryMap{})
+		r.NoError(err)
+		r.Len(history, 3)
+		a.Equal(BankHistoryRecord{
+			ID:            1,
+			BankAccountID: 1,
+			MonthID:       1,
+			Balance:       lib.NewCurrency("3", lib.USD),
+		}, history[0])
+		a.Equal(lib.NewCurrency("3", lib.USD), history[1].Balance)
+		a.Equal(lib.NewCurrency("4", lib.USD), history[2].Balance)
+
+		transfers, err := db.QueryTransfers(QueryMap{})
+		r.NoError(err)
+		r.Len(transfers, 2)
+		a.Equal(1, transfers[0].HistoryID)
+		a.Equal(1, transfers[1].HistoryID)
+		a.Equal(1, transfers[1].MonthID)
+
+		incomeHistory, err := db.QueryIncomeHistory(QueryMap{})
+		r.NoError(err)
+		r.Len(incomeHistory, 1)
+		a.Equal(1, incomeHistory[0].MonthID)
+		a.Equal(lib.NewCurrency("90", lib.USD), incomeHistory[0].Amount)
+
+		affixes, err := db.QueryAffixIncome(QueryMap{})
+		r.NoError(err)
+		r.Len(affixes, 1)
+		a.Equal(incomeHistory[0].ID, affixes[0].IncomeHistoryID)
+
+		bills, err := db.QueryBillHistory(QueryMap{})
+		r.NoError(err)
+		r.Len(bills, 1)
+		a.Equal(lib.NewCurrency("15", lib.USD), bills[0].Amount)
+		a.Equal(lib.NewPointer(lib.NewCurrency("10", lib.USD)), bills[0].PaidAmount)
+		a.Equal(lib.NewPointer("2024-05-03"), bills[0].PaidDate)
+		a.Equal(lib.NewPointer("autopay\nlate fee"), bills[0].Notes)
+
+		cards, err := db.QueryCreditCardHistory(QueryMap{})
+		r.NoError(err)
+		r.Len(cards, 1)
+		a.Equal(lib.NewCurrency("1.10", lib.USD), cards[0].Balance)
+		a.Equal(lib.NewCurrency("0.60", lib.USD), cards[0].PaidAmount)
+		a.Equal(lib.NewPointer(lib.NewCurrency("1000", lib.USD)), cards[0].CreditLimit)
+		a.Equal(lib.NewPointer(4), cards[0].PaidDay)
+
+		rates, err := db.QueryExchangeRates(QueryMap{WHERE_MONTH_ID: 1})
+		r.NoError(err)
+		r.Len(rates, 2)
+		a.Equal(1, rates[0].ID, "the rate of the oldest month id should win")
+		a.Equal(3, rates[1].ID)
+
+		a.ErrorIs(db.CreateMonth(time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)), ErrDuplicateMonth)
+	})
+
+	t.Run("should refuse a database newer than the binary", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		dir := t.TempDir()
+
+		migrations, err := loadMigrations()
+		r.NoError(err)
+
+		db := openRawDb(t, filepath.Join(dir, "mock.db"))
+		defer db.Close()
+
+		r.NoError(migrate(db, migrations))
+		_, err = db.Exec("PRAGMA user_version = 9999;")
+		r.NoError(err)
+
+		a.ErrorIs(migrate(db, migrations), ErrSchemaTooNew)
+		r.NoError(db.Close())
+
+		_, err = NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
+		a.ErrorIs(err, ErrSchemaTooNew)
+	})
+
+	t.Run("should roll back a failed migration", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		dir := t.TempDir()
+
+		migrations, err := loadMigrations()
+		r.NoError(err)
+
+		db := openRawDb(t, filepath.Join(dir, "mock.db"))
+		defer db.Close()
+
+		latest := len(migrations)
+		broken := append(migrations, migration{
+			version: latest + 1,
+			name:    "broken.sqlite",
+			sql: `CREATE TABLE half_done (id INTEGER PRIMARY KEY);
+				  INSERT INTO missing_table VALUES (1);`,
+		})
+
+		err = migrate(db, broken)
+		a.ErrorIs(err, ErrMigrationFailed)
+
+		var version int
+		r.NoError(db.QueryRow("PRAGMA user_version;").Scan(&version))
+		a.Equal(latest, version)
+
+		var tableCount int
+		r.NoError(db.QueryRow(
+			"SELECT COUNT(*) FROM sqlite_master WHERE name = 'half_done'",
+		).Scan(&tableCount))
+		a.Zero(tableCount)
+	})
+}
+
+func openRawDb(t *testing.T, filePath string) *sql.DB {
+	db, err := sql.Open("sqlite", filePath)
+	require.NoError(t, err)
+	return db
+}
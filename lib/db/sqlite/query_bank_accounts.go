@@ -1,6 +1,7 @@
 package sqlite
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/jaeiya/billbank/lib"
@@ -16,16 +17,18 @@ const (
 
 type BankAccountConfig struct {
 	Name          string
-	Password      *string
 	AccountNumber *string
 	Notes         *string
+	// The currency the account is kept in. Defaults to the base currency.
+	CurrencyCode *lib.CurrencyCode
 }
 
 type BankRecord struct {
 	ID            int
 	Name          string
-	AccountNumber *string
+	AccountNumber *lib.Secret
 	Notes         *string
+	CurrencyCode  lib.CurrencyCode
 }
 
 type BankHistoryRecord struct {
@@ -58,142 +61,374 @@ type TransferRecord struct {
 	ID int
 }
 
-func (sdb SqliteDb) CreateBankAccount(config BankAccountConfig) {
-	if _, err := sdb.handle.Exec(
-		sdb.InsertInto(
-			BANK_ACCOUNTS,
-			config.Name,
-			lib.EncryptNonNil(config.AccountNumber, config.Password),
-			lib.EncryptNonNil(config.Notes, config.Password),
-		),
+func (sdb SqliteDb) CreateBankAccount(config BankAccountConfig) error {
+	if err := sdb.insertEncrypted(
+		BANK_ACCOUNTS,
+		map[string]*string{
+			"account_number": config.AccountNumber,
+			"notes":          config.Notes,
+		},
+		config.Name,
+		nil, // account number
+		nil, // notes
+		sdb.currencyOrBase(config.CurrencyCode),
 	); err != nil {
-		panicOnExecErr(err)
+		return fmt.Errorf("cannot create bank account %q: %w", config.Name, err)
 	}
+	return nil
 }
 
-func (sdb SqliteDb) QueryBankAccounts(qm QueryMap, password *string) ([]BankRecord, error) {
-	rows := sdb.query(BANK_ACCOUNTS, qm)
-	var records []BankRecord
+/*
+QueryBankAccounts returns the bank accounts matching qm. Account numbers and
+notes can only be decrypted while the database is unlocked, so an account
+that has either returns lib.ErrVaultLocked while it's locked.
+*/
+func (sdb SqliteDb) QueryBankAccounts(qm QueryMap, opts ...QueryOption) ([]BankRecord, error) {
+	records, err := allRows(sdb, BANK_ACCOUNTS, qm, opts, sdb.scanBankAccount)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query bank accounts: %w", err)
+	}
 
-	for rows.Next() {
-		var record BankRecord
-		var err error
+	if len(records) == 0 {
+		return []BankRecord{}, fmt.Errorf("bank accounts: %w", ErrNoRows)
+	}
 
-		if err = rows.Scan(
-			&record.ID,
-			&record.Name,
-			&record.AccountNumber,
-			&record.Notes,
-		); err != nil {
-			panic(err)
-		}
+	return records, nil
+}
 
-		if password != nil && record.AccountNumber != nil {
-			if record.AccountNumber, err = lib.DecryptNonNil(record.AccountNumber, *password); err != nil {
-				panic(err)
-			}
-		}
+func (sdb SqliteDb) scanBankAccount(row scanner) (BankRecord, error) {
+	var currencyCode string
+	var accountNumber *string
+	var record BankRecord
+
+	if err := row.Scan(
+		&record.ID,
+		&record.Name,
+		&accountNumber,
+		&record.Notes,
+		&currencyCode,
+	); err != nil {
+		return BankRecord{}, fmt.Errorf("cannot scan bank account: %w", err)
+	}
 
-		if password != nil && record.Notes != nil {
-			if record.Notes, err = lib.DecryptNonNil(record.Notes, *password); err != nil {
-				panic(err)
-			}
+	var err error
+	if record.CurrencyCode, err = lib.ParseCurrencyCode(currencyCode); err != nil {
+		return BankRecord{}, fmt.Errorf("cannot scan bank account: %w", err)
+	}
+
+	if record.AccountNumber, err = sdb.decryptSecret(
+		BANK_ACCOUNTS,
+		"account_number",
+		record.ID,
+		accountNumber,
+	); err != nil {
+		return BankRecord{}, fmt.Errorf("bank account %d: %w", record.ID, err)
+	}
+
+	if record.Notes, err = sdb.decryptField(
+		BANK_ACCOUNTS,
+		"notes",
+		record.ID,
+		record.Notes,
+	); err != nil {
+		return BankRecord{}, fmt.Errorf("bank account %d: %w", record.ID, err)
+	}
+	return record, nil
+}
+
+/*
+UpdateBankAccount replaces every field of a bank account. The currency can
+only be changed before the account has any history, otherwise
+ErrCurrencyHasHistory is returned.
+*/
+func (sdb SqliteDb) UpdateBankAccount(id int, config BankAccountConfig) error {
+	accountNumber, err := sdb.encryptField(BANK_ACCOUNTS, "account_number", id, config.AccountNumber)
+	if err != nil {
+		return fmt.Errorf("cannot update bank account %d: %w", id, err)
+	}
+
+	notes, err := sdb.encryptField(BANK_ACCOUNTS, "notes", id, config.Notes)
+	if err != nil {
+		return fmt.Errorf("cannot update bank account %d: %w", id, err)
+	}
+
+	currencyCode := sdb.currencyOrBase(config.CurrencyCode)
+	if err := sdb.WithTx(func(tx SqliteDb) error {
+		if err := tx.requireCurrencyUnchanged(BANK_ACCOUNTS, id, currencyCode); err != nil {
+			return err
 		}
+		return tx.update(BANK_ACCOUNTS, id, FieldMap{
+			"name":           config.Name,
+			"account_number": accountNumber,
+			"notes":          notes,
+			"currency_code":  currencyCode,
+		})
+	}); err != nil {
+		return fmt.Errorf("cannot update bank account %d: %w", id, err)
+	}
+	return nil
+}
+
+/*
+DeleteBankAccount removes a bank account. Accounts that already have history
+are archived instead, so their history and transfers are kept and they no
+longer show up in QueryBankAccounts unless WHERE_ARCHIVED is set.
+*/
+func (sdb SqliteDb) DeleteBankAccount(id int) error {
+	if err := sdb.archiveOrDelete(BANK_ACCOUNTS, id); err != nil {
+		return fmt.Errorf("cannot delete bank account %d: %w", id, err)
+	}
+	return nil
+}
+
+func (sdb SqliteDb) CreateBankAccountHistory(config BankHistoryConfig) error {
+	if err := sdb.requireAccountCurrency(config); err != nil {
+		return fmt.Errorf(
+			"cannot create history for bank account %d: %w",
+			config.BankAccountID,
+			err,
+		)
+	}
+
+	if _, err := sdb.insert(
+		BANK_ACCOUNT_HISTORY,
+		config.BankAccountID,
+		config.MonthID,
+		config.Balance,
+	); err != nil {
+		return fmt.Errorf(
+			"cannot create history for bank account %d: %w",
+			config.BankAccountID,
+			err,
+		)
+	}
+	return nil
+}
 
-		records = append(records, record)
+func (sdb SqliteDb) QueryBankAccountHistory(
+	qm QueryMap,
+	opts ...QueryOption,
+) ([]BankHistoryRecord, error) {
+	records, err := allRows(sdb, BANK_ACCOUNT_HISTORY, qm, opts, scanBankHistory)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query bank account history: %w", err)
 	}
 
 	if len(records) == 0 {
-		return []BankRecord{}, fmt.Errorf("no bank accounts")
+		return []BankHistoryRecord{}, fmt.Errorf("bank account history: %w", ErrNoRows)
 	}
 
 	return records, nil
 }
 
-func (sdb SqliteDb) CreateBankAccountHistory(config BankHistoryConfig) {
-	if _, err := sdb.handle.Exec(
-		sdb.InsertInto(
-			BANK_ACCOUNT_HISTORY,
-			config.BankAccountID,
-			config.MonthID,
-			config.Balance.GetStoredValue(),
-		),
-	); err != nil {
-		panicOnExecErr(err)
+/*
+EachBankAccountHistory calls fn with every bank account history record
+matching qm, one at a time. See ErrStop to stop early. fn must not use the
+database.
+*/
+func (sdb SqliteDb) EachBankAccountHistory(
+	qm QueryMap,
+	fn func(BankHistoryRecord) error,
+	opts ...QueryOption,
+) error {
+	if err := eachRow(sdb, BANK_ACCOUNT_HISTORY, qm, opts, scanBankHistory, fn); err != nil {
+		return fmt.Errorf("cannot query bank account history: %w", err)
 	}
+	return nil
 }
 
-func (sdb SqliteDb) QueryBankAccountHistory(qm QueryMap) ([]BankHistoryRecord, error) {
-	rows := sdb.query(BANK_ACCOUNT_HISTORY, qm)
-	var balance int
-	var records []BankHistoryRecord
+// scanBankHistory scans balances in the currency of their account
+func scanBankHistory(row scanner) (BankHistoryRecord, error) {
+	var balance int64
+	var code string
+	var record BankHistoryRecord
 
-	for rows.Next() {
-		var record BankHistoryRecord
-		if err := rows.Scan(
-			&record.ID,
-			&record.BankAccountID,
-			&record.MonthID,
-			&balance,
-		); err != nil {
-			panic(err)
-		}
+	if err := row.Scan(
+		&record.ID,
+		&record.BankAccountID,
+		&record.MonthID,
+		&balance,
+		&code,
+	); err != nil {
+		return BankHistoryRecord{}, fmt.Errorf("cannot scan bank account history: %w", err)
+	}
 
-		record.Balance = lib.NewCurrencyFromStore(balance, sdb.currencyCode)
-		records = append(records, record)
+	cc, err := lib.ParseCurrencyCode(code)
+	if err != nil {
+		return BankHistoryRecord{}, fmt.Errorf("cannot scan bank account history: %w", err)
 	}
 
-	if len(records) == 0 {
-		return []BankHistoryRecord{}, fmt.Errorf("no query results found")
+	record.Balance = lib.NewCurrencyFromStore(balance, cc)
+	return record, nil
+}
+
+func (sdb SqliteDb) UpdateBankAccountHistory(id int, config BankHistoryConfig) error {
+	if err := sdb.requireAccountCurrency(config); err != nil {
+		return fmt.Errorf("cannot update bank account history %d: %w", id, err)
 	}
 
-	return records, nil
+	if err := sdb.update(BANK_ACCOUNT_HISTORY, id, FieldMap{
+		"account_id": config.BankAccountID,
+		"month_id":   config.MonthID,
+		"balance":    config.Balance,
+	}); err != nil {
+		return fmt.Errorf("cannot update bank account history %d: %w", id, err)
+	}
+	return nil
+}
+
+/*
+DeleteBankAccountHistory removes a bank account history record along with
+all of the transfers that were recorded against it.
+*/
+func (sdb SqliteDb) DeleteBankAccountHistory(id int) error {
+	if err := sdb.deleteByID(BANK_ACCOUNT_HISTORY, id); err != nil {
+		return fmt.Errorf("cannot delete bank account history %d: %w", id, err)
+	}
+	return nil
 }
 
-func (sdb SqliteDb) CreateTransfer(td TransferConfig) {
-	execStr := sdb.InsertInto(
+func (sdb SqliteDb) CreateTransfer(td TransferConfig) error {
+	if err := sdb.requireTransferCurrency(td); err != nil {
+		return fmt.Errorf("cannot create transfer %q: %w", td.Name, err)
+	}
+
+	if _, err := sdb.insert(
 		TRANSFERS,
 		td.HistoryID,
 		td.MonthID,
 		td.Name,
-		td.Amount.GetStoredValue(),
+		td.Amount,
 		td.DueDay,
 		td.TransferType,
 		lib.TryDeref(td.ToWhom),
 		lib.TryDeref(td.FromWhom),
-	)
-	if _, err := sdb.handle.Exec(execStr); err != nil {
-		panicOnExecErr(err)
-	}
-}
-
-func (sdb SqliteDb) QueryTransfers(qm QueryMap) ([]TransferRecord, error) {
-	rows := sdb.query(TRANSFERS, qm)
-	var amount int
-	var records []TransferRecord
-
-	for rows.Next() {
-		var record TransferRecord
-		if err := rows.Scan(
-			&record.ID,
-			&record.HistoryID,
-			&record.MonthID,
-			&record.Name,
-			&amount,
-			&record.DueDay,
-			&record.TransferType,
-			&record.ToWhom,
-			&record.FromWhom,
-		); err != nil {
-			panic(err)
-		}
-		record.Amount = lib.NewCurrencyFromStore(amount, sdb.currencyCode)
-		records = append(records, record)
+	); err != nil {
+		return fmt.Errorf("cannot create transfer %q: %w", td.Name, err)
+	}
+	return nil
+}
+
+func (sdb SqliteDb) QueryTransfers(qm QueryMap, opts ...QueryOption) ([]TransferRecord, error) {
+	records, err := allRows(sdb, TRANSFERS, qm, opts, scanTransfer)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query transfers: %w", err)
 	}
 
 	if len(records) == 0 {
-		return records, fmt.Errorf("no data found")
+		return records, fmt.Errorf("transfers: %w", ErrNoRows)
 	}
 
 	return records, nil
 }
+
+/*
+EachTransfer calls fn with every transfer matching qm, one at a time. See
+ErrStop to stop early. fn must not use the database.
+*/
+func (sdb SqliteDb) EachTransfer(
+	qm QueryMap,
+	fn func(TransferRecord) error,
+	opts ...QueryOption,
+) error {
+	if err := eachRow(sdb, TRANSFERS, qm, opts, scanTransfer, fn); err != nil {
+		return fmt.Errorf("cannot query transfers: %w", err)
+	}
+	return nil
+}
+
+// scanTransfer scans amounts in the currency of their history's account
+func scanTransfer(row scanner) (TransferRecord, error) {
+	var amount int64
+	var code string
+	var record TransferRecord
+
+	if err := row.Scan(
+		&record.ID,
+		&record.HistoryID,
+		&record.MonthID,
+		&record.Name,
+		&amount,
+		&record.DueDay,
+		&record.TransferType,
+		&record.ToWhom,
+		&record.FromWhom,
+		&code,
+	); err != nil {
+		return TransferRecord{}, fmt.Errorf("cannot scan transfer: %w", err)
+	}
+
+	cc, err := lib.ParseCurrencyCode(code)
+	if err != nil {
+		return TransferRecord{}, fmt.Errorf("cannot scan transfer: %w", err)
+	}
+
+	record.Amount = lib.NewCurrencyFromStore(amount, cc)
+	return record, nil
+}
+
+func (sdb SqliteDb) UpdateTransfer(id int, td TransferConfig) error {
+	if err := sdb.requireTransferCurrency(td); err != nil {
+		return fmt.Errorf("cannot update transfer %d: %w", id, err)
+	}
+
+	if err := sdb.update(TRANSFERS, id, FieldMap{
+		"history_id":    td.HistoryID,
+		"month_id":      td.MonthID,
+		"name":          td.Name,
+		"amount":        td.Amount,
+		"due_day":       td.DueDay,
+		"transfer_type": td.TransferType,
+		"to_whom":       lib.TryDeref(td.ToWhom),
+		"from_whom":     lib.TryDeref(td.FromWhom),
+	}); err != nil {
+		return fmt.Errorf("cannot update transfer %d: %w", id, err)
+	}
+	return nil
+}
+
+func (sdb SqliteDb) DeleteTransfer(id int) error {
+	if err := sdb.deleteByID(TRANSFERS, id); err != nil {
+		return fmt.Errorf("cannot delete transfer %d: %w", id, err)
+	}
+	return nil
+}
+
+/*
+TotalBankBalance adds up the balance of every bank account in the month,
+converted into the base currency with the month's exchange rates. Returns
+lib.ErrCurrencyOverflow if the total is too large to store.
+*/
+func (sdb SqliteDb) TotalBankBalance(monthID int) (lib.Currency, error) {
+	history, err := sdb.QueryBankAccountHistory(QueryMap{WHERE_MONTH_ID: monthID})
+	if err != nil && !errors.Is(err, ErrNoRows) {
+		return lib.Currency{}, err
+	}
+
+	balances := make([]lib.Currency, 0, len(history))
+	for _, record := range history {
+		balances = append(balances, record.Balance)
+	}
+
+	total, err := sdb.sumInBase(monthID, balances...)
+	if err != nil {
+		return lib.Currency{}, fmt.Errorf("cannot total bank balances: %w", err)
+	}
+	return total, nil
+}
+
+func (sdb SqliteDb) requireAccountCurrency(config BankHistoryConfig) error {
+	code, found, err := sdb.currencyOf(accountCurrencyQuery, config.BankAccountID)
+	if err != nil || !found {
+		return err
+	}
+	return requireCurrency(code, &config.Balance)
+}
+
+func (sdb SqliteDb) requireTransferCurrency(td TransferConfig) error {
+	code, found, err := sdb.currencyOf(bankHistoryCurrencyQuery, td.HistoryID)
+	if err != nil || !found {
+		return err
+	}
+	return requireCurrency(code, &td.Amount)
+}
@@ -2,7 +2,6 @@ package sqlite
 
 import (
 	"encoding/base64"
-	"path/filepath"
 	"regexp"
 	"testing"
 	"time"
@@ -18,6 +17,9 @@ func TestCreateBankAccount(t *testing.T) {
 		actual   []BankAccountConfig
 		expected []BankRecord
 		password *string
+		locked   bool
+		// Only checked while locked
+		expectedError error
 	}
 
 	table := []MockTable{
@@ -52,7 +54,6 @@ func TestCreateBankAccount(t *testing.T) {
 			actual: []BankAccountConfig{
 				{
 					Name:          "test",
-					Password:      lib.NewPointer("test"),
 					AccountNumber: lib.NewPointer("282841"),
 					Notes:         lib.NewPointer("some notes"),
 				},
@@ -61,7 +62,7 @@ func TestCreateBankAccount(t *testing.T) {
 				{
 					ID:            1,
 					Name:          "test",
-					AccountNumber: lib.NewPointer("282841"),
+					AccountNumber: lib.NewSecret("282841"),
 					Notes:         lib.NewPointer("some notes"),
 				},
 			},
@@ -72,7 +73,6 @@ func TestCreateBankAccount(t *testing.T) {
 			actual: []BankAccountConfig{
 				{
 					Name:          "test",
-					Password:      lib.NewPointer("test"),
 					AccountNumber: lib.NewPointer("1337420"),
 				},
 			},
@@ -80,7 +80,7 @@ func TestCreateBankAccount(t *testing.T) {
 				{
 					ID:            1,
 					Name:          "test",
-					AccountNumber: lib.NewPointer("1337420"),
+					AccountNumber: lib.NewSecret("1337420"),
 				},
 			},
 			password: lib.NewPointer("test"),
@@ -89,9 +89,8 @@ func TestCreateBankAccount(t *testing.T) {
 			should: "just save notes",
 			actual: []BankAccountConfig{
 				{
-					Name:     "test",
-					Password: lib.NewPointer("test"),
-					Notes:    lib.NewPointer("some notes"),
+					Name:  "test",
+					Notes: lib.NewPointer("some notes"),
 				},
 			},
 			expected: []BankRecord{
@@ -104,21 +103,17 @@ func TestCreateBankAccount(t *testing.T) {
 			password: lib.NewPointer("test"),
 		},
 		{
-			should: "get encoded versions of protected fields",
+			should: "refuse to read protected fields while locked",
 			actual: []BankAccountConfig{
 				{
 					Name:          "test",
-					Password:      lib.NewPointer("test"),
 					AccountNumber: lib.NewPointer("1337420"),
 					Notes:         lib.NewPointer("sevenCh"),
 				},
 			},
-			expected: []BankRecord{
-				{
-					ID:   1,
-					Name: "test",
-				},
-			},
+			password:      lib.NewPointer("test"),
+			locked:        true,
+			expectedError: lib.ErrVaultLocked,
 		},
 	}
 
@@ -127,49 +122,47 @@ func TestCreateBankAccount(t *testing.T) {
 			t.Parallel()
 			a := assert.New(t)
 			r := require.New(t)
-			dir := t.TempDir()
-
-			db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-			defer db.Close()
+			db := newMockDb(t)
+			if mock.password != nil {
+				r.NoError(db.SetPassword(*mock.password))
+			}
 
 			for _, acct := range mock.actual {
-				db.CreateBankAccount(acct)
+				r.NoError(db.CreateBankAccount(acct))
 			}
 
-			res, err := db.QueryBankAccounts(QueryMap{}, mock.password)
-			r.NoError(err)
-			r.Len(res, len(mock.expected))
-			for i, r := range res {
-				if mock.password == nil {
-					a.Equal(r.ID, mock.expected[i].ID)
-					a.Equal(r.Name, mock.expected[i].Name)
-					if r.AccountNumber != nil {
-						a.True(isProbablyBase64(*res[0].AccountNumber))
-					}
-					if r.Notes != nil {
-						a.True(isProbablyBase64(*res[0].Notes))
-					}
-					return
-				}
-				a.Equal(mock.expected, res)
+			if mock.locked {
+				db.Lock()
+
+				// The stored cipher text is never handed out in place of the fields
+				_, err := db.QueryBankAccounts(QueryMap{})
+				a.ErrorIs(err, mock.expectedError)
+
+				var accountNumber, notes string
+				r.NoError(db.handle.QueryRow(
+					"SELECT account_number, notes FROM bank_accounts WHERE id = 1",
+				).Scan(&accountNumber, &notes))
+				a.True(isProbablyBase64(accountNumber))
+				a.True(isProbablyBase64(notes))
+				return
 			}
+
+			res, err := db.QueryBankAccounts(QueryMap{})
+			r.NoError(err)
+			a.Equal(mock.expected, res)
 		})
 	}
 
-	t.Run("should error when passing nil password & sensitive data", func(t *testing.T) {
+	t.Run("should error when saving sensitive data while locked", func(t *testing.T) {
 		t.Parallel()
 		a := assert.New(t)
-		dir := t.TempDir()
-
-		db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-		defer db.Close()
+		db := newMockDb(t)
 
-		a.PanicsWithValue(lib.ErrEncryptWithoutPassword, func() {
-			db.CreateBankAccount(BankAccountConfig{
-				Name:          "Test",
-				AccountNumber: lib.NewPointer("1823842"),
-			})
+		err := db.CreateBankAccount(BankAccountConfig{
+			Name:          "Test",
+			AccountNumber: lib.NewPointer("1823842"),
 		})
+		a.ErrorIs(err, lib.ErrVaultLocked)
 	})
 }
 
@@ -289,25 +282,20 @@ func TestBankAccountHistory(t *testing.T) {
 			t.Parallel()
 			a := assert.New(t)
 			r := require.New(t)
-			dir := t.TempDir()
-
-			db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-			defer db.Close()
+			db := newMockDb(t)
 
-			db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local))
+			r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
 
 			for _, acct := range mock.accounts {
-				db.CreateBankAccount(acct)
+				r.NoError(db.CreateBankAccount(acct))
 			}
 
 			for _, history := range mock.actual {
 				if mock.expectError != nil {
-					a.PanicsWithValue(ErrForeignKey, func() {
-						db.CreateBankAccountHistory(history)
-					})
+					a.ErrorIs(db.CreateBankAccountHistory(history), ErrForeignKey)
 					return
 				}
-				db.CreateBankAccountHistory(history)
+				r.NoError(db.CreateBankAccountHistory(history))
 			}
 
 			res, err := db.QueryBankAccountHistory(QueryMap{})
@@ -395,7 +383,60 @@ func TestBankTransfers(t *testing.T) {
 			},
 		},
 		{
-			should:   "panic on foreign key constraint violations",
+			should: "keep each transfer in the currency of its account",
+			accounts: []BankAccountConfig{
+				{Name: "checking"},
+				{Name: "savings", CurrencyCode: lib.NewPointer(lib.EUR)},
+			},
+			history: []BankHistoryConfig{
+				{MonthID: 1, BankAccountID: 1},
+				{MonthID: 1, BankAccountID: 2, Balance: lib.NewCurrency("", lib.EUR)},
+			},
+			actual: []TransferConfig{
+				{
+					HistoryID:    2,
+					MonthID:      1,
+					Name:         "savings",
+					Amount:       lib.NewCurrency("40", lib.EUR),
+					DueDay:       5,
+					TransferType: DEPOSIT,
+				},
+				{
+					HistoryID:    1,
+					MonthID:      1,
+					Name:         "rent",
+					Amount:       lib.NewCurrency("1200", lib.USD),
+					DueDay:       1,
+					TransferType: WITHDRAWAL,
+				},
+			},
+			expected: []TransferRecord{
+				{
+					ID: 1,
+					TransferConfig: TransferConfig{
+						HistoryID:    2,
+						MonthID:      1,
+						Name:         "savings",
+						Amount:       lib.NewCurrency("40", lib.EUR),
+						DueDay:       5,
+						TransferType: DEPOSIT,
+					},
+				},
+				{
+					ID: 2,
+					TransferConfig: TransferConfig{
+						HistoryID:    1,
+						MonthID:      1,
+						Name:         "rent",
+						Amount:       lib.NewCurrency("1200", lib.USD),
+						DueDay:       1,
+						TransferType: WITHDRAWAL,
+					},
+				},
+			},
+		},
+		{
+			should:   "error on foreign key constraint violations",
 			accounts: []BankAccountConfig{{Name: "Test"}},
 			history: []BankHistoryConfig{
 				{MonthID: 1, BankAccountID: 1},
@@ -417,7 +458,7 @@ func TestBankTransfers(t *testing.T) {
 			expectError: ErrForeignKey,
 		},
 		{
-			should:   "panic on due date constraint violations",
+			should:   "error on due date constraint violations",
 			accounts: []BankAccountConfig{{Name: "Test"}},
 			history: []BankHistoryConfig{
 				{MonthID: 1, BankAccountID: 1},
@@ -429,7 +470,7 @@ func TestBankTransfers(t *testing.T) {
 			expectError: ErrDueDayInvalid,
 		},
 		{
-			should:   "panic on transfer type constraint violations",
+			should:   "error on transfer type constraint violations",
 			accounts: []BankAccountConfig{{Name: "Test"}},
 			history: []BankHistoryConfig{
 				{MonthID: 1, BankAccountID: 1},
@@ -447,28 +488,23 @@ func TestBankTransfers(t *testing.T) {
 			t.Parallel()
 			a := assert.New(t)
 			r := assert.New(t)
-			dir := t.TempDir()
+			db := newMockDb(t)
 
-			db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-			defer db.Close()
-
-			db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local))
+			r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
 
 			for _, acct := range mock.accounts {
-				db.CreateBankAccount(acct)
+				r.NoError(db.CreateBankAccount(acct))
 			}
 
 			for _, history := range mock.history {
-				db.CreateBankAccountHistory(history)
+				r.NoError(db.CreateBankAccountHistory(history))
 			}
 
 			for _, transfer := range mock.actual {
 				if mock.expectError != nil {
-					a.PanicsWithValue(mock.expectError, func() {
-						db.CreateTransfer(transfer)
-					})
+					a.ErrorIs(db.CreateTransfer(transfer), mock.expectError)
 				} else {
-					db.CreateTransfer(transfer)
+					r.NoError(db.CreateTransfer(transfer))
 				}
 			}
 
@@ -485,6 +521,215 @@ func TestBankTransfers(t *testing.T) {
 	}
 }
 
+func TestUpdateBankAccounts(t *testing.T) {
+	t.Run("should update a bank account and its history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+		r.NoError(db.CreateBankAccountHistory(BankHistoryConfig{
+			MonthID:       1,
+			BankAccountID: 1,
+			Balance:       lib.NewCurrency("100", lib.USD),
+		}))
+
+		r.NoError(db.SetPassword("password"))
+		r.NoError(db.UpdateBankAccount(1, BankAccountConfig{
+			Name:          "savings",
+			AccountNumber: lib.NewPointer("282841"),
+		}))
+		r.NoError(db.UpdateBankAccountHistory(1, BankHistoryConfig{
+			MonthID:       1,
+			BankAccountID: 1,
+			Balance:       lib.NewCurrency("250.50", lib.USD),
+		}))
+
+		accounts, err := db.QueryBankAccounts(QueryMap{})
+		r.NoError(err)
+		a.Equal([]BankRecord{
+			{ID: 1, Name: "savings", AccountNumber: lib.NewSecret("282841")},
+		}, accounts)
+
+		history, err := db.QueryBankAccountHistory(QueryMap{})
+		r.NoError(err)
+		a.Equal(lib.NewCurrency("250.50", lib.USD), history[0].Balance)
+	})
+
+	t.Run("should update a transfer", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+		r.NoError(db.CreateBankAccountHistory(BankHistoryConfig{MonthID: 1, BankAccountID: 1}))
+		r.NoError(db.CreateTransfer(TransferConfig{
+			HistoryID:    1,
+			MonthID:      1,
+			Name:         "paycheck",
+			Amount:       lib.NewCurrency("500", lib.USD),
+			DueDay:       1,
+			TransferType: DEPOSIT,
+			FromWhom:     lib.NewPointer("work"),
+		}))
+
+		updated := TransferConfig{
+			HistoryID:    1,
+			MonthID:      1,
+			Name:         "rent",
+			Amount:       lib.NewCurrency("1200", lib.USD),
+			DueDay:       3,
+			TransferType: WITHDRAWAL,
+			ToWhom:       lib.NewPointer("landlord"),
+		}
+		r.NoError(db.UpdateTransfer(1, updated))
+
+		transfers, err := db.QueryTransfers(QueryMap{})
+		r.NoError(err)
+		a.Equal([]TransferRecord{{ID: 1, TransferConfig: updated}}, transfers)
+
+		updated.TransferType = TransferType("refund")
+		a.ErrorIs(db.UpdateTransfer(1, updated), ErrTransferTypeInvalid)
+		a.ErrorIs(db.UpdateTransfer(2, updated), ErrNoRows)
+	})
+
+	t.Run("should only change the currency before there is history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+		yen := lib.JPY
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+		r.NoError(db.UpdateBankAccount(1, BankAccountConfig{Name: "checking", CurrencyCode: &yen}))
+		r.NoError(db.UpdateBankAccount(1, BankAccountConfig{Name: "checking"}))
+
+		r.NoError(db.CreateBankAccountHistory(BankHistoryConfig{
+			MonthID:       1,
+			BankAccountID: 1,
+			Balance:       lib.NewCurrency("100", lib.USD),
+		}))
+		err := db.UpdateBankAccount(1, BankAccountConfig{Name: "savings", CurrencyCode: &yen})
+		a.ErrorIs(err, ErrCurrencyHasHistory)
+
+		accounts, err := db.QueryBankAccounts(QueryMap{})
+		r.NoError(err)
+		a.Equal([]BankRecord{{ID: 1, Name: "checking", CurrencyCode: lib.USD}}, accounts)
+
+		history, err := db.QueryBankAccountHistory(QueryMap{})
+		r.NoError(err)
+		a.Equal(lib.NewCurrency("100", lib.USD), history[0].Balance)
+
+		// Everything else can still be changed
+		r.NoError(db.UpdateBankAccount(1, BankAccountConfig{Name: "savings"}))
+	})
+}
+
+func TestDeleteBankAccounts(t *testing.T) {
+	t.Run("should delete a bank account without history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+		r.NoError(db.DeleteBankAccount(1))
+
+		_, err := db.QueryBankAccounts(QueryMap{WHERE_ARCHIVED: true})
+		a.ErrorIs(err, ErrNoRows)
+		a.ErrorIs(db.DeleteBankAccount(1), ErrNoRows)
+	})
+
+	t.Run("should archive a bank account with history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+		r.NoError(db.CreateBankAccountHistory(BankHistoryConfig{MonthID: 1, BankAccountID: 1}))
+
+		r.NoError(db.DeleteBankAccount(1))
+
+		_, err := db.QueryBankAccounts(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+
+		archived, err := db.QueryBankAccounts(QueryMap{WHERE_ARCHIVED: true})
+		r.NoError(err)
+		a.Equal([]BankRecord{{ID: 1, Name: "checking"}}, archived)
+
+		history, err := db.QueryBankAccountHistory(QueryMap{})
+		r.NoError(err)
+		a.Len(history, 1)
+	})
+
+	t.Run("should delete bank account history along with its transfers", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+		for range 2 {
+			r.NoError(db.CreateBankAccountHistory(BankHistoryConfig{MonthID: 1, BankAccountID: 1}))
+		}
+		for historyID := 1; historyID <= 2; historyID++ {
+			r.NoError(db.CreateTransfer(TransferConfig{
+				HistoryID:    historyID,
+				MonthID:      1,
+				Name:         "transfer",
+				Amount:       lib.NewCurrency("10", lib.USD),
+				DueDay:       1,
+				TransferType: MOVE,
+			}))
+		}
+
+		r.NoError(db.DeleteBankAccountHistory(1))
+
+		history, err := db.QueryBankAccountHistory(QueryMap{})
+		r.NoError(err)
+		r.Len(history, 1)
+		a.Equal(2, history[0].ID)
+
+		transfers, err := db.QueryTransfers(QueryMap{})
+		r.NoError(err)
+		r.Len(transfers, 1)
+		a.Equal(2, transfers[0].HistoryID)
+	})
+
+	t.Run("should delete a transfer", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+		r.NoError(db.CreateBankAccountHistory(BankHistoryConfig{MonthID: 1, BankAccountID: 1}))
+		r.NoError(db.CreateTransfer(TransferConfig{
+			HistoryID:    1,
+			MonthID:      1,
+			Name:         "transfer",
+			Amount:       lib.NewCurrency("10", lib.USD),
+			DueDay:       1,
+			TransferType: MOVE,
+		}))
+
+		r.NoError(db.DeleteTransfer(1))
+
+		_, err := db.QueryTransfers(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+		a.ErrorIs(db.DeleteTransfer(1), ErrNoRows)
+	})
+}
+
 func isProbablyBase64(s string) bool {
 	re := regexp.MustCompile(`^[A-Za-z0-9+/]+={0,2}$`)
 	if !re.MatchString(s) {
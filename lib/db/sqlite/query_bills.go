@@ -1,16 +1,28 @@
 package sqlite
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jaeiya/billbank/lib"
 )
 
+var (
+	ErrPeriodInvalid  = fmt.Errorf("failed to validate period constraint")
+	ErrAnchorRequired = fmt.Errorf("period requires an anchor month")
+)
+
 type BillsConfig struct {
 	Name   string
 	Amount lib.Currency
 	DueDay int
 	Period Period
+	// Any month the bill is due in. Only monthly bills can go without one.
+	AnchorMonth time.Time
+	// How many months pass between payments of an EVERY_N_MONTHS bill
+	EveryNMonths int
 }
 
 type BillRecord struct {
@@ -33,98 +45,317 @@ type BillHistoryRecord struct {
 	BillHistoryConfig
 }
 
-func (sdb SqliteDb) CreateNewBill(cfg BillsConfig) {
-	if _, err := sdb.handle.Exec(
-		sdb.InsertInto(BILLS, cfg.Name, cfg.Amount.GetStoredValue(), cfg.DueDay, cfg.Period),
+func (sdb SqliteDb) CreateNewBill(cfg BillsConfig) error {
+	if err := cfg.validatePeriod(); err != nil {
+		return fmt.Errorf("cannot create bill %q: %w", cfg.Name, err)
+	}
+
+	if err := requireCurrency(sdb.currencyCode, &cfg.Amount); err != nil {
+		return fmt.Errorf("cannot create bill %q: %w", cfg.Name, err)
+	}
+
+	anchorYear, anchorMonth, everyNMonths := cfg.periodValues()
+	if _, err := sdb.insert(
+		BILLS,
+		cfg.Name,
+		cfg.Amount,
+		cfg.DueDay,
+		cfg.Period,
+		anchorYear,
+		anchorMonth,
+		everyNMonths,
 	); err != nil {
-		panicOnExecErr(err)
+		return fmt.Errorf("cannot create bill %q: %w", cfg.Name, err)
 	}
+	return nil
 }
 
-func (sdb SqliteDb) QueryBills(qm QueryMap) ([]BillRecord, error) {
-	rows := sdb.query(BILLS, qm)
-	var amount int
-	var records []BillRecord
-
-	for rows.Next() {
-		var record BillRecord
-		if err := rows.Scan(
-			&record.ID,
-			&record.Name,
-			&amount,
-			&record.DueDay,
-			&record.Period,
-		); err != nil {
-			panic(err)
-		}
-		record.Amount = lib.NewCurrencyFromStore(amount, sdb.currencyCode)
-		records = append(records, record)
+func (sdb SqliteDb) QueryBills(qm QueryMap, opts ...QueryOption) ([]BillRecord, error) {
+	records, err := allRows(sdb, BILLS, qm, opts, sdb.scanBill)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query bills: %w", err)
 	}
 
 	if len(records) == 0 {
-		return []BillRecord{}, fmt.Errorf("no query results")
+		return []BillRecord{}, fmt.Errorf("bills: %w", ErrNoRows)
 	}
 
 	return records, nil
 }
 
-func (sdb SqliteDb) CreateBillHistory(cfg BillHistoryConfig) {
-	paidAmount := lib.TryDeref(cfg.PaidAmount)
-	if paidAmount != nil {
-		paidAmount = cfg.PaidAmount.GetStoredValue()
-	}
+func (sdb SqliteDb) scanBill(row scanner) (BillRecord, error) {
+	var anchorYear, anchorMonth, everyNMonths sql.NullInt64
+	var record BillRecord
+	record.Amount = lib.NewCurrency("", sdb.currencyCode)
 
-	if _, err := sdb.handle.Exec(
-		sdb.InsertInto(
-			BILL_HISTORY,
-			cfg.BillID,
-			cfg.MonthID,
-			cfg.Amount.GetStoredValue(),
-			paidAmount,
-			lib.TryDeref(cfg.PaidDate),
-			cfg.DueDay,
-			lib.TryDeref(cfg.Notes),
-		),
+	if err := row.Scan(
+		&record.ID,
+		&record.Name,
+		&record.Amount,
+		&record.DueDay,
+		&record.Period,
+		&anchorYear,
+		&anchorMonth,
+		&everyNMonths,
 	); err != nil {
-		panicOnExecErr(err)
-	}
-}
-
-func (sdb SqliteDb) QueryBillHistory(qm QueryMap) ([]BillHistoryRecord, error) {
-	rows := sdb.query(BILL_HISTORY, qm)
-
-	var amount int
-	var paidAmount *int
-	var records []BillHistoryRecord
-
-	for rows.Next() {
-		var record BillHistoryRecord
-		if err := rows.Scan(
-			&record.ID,
-			&record.BillID,
-			&record.MonthID,
-			&amount,
-			&paidAmount,
-			&record.PaidDate,
-			&record.DueDay,
-			&record.Notes,
-		); err != nil {
-			panic(err)
+		return BillRecord{}, fmt.Errorf("cannot scan bill: %w", err)
+	}
+
+	if anchorYear.Valid && anchorMonth.Valid {
+		record.AnchorMonth = time.Date(
+			int(anchorYear.Int64),
+			time.Month(anchorMonth.Int64),
+			1, 0, 0, 0, 0,
+			time.Local,
+		)
+	}
+	record.EveryNMonths = int(everyNMonths.Int64)
+	return record, nil
+}
+
+func (sdb SqliteDb) UpdateBill(id int, cfg BillsConfig) error {
+	if err := cfg.validatePeriod(); err != nil {
+		return fmt.Errorf("cannot update bill %d: %w", id, err)
+	}
+
+	if err := requireCurrency(sdb.currencyCode, &cfg.Amount); err != nil {
+		return fmt.Errorf("cannot update bill %d: %w", id, err)
+	}
+
+	anchorYear, anchorMonth, everyNMonths := cfg.periodValues()
+	if err := sdb.update(BILLS, id, FieldMap{
+		"name":           cfg.Name,
+		"amount":         cfg.Amount,
+		"due_day":        cfg.DueDay,
+		"period":         cfg.Period,
+		"anchor_year":    anchorYear,
+		"anchor_month":   anchorMonth,
+		"every_n_months": everyNMonths,
+	}); err != nil {
+		return fmt.Errorf("cannot update bill %d: %w", id, err)
+	}
+	return nil
+}
+
+/*
+DeleteBill removes a bill. Bills that already have history are archived
+instead, so their history is kept and they no longer show up in QueryBills
+unless WHERE_ARCHIVED is set.
+*/
+func (sdb SqliteDb) DeleteBill(id int) error {
+	if err := sdb.archiveOrDelete(BILLS, id); err != nil {
+		return fmt.Errorf("cannot delete bill %d: %w", id, err)
+	}
+	return nil
+}
+
+/*
+QueryBillsDueIn returns the active bills that are due in the month of t.
+Returns ErrNoRows when nothing is due that month. Bills without an anchor
+month are never due, see QueryBillsWithoutAnchor.
+*/
+func (sdb SqliteDb) QueryBillsDueIn(t time.Time) ([]BillRecord, error) {
+	bills, err := sdb.QueryBills(QueryMap{})
+	if err != nil {
+		return bills, err
+	}
+
+	var due []BillRecord
+	for _, bill := range bills {
+		if bill.IsDueIn(t) {
+			due = append(due, bill)
 		}
+	}
 
-		record.Amount = lib.NewCurrencyFromStore(amount, sdb.currencyCode)
+	if len(due) == 0 {
+		return []BillRecord{}, fmt.Errorf(
+			"bills due in %d-%02d: %w",
+			t.Year(),
+			t.Month(),
+			ErrNoRows,
+		)
+	}
+	return due, nil
+}
 
-		if paidAmount != nil {
-			pa := lib.NewCurrencyFromStore(*paidAmount, sdb.currencyCode)
-			record.PaidAmount = &pa
+/*
+QueryBillsWithoutAnchor returns the active bills that need an anchor month
+but don't have one, which only bills from before bill periods can be. There's
+no telling when they're due, so they're left out of every month until
+UpdateBill gives them an anchor. Returns ErrNoRows when there are none.
+*/
+func (sdb SqliteDb) QueryBillsWithoutAnchor() ([]BillRecord, error) {
+	bills, err := sdb.QueryBills(QueryMap{})
+	if err != nil {
+		return bills, err
+	}
+
+	var missing []BillRecord
+	for _, bill := range bills {
+		if errors.Is(bill.validatePeriod(), ErrAnchorRequired) {
+			missing = append(missing, bill)
 		}
+	}
+
+	if len(missing) == 0 {
+		return []BillRecord{}, fmt.Errorf("bills without an anchor: %w", ErrNoRows)
+	}
+	return missing, nil
+}
 
-		records = append(records, record)
+/*
+IsDueIn reports whether the bill is due in the month of t. The bill repeats
+every period in both directions from its anchor, so months before the
+anchor are answered the same way as the months after it.
+*/
+func (cfg BillsConfig) IsDueIn(t time.Time) bool {
+	interval := cfg.Period.Months()
+	if cfg.Period == EVERY_N_MONTHS {
+		interval = cfg.EveryNMonths
+	}
+
+	switch {
+	case interval == 1:
+		return true
+	case interval < 1 || cfg.AnchorMonth.IsZero():
+		return false
+	}
+
+	diff := monthIndex(t.Year(), int(t.Month())) -
+		monthIndex(cfg.AnchorMonth.Year(), int(cfg.AnchorMonth.Month()))
+	return diff%interval == 0
+}
+
+func (cfg BillsConfig) validatePeriod() error {
+	if cfg.Period == EVERY_N_MONTHS && cfg.EveryNMonths < 1 {
+		return fmt.Errorf("%w: every_n_months must be at least 1", ErrPeriodInvalid)
+	}
+	if cfg.Period != EVERY_N_MONTHS && cfg.EveryNMonths != 0 {
+		return fmt.Errorf("%w: every_n_months is only used by %s", ErrPeriodInvalid, EVERY_N_MONTHS)
+	}
+
+	switch cfg.Period {
+	case QUARTERLY, SEMIANNUAL, YEARLY, EVERY_N_MONTHS:
+		if cfg.AnchorMonth.IsZero() {
+			return fmt.Errorf("%w: %s", ErrAnchorRequired, cfg.Period)
+		}
+	}
+	return nil
+}
+
+// periodValues returns the stored anchor and every_n_months values, nil when unset
+func (cfg BillsConfig) periodValues() (anchorYear, anchorMonth, everyNMonths any) {
+	if !cfg.AnchorMonth.IsZero() {
+		anchorYear = cfg.AnchorMonth.Year()
+		anchorMonth = cfg.AnchorMonth.Month()
+	}
+	if cfg.EveryNMonths != 0 {
+		everyNMonths = cfg.EveryNMonths
+	}
+	return anchorYear, anchorMonth, everyNMonths
+}
+
+func (sdb SqliteDb) CreateBillHistory(cfg BillHistoryConfig) error {
+	if err := requireCurrency(sdb.currencyCode, &cfg.Amount, cfg.PaidAmount); err != nil {
+		return fmt.Errorf("cannot create bill history: %w", err)
+	}
+
+	if _, err := sdb.insert(
+		BILL_HISTORY,
+		cfg.BillID,
+		cfg.MonthID,
+		cfg.Amount,
+		lib.TryDeref(cfg.PaidAmount),
+		lib.TryDeref(cfg.PaidDate),
+		cfg.DueDay,
+		lib.TryDeref(cfg.Notes),
+	); err != nil {
+		return fmt.Errorf("cannot create bill history for bill %d: %w", cfg.BillID, err)
+	}
+	return nil
+}
+
+func (sdb SqliteDb) QueryBillHistory(
+	qm QueryMap,
+	opts ...QueryOption,
+) ([]BillHistoryRecord, error) {
+	records, err := allRows(sdb, BILL_HISTORY, qm, opts, sdb.scanBillHistory)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query bill history: %w", err)
 	}
 
 	if len(records) == 0 {
-		return nil, fmt.Errorf("no results from query")
+		return nil, fmt.Errorf("bill history: %w", ErrNoRows)
 	}
 
 	return records, nil
 }
+
+/*
+EachBillHistory calls fn with every bill history record matching qm, one at
+a time. See ErrStop to stop early. fn must not use the database.
+*/
+func (sdb SqliteDb) EachBillHistory(
+	qm QueryMap,
+	fn func(BillHistoryRecord) error,
+	opts ...QueryOption,
+) error {
+	if err := eachRow(sdb, BILL_HISTORY, qm, opts, sdb.scanBillHistory, fn); err != nil {
+		return fmt.Errorf("cannot query bill history: %w", err)
+	}
+	return nil
+}
+
+func (sdb SqliteDb) scanBillHistory(row scanner) (BillHistoryRecord, error) {
+	var record BillHistoryRecord
+	record.Amount = lib.NewCurrency("", sdb.currencyCode)
+	paidAmount := lib.NullCurrency{Currency: record.Amount}
+
+	if err := row.Scan(
+		&record.ID,
+		&record.BillID,
+		&record.MonthID,
+		&record.Amount,
+		&paidAmount,
+		&record.PaidDate,
+		&record.DueDay,
+		&record.Notes,
+	); err != nil {
+		return BillHistoryRecord{}, fmt.Errorf("cannot scan bill history: %w", err)
+	}
+
+	record.PaidAmount = paidAmount.Ptr()
+	return record, nil
+}
+
+func (sdb SqliteDb) UpdateBillHistory(id int, cfg BillHistoryConfig) error {
+	if err := requireCurrency(sdb.currencyCode, &cfg.Amount, cfg.PaidAmount); err != nil {
+		return fmt.Errorf("cannot update bill history %d: %w", id, err)
+	}
+
+	// Matches the column default when creating bill history
+	paidAmount := lib.NewCurrency("", sdb.currencyCode)
+	if cfg.PaidAmount != nil {
+		paidAmount = *cfg.PaidAmount
+	}
+
+	if err := sdb.update(BILL_HISTORY, id, FieldMap{
+		"bill_id":     cfg.BillID,
+		"month_id":    cfg.MonthID,
+		"amount":      cfg.Amount,
+		"paid_amount": paidAmount,
+		"paid_date":   lib.TryDeref(cfg.PaidDate),
+		"due_day":     cfg.DueDay,
+		"notes":       lib.TryDeref(cfg.Notes),
+	}); err != nil {
+		return fmt.Errorf("cannot update bill history %d: %w", id, err)
+	}
+	return nil
+}
+
+func (sdb SqliteDb) DeleteBillHistory(id int) error {
+	if err := sdb.deleteByID(BILL_HISTORY, id); err != nil {
+		return fmt.Errorf("cannot delete bill history %d: %w", id, err)
+	}
+	return nil
+}
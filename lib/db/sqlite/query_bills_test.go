@@ -1,7 +1,6 @@
 package sqlite
 
 import (
-	"path/filepath"
 	"testing"
 	"time"
 
@@ -87,7 +86,7 @@ func TestQueryBills(t *testing.T) {
 			},
 		},
 		{
-			should: "panic on violated due_day constraint",
+			should: "error on violated due_day constraint",
 			actual: []BillsConfig{
 				{
 					Name:   "t1",
@@ -107,24 +106,19 @@ func TestQueryBills(t *testing.T) {
 	for _, mock := range table {
 		t.Run("should "+mock.should, func(t *testing.T) {
 			t.Parallel()
-			dir := t.TempDir()
 			a := assert.New(t)
 			r := require.New(t)
-
-			db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-			defer db.Close()
+			db := newMockDb(t)
 
 			if mock.expectedError != nil {
 				for _, bill := range mock.actual {
-					a.PanicsWithValue(ErrDueDayInvalid, func() {
-						db.CreateNewBill(bill)
-					})
+					a.ErrorIs(db.CreateNewBill(bill), ErrDueDayInvalid)
 				}
 				return
 			}
 
 			for _, bill := range mock.actual {
-				db.CreateNewBill(bill)
+				r.NoError(db.CreateNewBill(bill))
 			}
 
 			bills, err := db.QueryBills(QueryMap{})
@@ -134,29 +128,26 @@ func TestQueryBills(t *testing.T) {
 		})
 	}
 
-	t.Run("should panic on unique constraint violation", func(t *testing.T) {
+	t.Run("should error on unique constraint violation", func(t *testing.T) {
 		t.Parallel()
-		dir := t.TempDir()
 		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
 
-		db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-		defer db.Close()
-
-		db.CreateNewBill(BillsConfig{
+		r.NoError(db.CreateNewBill(BillsConfig{
 			Name:   "name",
 			Amount: lib.NewCurrency("13.37", lib.USD),
 			DueDay: 3,
 			Period: MONTHLY,
-		})
+		}))
 
-		a.PanicsWithValue(ErrUniqueName, func() {
-			db.CreateNewBill(BillsConfig{
-				Name:   "name",
-				Amount: lib.NewCurrency("133.7", lib.USD),
-				DueDay: 7,
-				Period: MONTHLY,
-			})
+		err := db.CreateNewBill(BillsConfig{
+			Name:   "name",
+			Amount: lib.NewCurrency("133.7", lib.USD),
+			DueDay: 7,
+			Period: MONTHLY,
 		})
+		a.ErrorIs(err, ErrUniqueName)
 	})
 }
 
@@ -267,7 +258,7 @@ func TestCreateBillHistory(t *testing.T) {
 			},
 		},
 		{
-			should: "panic with foreign key violations",
+			should: "error with foreign key violations",
 			bills: []BillsConfig{
 				{
 					Name:   "b1",
@@ -297,32 +288,27 @@ func TestCreateBillHistory(t *testing.T) {
 	for _, mock := range table {
 		t.Run("should "+mock.should, func(t *testing.T) {
 			t.Parallel()
-			dir := t.TempDir()
 			a := assert.New(t)
 			r := require.New(t)
+			db := newMockDb(t)
 
-			db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-			defer db.Close()
-
-			db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local))
+			r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
 
 			for _, b := range mock.bills {
-				db.CreateNewBill(b)
+				r.NoError(db.CreateNewBill(b))
 			}
 
 			if mock.expectedError != nil {
 				for _, history := range mock.actual {
 					if mock.expectedError != nil {
-						a.PanicsWithValue(ErrForeignKey, func() {
-							db.CreateBillHistory(history)
-						})
+						a.ErrorIs(db.CreateBillHistory(history), ErrForeignKey)
 					}
 				}
 				return
 			}
 
 			for _, history := range mock.actual {
-				db.CreateBillHistory(history)
+				r.NoError(db.CreateBillHistory(history))
 			}
 
 			res, err := db.QueryBillHistory(QueryMap{})
@@ -332,3 +318,368 @@ func TestCreateBillHistory(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateBills(t *testing.T) {
+	t.Run("should update a bill", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateNewBill(BillsConfig{
+			Name:   "rent",
+			Amount: lib.NewCurrency("1500", lib.USD),
+			DueDay: 1,
+			Period: MONTHLY,
+		}))
+
+		updated := BillsConfig{
+			Name:        "Mom's Rent",
+			Amount:      lib.NewCurrency("1650.50", lib.USD),
+			DueDay:      3,
+			Period:      YEARLY,
+			AnchorMonth: time.Date(2024, 8, 1, 0, 0, 0, 0, time.Local),
+		}
+		r.NoError(db.UpdateBill(1, updated))
+
+		res, err := db.QueryBills(QueryMap{})
+		r.NoError(err)
+		a.Equal([]BillRecord{{ID: 1, BillsConfig: updated}}, res)
+	})
+
+	t.Run("should update bill history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateNewBill(BillsConfig{
+			Name:   "rent",
+			Amount: lib.NewCurrency("1500", lib.USD),
+			DueDay: 1,
+			Period: MONTHLY,
+		}))
+		r.NoError(db.CreateBillHistory(BillHistoryConfig{
+			BillID:  1,
+			MonthID: 1,
+			Amount:  lib.NewCurrency("1500", lib.USD),
+			DueDay:  1,
+		}))
+
+		updated := BillHistoryConfig{
+			BillID:     1,
+			MonthID:    1,
+			Amount:     lib.NewCurrency("1500", lib.USD),
+			DueDay:     1,
+			PaidAmount: lib.NewPointer(lib.NewCurrency("1500", lib.USD)),
+			PaidDate:   lib.NewPointer("2024-01-01"),
+			Notes:      lib.NewPointer("paid early"),
+		}
+		r.NoError(db.UpdateBillHistory(1, updated))
+
+		res, err := db.QueryBillHistory(QueryMap{})
+		r.NoError(err)
+		a.Equal([]BillHistoryRecord{{ID: 1, BillHistoryConfig: updated}}, res)
+	})
+
+	t.Run("should error when updating with invalid values", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		for _, name := range []string{"rent", "power"} {
+			r.NoError(db.CreateNewBill(BillsConfig{
+				Name:   name,
+				Amount: lib.NewCurrency("10", lib.USD),
+				DueDay: 1,
+				Period: MONTHLY,
+			}))
+		}
+
+		err := db.UpdateBill(2, BillsConfig{
+			Name:   "rent",
+			Amount: lib.NewCurrency("10", lib.USD),
+			DueDay: 1,
+			Period: MONTHLY,
+		})
+		a.ErrorIs(err, ErrUniqueName)
+
+		err = db.UpdateBill(2, BillsConfig{
+			Name:   "power",
+			Amount: lib.NewCurrency("10", lib.USD),
+			DueDay: 32,
+			Period: MONTHLY,
+		})
+		a.ErrorIs(err, ErrDueDayInvalid)
+
+		err = db.UpdateBill(3, BillsConfig{
+			Name:   "water",
+			Amount: lib.NewCurrency("10", lib.USD),
+			DueDay: 1,
+			Period: MONTHLY,
+		})
+		a.ErrorIs(err, ErrNoRows)
+	})
+}
+
+func TestDeleteBills(t *testing.T) {
+	rent := BillsConfig{
+		Name:   "rent",
+		Amount: lib.NewCurrency("1500", lib.USD),
+		DueDay: 1,
+		Period: MONTHLY,
+	}
+
+	t.Run("should delete a bill without history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateNewBill(rent))
+		r.NoError(db.DeleteBill(1))
+
+		_, err := db.QueryBills(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+
+		_, err = db.QueryBills(QueryMap{WHERE_ARCHIVED: true})
+		a.ErrorIs(err, ErrNoRows)
+
+		a.ErrorIs(db.DeleteBill(1), ErrNoRows)
+	})
+
+	t.Run("should archive a bill with history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateNewBill(rent))
+		r.NoError(db.CreateBillHistory(BillHistoryConfig{
+			BillID:  1,
+			MonthID: 1,
+			Amount:  rent.Amount,
+			DueDay:  rent.DueDay,
+		}))
+
+		r.NoError(db.DeleteBill(1))
+
+		_, err := db.QueryBills(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+
+		archived, err := db.QueryBills(QueryMap{WHERE_ARCHIVED: true})
+		r.NoError(err)
+		a.Equal([]BillRecord{{ID: 1, BillsConfig: rent}}, archived)
+
+		history, err := db.QueryBillHistory(QueryMap{WHERE_BILL_ID: 1})
+		r.NoError(err)
+		a.Len(history, 1)
+
+		// The name is free to use again once the bill is archived
+		r.NoError(db.CreateNewBill(rent))
+		bills, err := db.QueryBills(QueryMap{})
+		r.NoError(err)
+		a.Equal([]BillRecord{{ID: 2, BillsConfig: rent}}, bills)
+	})
+
+	t.Run("should delete bill history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateNewBill(rent))
+		r.NoError(db.CreateBillHistory(BillHistoryConfig{
+			BillID:  1,
+			MonthID: 1,
+			Amount:  rent.Amount,
+			DueDay:  rent.DueDay,
+		}))
+
+		r.NoError(db.DeleteBillHistory(1))
+
+		_, err := db.QueryBillHistory(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+
+		// Without history, the bill is deleted instead of archived
+		r.NoError(db.DeleteBill(1))
+		_, err = db.QueryBills(QueryMap{WHERE_ARCHIVED: true})
+		a.ErrorIs(err, ErrNoRows)
+	})
+}
+
+func TestBillsDueIn(t *testing.T) {
+	month := func(year int, m time.Month) time.Time {
+		return time.Date(year, m, 1, 0, 0, 0, 0, time.Local)
+	}
+
+	type MockTable struct {
+		should string
+		bill   BillsConfig
+		due    []time.Time
+		notDue []time.Time
+	}
+
+	table := []MockTable{
+		{
+			should: "be due every month",
+			bill:   BillsConfig{Period: MONTHLY},
+			due:    []time.Time{month(2023, 12), month(2024, 1), month(2024, 7)},
+		},
+		{
+			should: "be due once a year",
+			bill:   BillsConfig{Period: YEARLY, AnchorMonth: month(2024, 3)},
+			due:    []time.Time{month(2023, 3), month(2024, 3), month(2025, 3)},
+			notDue: []time.Time{month(2024, 2), month(2024, 4), month(2025, 1)},
+		},
+		{
+			should: "be due every quarter across the new year",
+			bill:   BillsConfig{Period: QUARTERLY, AnchorMonth: month(2024, 11)},
+			due: []time.Time{
+				month(2024, 8),
+				month(2024, 11),
+				month(2025, 2),
+				month(2025, 5),
+			},
+			notDue: []time.Time{month(2024, 12), month(2025, 1), month(2025, 3)},
+		},
+		{
+			should: "be due twice a year",
+			bill:   BillsConfig{Period: SEMIANNUAL, AnchorMonth: month(2024, 10)},
+			due:    []time.Time{month(2024, 4), month(2024, 10), month(2025, 4)},
+			notDue: []time.Time{month(2024, 12), month(2025, 1), month(2025, 9)},
+		},
+		{
+			should: "be due every n months without lining up with the year",
+			bill: BillsConfig{
+				Period:       EVERY_N_MONTHS,
+				AnchorMonth:  month(2024, 12),
+				EveryNMonths: 5,
+			},
+			due: []time.Time{
+				month(2024, 7),
+				month(2024, 12),
+				month(2025, 5),
+				month(2025, 10),
+				month(2026, 3),
+			},
+			notDue: []time.Time{month(2025, 12), month(2025, 1), month(2023, 12)},
+		},
+		{
+			should: "never be due without an anchor",
+			bill:   BillsConfig{Period: YEARLY},
+			notDue: []time.Time{month(2024, 1), month(2024, 6)},
+		},
+	}
+
+	for _, mock := range table {
+		t.Run("should "+mock.should, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			for _, m := range mock.due {
+				a.True(mock.bill.IsDueIn(m), "expected due in %s", m.Format("2006-01"))
+			}
+			for _, m := range mock.notDue {
+				a.False(mock.bill.IsDueIn(m), "expected not due in %s", m.Format("2006-01"))
+			}
+		})
+	}
+
+	t.Run("should query the bills due in a month", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		bills := []BillsConfig{
+			{
+				Name:   "rent",
+				Amount: lib.NewCurrency("1200", lib.USD),
+				DueDay: 1,
+				Period: MONTHLY,
+			},
+			{
+				Name:        "insurance",
+				Amount:      lib.NewCurrency("300", lib.USD),
+				DueDay:      15,
+				Period:      QUARTERLY,
+				AnchorMonth: month(2024, 11),
+			},
+			{
+				Name:        "domain",
+				Amount:      lib.NewCurrency("12", lib.USD),
+				DueDay:      20,
+				Period:      YEARLY,
+				AnchorMonth: month(2024, 12),
+			},
+		}
+		for _, bill := range bills {
+			r.NoError(db.CreateNewBill(bill))
+		}
+
+		names := func(t time.Time) []string {
+			res, err := db.QueryBillsDueIn(t)
+			r.NoError(err)
+
+			var names []string
+			for _, b := range res {
+				names = append(names, b.Name)
+			}
+			return names
+		}
+
+		a.Equal([]string{"rent", "domain"}, names(month(2024, 12)))
+		a.Equal([]string{"rent"}, names(month(2025, 1)))
+		a.Equal([]string{"rent", "insurance"}, names(month(2025, 2)))
+		a.Equal([]string{"rent", "domain"}, names(month(2025, 12)))
+
+		res, err := db.QueryBills(QueryMap{WHERE_NAME: "domain"})
+		r.NoError(err)
+		a.Equal(bills[2], res[0].BillsConfig)
+	})
+
+	t.Run("should error on invalid periods", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+
+		err := db.CreateNewBill(BillsConfig{
+			Name:   "domain",
+			Amount: lib.NewCurrency("12", lib.USD),
+			DueDay: 1,
+			Period: YEARLY,
+		})
+		a.ErrorIs(err, ErrAnchorRequired)
+
+		err = db.CreateNewBill(BillsConfig{
+			Name:        "filter",
+			Amount:      lib.NewCurrency("30", lib.USD),
+			DueDay:      1,
+			Period:      EVERY_N_MONTHS,
+			AnchorMonth: month(2024, 1),
+		})
+		a.ErrorIs(err, ErrPeriodInvalid)
+
+		err = db.CreateNewBill(BillsConfig{
+			Name:         "rent",
+			Amount:       lib.NewCurrency("1200", lib.USD),
+			DueDay:       1,
+			Period:       MONTHLY,
+			EveryNMonths: 2,
+		})
+		a.ErrorIs(err, ErrPeriodInvalid)
+
+		err = db.CreateNewBill(BillsConfig{
+			Name:   "gym",
+			Amount: lib.NewCurrency("30", lib.USD),
+			DueDay: 1,
+			Period: WEEKLY,
+		})
+		a.ErrorIs(err, ErrPeriodInvalid)
+	})
+}
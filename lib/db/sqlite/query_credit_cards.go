@@ -1,8 +1,8 @@
 package sqlite
 
 import (
+	"errors"
 	"fmt"
-	"strings"
 
 	"github.com/jaeiya/billbank/lib"
 )
@@ -27,7 +27,8 @@ type CreditCardConfig struct {
 	CardNumber     *string
 	LastFourDigits string
 	Notes          *string
-	Password       *string
+	// The currency the card is billed in. Defaults to the base currency.
+	CurrencyCode *lib.CurrencyCode
 }
 
 type CreditCardHistoryConfig struct {
@@ -43,9 +44,10 @@ type CreditCardRecord struct {
 	Name           string
 	DueDay         int
 	CreditLimit    *lib.Currency
-	CardNumber     *string
+	CardNumber     *lib.Secret
 	LastFourDigits string
 	Notes          *string
+	CurrencyCode   lib.CurrencyCode
 }
 
 func (cr CreditCardRecord) String() string {
@@ -87,146 +89,271 @@ func (chr CardHistoryRecord) String() string {
 	)
 }
 
-func (sdb SqliteDb) CreateCreditCard(config CreditCardConfig) {
-	creditLimit := lib.TryDeref(config.CreditLimit)
-	if creditLimit != nil {
-		creditLimit = config.CreditLimit.GetStoredValue()
-	}
-
-	if _, err := sdb.handle.Exec(
-		sdb.InsertInto(
-			CREDIT_CARDS,
-			config.Name,
-			config.DueDay,
-			creditLimit,
-			lib.EncryptNonNil(config.CardNumber, config.Password),
-			config.LastFourDigits,
-			lib.EncryptNonNil(config.Notes, config.Password),
-		),
+func (sdb SqliteDb) CreateCreditCard(config CreditCardConfig) error {
+	currencyCode := sdb.currencyOrBase(config.CurrencyCode)
+	if err := requireCurrency(currencyCode, config.CreditLimit); err != nil {
+		return fmt.Errorf("cannot create credit card %q: %w", config.Name, err)
+	}
+
+	if err := sdb.insertEncrypted(
+		CREDIT_CARDS,
+		map[string]*string{
+			"card_number": config.CardNumber,
+			"notes":       config.Notes,
+		},
+		config.Name,
+		config.DueDay,
+		lib.NewNullCurrency(config.CreditLimit),
+		nil, // card number
+		config.LastFourDigits,
+		nil, // notes
+		currencyCode,
 	); err != nil {
-		panicOnExecErr(err)
+		return fmt.Errorf("cannot create credit card %q: %w", config.Name, err)
 	}
+	return nil
 }
 
-func (sdb SqliteDb) QueryCreditCards(
-	qm QueryMap,
-	password *string,
-) ([]CreditCardRecord, error) {
-	rows := sdb.query(CREDIT_CARDS, qm)
-	var creditLimit *int
-	var records []CreditCardRecord
-
-	for rows.Next() {
-		var record CreditCardRecord
-		var err error
-
-		if err := rows.Scan(
-			&record.ID,
-			&record.Name,
-			&record.DueDay,
-			&creditLimit,
-			&record.CardNumber,
-			&record.LastFourDigits,
-			&record.Notes,
-		); err != nil {
-			panic(err)
-		}
+/*
+UpdateCreditCard replaces every field of a credit card. The currency can
+only be changed before the card has any history, otherwise
+ErrCurrencyHasHistory is returned.
+*/
+func (sdb SqliteDb) UpdateCreditCard(id int, config CreditCardConfig) error {
+	currencyCode := sdb.currencyOrBase(config.CurrencyCode)
+	if err := requireCurrency(currencyCode, config.CreditLimit); err != nil {
+		return fmt.Errorf("cannot update credit card %d: %w", id, err)
+	}
 
-		if creditLimit != nil {
-			c := lib.NewCurrencyFromStore(*creditLimit, sdb.currencyCode)
-			record.CreditLimit = &c
-		}
+	cardNumber, err := sdb.encryptField(CREDIT_CARDS, "card_number", id, config.CardNumber)
+	if err != nil {
+		return fmt.Errorf("cannot update credit card %d: %w", id, err)
+	}
 
-		if password != nil && record.CardNumber != nil {
-			if record.CardNumber, err = lib.DecryptNonNil(record.CardNumber, *password); err != nil {
-				panic(err)
-			}
-		}
+	notes, err := sdb.encryptField(CREDIT_CARDS, "notes", id, config.Notes)
+	if err != nil {
+		return fmt.Errorf("cannot update credit card %d: %w", id, err)
+	}
 
-		if password != nil && record.Notes != nil {
-			if record.Notes, err = lib.DecryptNonNil(record.Notes, *password); err != nil {
-				panic(err)
-			}
+	if err := sdb.WithTx(func(tx SqliteDb) error {
+		if err := tx.requireCurrencyUnchanged(CREDIT_CARDS, id, currencyCode); err != nil {
+			return err
 		}
+		return tx.update(CREDIT_CARDS, id, FieldMap{
+			"name":             config.Name,
+			"due_day":          config.DueDay,
+			"credit_limit":     lib.NewNullCurrency(config.CreditLimit),
+			"card_number":      cardNumber,
+			"last_four_digits": config.LastFourDigits,
+			"notes":            notes,
+			"currency_code":    currencyCode,
+		})
+	}); err != nil {
+		return fmt.Errorf("cannot update credit card %d: %w", id, err)
+	}
+	return nil
+}
+
+/*
+DeleteCreditCard removes a credit card. Cards that already have history are
+archived instead, so their history is kept and they no longer show up in
+QueryCreditCards unless WHERE_ARCHIVED is set.
+*/
+func (sdb SqliteDb) DeleteCreditCard(id int) error {
+	if err := sdb.archiveOrDelete(CREDIT_CARDS, id); err != nil {
+		return fmt.Errorf("cannot delete credit card %d: %w", id, err)
+	}
+	return nil
+}
 
-		records = append(records, record)
+/*
+QueryCreditCards returns the credit cards matching qm. Card numbers and notes
+can only be decrypted while the database is unlocked, so a card that has
+either returns lib.ErrVaultLocked while it's locked.
+*/
+func (sdb SqliteDb) QueryCreditCards(qm QueryMap, opts ...QueryOption) ([]CreditCardRecord, error) {
+	records, err := allRows(sdb, CREDIT_CARDS, qm, opts, sdb.scanCreditCard)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query credit cards: %w", err)
 	}
 
 	if len(records) == 0 {
-		return []CreditCardRecord{}, fmt.Errorf("no results found")
+		return []CreditCardRecord{}, fmt.Errorf("credit cards: %w", ErrNoRows)
 	}
 
 	return records, nil
 }
 
-func (sdb SqliteDb) CreateCreditCardHistory(config CreditCardHistoryConfig) {
-	creditLimit := lib.TryDeref(config.CreditLimit)
+func (sdb SqliteDb) scanCreditCard(row scanner) (CreditCardRecord, error) {
+	var creditLimit *int64
+	var cardNumber *string
+	var currencyCode string
+	var record CreditCardRecord
+
+	if err := row.Scan(
+		&record.ID,
+		&record.Name,
+		&record.DueDay,
+		&creditLimit,
+		&cardNumber,
+		&record.LastFourDigits,
+		&record.Notes,
+		&currencyCode,
+	); err != nil {
+		return CreditCardRecord{}, fmt.Errorf("cannot scan credit card: %w", err)
+	}
+
+	var err error
+	if record.CurrencyCode, err = lib.ParseCurrencyCode(currencyCode); err != nil {
+		return CreditCardRecord{}, fmt.Errorf("cannot scan credit card: %w", err)
+	}
+
 	if creditLimit != nil {
-		creditLimit = config.CreditLimit.GetStoredValue()
+		c := lib.NewCurrencyFromStore(*creditLimit, record.CurrencyCode)
+		record.CreditLimit = &c
+	}
+
+	if record.CardNumber, err = sdb.decryptSecret(
+		CREDIT_CARDS,
+		"card_number",
+		record.ID,
+		cardNumber,
+	); err != nil {
+		return CreditCardRecord{}, fmt.Errorf("credit card %d: %w", record.ID, err)
+	}
+
+	if record.Notes, err = sdb.decryptField(
+		CREDIT_CARDS,
+		"notes",
+		record.ID,
+		record.Notes,
+	); err != nil {
+		return CreditCardRecord{}, fmt.Errorf("credit card %d: %w", record.ID, err)
 	}
-	if _, err := sdb.handle.Exec(
-		sdb.InsertInto(
-			CREDIT_CARD_HISTORY,
+	return record, nil
+}
+
+func (sdb SqliteDb) CreateCreditCardHistory(config CreditCardHistoryConfig) error {
+	if err := sdb.requireCardCurrency(config); err != nil {
+		return fmt.Errorf(
+			"cannot create history for credit card %d: %w",
 			config.CreditCardID,
-			config.MonthID,
-			config.Balance.GetStoredValue(),
-			creditLimit,
-			nil, // paid amount -- defaults to 0
-			nil, // paid date
-			config.DueDay,
-			MONTHLY,
-		),
+			err,
+		)
+	}
+
+	if _, err := sdb.insert(
+		CREDIT_CARD_HISTORY,
+		config.CreditCardID,
+		config.MonthID,
+		config.Balance,
+		lib.NewNullCurrency(config.CreditLimit),
+		nil, // paid amount -- defaults to 0
+		nil, // paid date
+		config.DueDay,
+		MONTHLY,
 	); err != nil {
-		panicOnExecErr(err)
+		return fmt.Errorf(
+			"cannot create history for credit card %d: %w",
+			config.CreditCardID,
+			err,
+		)
+	}
+	return nil
+}
+
+func (sdb SqliteDb) QueryCreditCardHistory(
+	qm QueryMap,
+	opts ...QueryOption,
+) ([]CardHistoryRecord, error) {
+	records, err := allRows(sdb, CREDIT_CARD_HISTORY, qm, opts, scanCardHistory)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query credit card history: %w", err)
+	}
+
+	if len(records) == 0 {
+		return []CardHistoryRecord{}, fmt.Errorf("credit card history: %w", ErrNoRows)
 	}
+
+	return records, nil
 }
 
-func (sdb SqliteDb) QueryCreditCardHistory(qm QueryMap) ([]CardHistoryRecord, error) {
-	rows := sdb.query(CREDIT_CARD_HISTORY, qm)
+/*
+EachCreditCardHistory calls fn with every credit card history record
+matching qm, one at a time. See ErrStop to stop early. fn must not use the
+database.
+*/
+func (sdb SqliteDb) EachCreditCardHistory(
+	qm QueryMap,
+	fn func(CardHistoryRecord) error,
+	opts ...QueryOption,
+) error {
+	if err := eachRow(sdb, CREDIT_CARD_HISTORY, qm, opts, scanCardHistory, fn); err != nil {
+		return fmt.Errorf("cannot query credit card history: %w", err)
+	}
+	return nil
+}
 
+// scanCardHistory scans amounts in the currency of their card
+func scanCardHistory(row scanner) (CardHistoryRecord, error) {
 	var (
-		balance     int
-		creditLimit *int
-		paidAmount  int
-		records     []CardHistoryRecord
+		balance     int64
+		creditLimit *int64
+		paidAmount  int64
+		code        string
+		record      CardHistoryRecord
 	)
 
-	for rows.Next() {
-		var record CardHistoryRecord
-
-		if err := rows.Scan(
-			&record.ID,
-			&record.CreditCardID,
-			&record.MonthID,
-			&balance,
-			&creditLimit,
-			&paidAmount,
-			&record.PaidDay,
-			&record.DueDay,
-			&record.Period,
-		); err != nil {
-			panic(err)
-		}
+	if err := row.Scan(
+		&record.ID,
+		&record.CreditCardID,
+		&record.MonthID,
+		&balance,
+		&creditLimit,
+		&paidAmount,
+		&record.PaidDay,
+		&record.DueDay,
+		&record.Period,
+		&code,
+	); err != nil {
+		return CardHistoryRecord{}, fmt.Errorf("cannot scan credit card history: %w", err)
+	}
 
-		if creditLimit != nil {
-			c := lib.NewCurrencyFromStore(*creditLimit, sdb.currencyCode)
-			record.CreditLimit = &c
-		}
+	currencyCode, err := lib.ParseCurrencyCode(code)
+	if err != nil {
+		return CardHistoryRecord{}, fmt.Errorf("cannot scan credit card history: %w", err)
+	}
 
-		record.Balance = lib.NewCurrencyFromStore(balance, sdb.currencyCode)
-		record.PaidAmount = lib.NewCurrencyFromStore(paidAmount, sdb.currencyCode)
-		records = append(records, record)
+	if creditLimit != nil {
+		c := lib.NewCurrencyFromStore(*creditLimit, currencyCode)
+		record.CreditLimit = &c
 	}
 
-	if len(records) == 0 {
-		return []CardHistoryRecord{}, fmt.Errorf("no query results")
+	record.Balance = lib.NewCurrencyFromStore(balance, currencyCode)
+	record.PaidAmount = lib.NewCurrencyFromStore(paidAmount, currencyCode)
+	return record, nil
+}
+
+func (sdb SqliteDb) UpdateCreditCardHistory(id int, config CreditCardHistoryConfig) error {
+	if err := sdb.requireCardCurrency(config); err != nil {
+		return fmt.Errorf("cannot update credit card history %d: %w", id, err)
 	}
 
-	return records, nil
+	if err := sdb.update(CREDIT_CARD_HISTORY, id, FieldMap{
+		"card_id":      config.CreditCardID,
+		"month_id":     config.MonthID,
+		"balance":      config.Balance,
+		"credit_limit": lib.NewNullCurrency(config.CreditLimit),
+		"due_day":      config.DueDay,
+	}); err != nil {
+		return fmt.Errorf("cannot update credit card history %d: %w", id, err)
+	}
+	return nil
 }
 
 func (sdb SqliteDb) SetCreditCardHistory(historyID int, fieldMap CCFieldMap) error {
-	conditions := make([]string, 0, len(fieldMap))
+	fm := make(FieldMap, len(fieldMap))
+	var amounts []*lib.Currency
 	for field, value := range fieldMap {
 		switch field {
 
@@ -235,28 +362,68 @@ func (sdb SqliteDb) SetCreditCardHistory(historyID int, fieldMap CCFieldMap) err
 			if err != nil {
 				return fmt.Errorf("%s should be of type: lib.Currency", field)
 			}
-			conditions = append(conditions, fmt.Sprintf("%s=%d", field, c.GetStoredValue()))
+			fm[string(field)] = c
+			amounts = append(amounts, &c)
 
 		case CC_DUE_DAY, CC_PAID_DAY:
 			if !lib.IsInt(value) {
 				return fmt.Errorf("%s should of of type: int", field)
 			}
-			conditions = append(conditions, fmt.Sprintf("%s=%d", field, value))
+			fm[string(field)] = value
 
 		default:
 			return fmt.Errorf("unsupported credit card history field: %s", field)
 		}
 	}
 
-	query := fmt.Sprintf(
-		"UPDATE %s SET %s WHERE id = %d",
-		CREDIT_CARD_HISTORY,
-		strings.Join(conditions, ","),
-		historyID,
-	)
+	code, found, err := sdb.currencyOf(cardHistoryCurrencyQuery, historyID)
+	if err == nil && found {
+		err = requireCurrency(code, amounts...)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot set credit card history %d: %w", historyID, err)
+	}
+
+	if err := sdb.update(CREDIT_CARD_HISTORY, historyID, fm); err != nil {
+		return fmt.Errorf("cannot set credit card history %d: %w", historyID, err)
+	}
+	return nil
+}
 
-	if _, err := sdb.handle.Exec(query); err != nil {
-		panic(err)
+func (sdb SqliteDb) DeleteCreditCardHistory(id int) error {
+	if err := sdb.deleteByID(CREDIT_CARD_HISTORY, id); err != nil {
+		return fmt.Errorf("cannot delete credit card history %d: %w", id, err)
 	}
 	return nil
 }
+
+/*
+TotalCreditCardBalance adds up the balance of every credit card in the
+month, converted into the base currency with the month's exchange rates.
+Returns lib.ErrCurrencyOverflow if the total is too large to store.
+*/
+func (sdb SqliteDb) TotalCreditCardBalance(monthID int) (lib.Currency, error) {
+	history, err := sdb.QueryCreditCardHistory(QueryMap{WHERE_MONTH_ID: monthID})
+	if err != nil && !errors.Is(err, ErrNoRows) {
+		return lib.Currency{}, err
+	}
+
+	balances := make([]lib.Currency, 0, len(history))
+	for _, record := range history {
+		balances = append(balances, record.Balance)
+	}
+
+	total, err := sdb.sumInBase(monthID, balances...)
+	if err != nil {
+		return lib.Currency{}, fmt.Errorf("cannot total credit card balances: %w", err)
+	}
+	return total, nil
+}
+
+func (sdb SqliteDb) requireCardCurrency(config CreditCardHistoryConfig) error {
+	code, found, err := sdb.currencyOf(cardCurrencyQuery, config.CreditCardID)
+	if err != nil || !found {
+		return err
+	}
+	return requireCurrency(code, &config.Balance, config.CreditLimit)
+}
@@ -1,7 +1,6 @@
 package sqlite
 
 import (
-	"path/filepath"
 	"testing"
 	"time"
 
@@ -30,7 +29,6 @@ func TestCreateCreditCards(t *testing.T) {
 					CardNumber:     lib.NewPointer("2382 3812 4582 5822"),
 					LastFourDigits: "5822",
 					Notes:          lib.NewPointer("some notes"),
-					Password:       lib.NewPointer("password"),
 				},
 			},
 			expected: []CreditCardRecord{
@@ -39,7 +37,7 @@ func TestCreateCreditCards(t *testing.T) {
 					Name:           "test",
 					DueDay:         5,
 					CreditLimit:    lib.NewPointer(lib.NewCurrency("5000", lib.USD)),
-					CardNumber:     lib.NewPointer("2382 3812 4582 5822"),
+					CardNumber:     lib.NewSecret("2382 3812 4582 5822"),
 					LastFourDigits: "5822",
 					Notes:          lib.NewPointer("some notes"),
 				},
@@ -55,7 +53,6 @@ func TestCreateCreditCards(t *testing.T) {
 					CreditLimit:    lib.NewPointer(lib.NewCurrency("5000", lib.USD)),
 					CardNumber:     lib.NewPointer("2382 3812 4582 5822"),
 					LastFourDigits: "5822",
-					Password:       lib.NewPointer("password"),
 				},
 				{
 					Name:           "test2",
@@ -63,14 +60,12 @@ func TestCreateCreditCards(t *testing.T) {
 					CreditLimit:    lib.NewPointer(lib.NewCurrency("5000", lib.USD)),
 					LastFourDigits: "0023",
 					Notes:          lib.NewPointer("some notes"),
-					Password:       lib.NewPointer("password"),
 				},
 				{
 					Name:           "test3",
 					DueDay:         8,
 					CreditLimit:    lib.NewPointer(lib.NewCurrency("5000", lib.USD)),
 					LastFourDigits: "1234",
-					Password:       lib.NewPointer("password"),
 				},
 			},
 			expected: []CreditCardRecord{
@@ -79,7 +74,7 @@ func TestCreateCreditCards(t *testing.T) {
 					Name:           "test",
 					DueDay:         5,
 					CreditLimit:    lib.NewPointer(lib.NewCurrency("5000", lib.USD)),
-					CardNumber:     lib.NewPointer("2382 3812 4582 5822"),
+					CardNumber:     lib.NewSecret("2382 3812 4582 5822"),
 					LastFourDigits: "5822",
 					Notes:          nil,
 				},
@@ -105,7 +100,7 @@ func TestCreateCreditCards(t *testing.T) {
 			password: lib.NewPointer("password"),
 		},
 		{
-			should: "panic on due day constraint violation",
+			should: "error on due day constraint violation",
 			actual: []CreditCardConfig{
 				{
 					Name:   "test",
@@ -123,54 +118,50 @@ func TestCreateCreditCards(t *testing.T) {
 	for _, mock := range table {
 		t.Run("should "+mock.should, func(t *testing.T) {
 			t.Parallel()
-			dir := t.TempDir()
 			a := assert.New(t)
 			r := require.New(t)
-
-			db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-			defer db.Close()
+			db := newMockDb(t)
 
 			if mock.expectedError != nil {
 				for _, cardConfig := range mock.actual {
-					a.PanicsWithValue(ErrDueDayInvalid, func() {
-						db.CreateCreditCard(cardConfig)
-					})
+					a.ErrorIs(db.CreateCreditCard(cardConfig), ErrDueDayInvalid)
 				}
 				return
 			}
 
+			if mock.password != nil {
+				r.NoError(db.SetPassword(*mock.password))
+			}
+
 			for _, cardConfig := range mock.actual {
-				db.CreateCreditCard(cardConfig)
+				r.NoError(db.CreateCreditCard(cardConfig))
 			}
 
-			res, err := db.QueryCreditCards(QueryMap{}, mock.password)
+			res, err := db.QueryCreditCards(QueryMap{})
 			r.NoError(err)
 
 			a.Equal(mock.expected, res)
 		})
 	}
 
-	t.Run("should panic on unique name constraint violation", func(t *testing.T) {
+	t.Run("should error on unique name constraint violation", func(t *testing.T) {
 		t.Parallel()
-		dir := t.TempDir()
 		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
 
-		db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-		defer db.Close()
-
-		db.CreateCreditCard(CreditCardConfig{
+		r.NoError(db.CreateCreditCard(CreditCardConfig{
 			Name:           "test",
 			DueDay:         5,
 			LastFourDigits: "1234",
-		})
+		}))
 
-		a.PanicsWithValue(ErrUniqueName, func() {
-			db.CreateCreditCard(CreditCardConfig{
-				Name:           "test",
-				DueDay:         5,
-				LastFourDigits: "1234",
-			})
+		err := db.CreateCreditCard(CreditCardConfig{
+			Name:           "test",
+			DueDay:         5,
+			LastFourDigits: "1234",
 		})
+		a.ErrorIs(err, ErrUniqueName)
 	})
 }
 
@@ -250,7 +241,7 @@ func TestCreateCreditCardHistory(t *testing.T) {
 			},
 		},
 		{
-			should: "panic on foreign key violations",
+			should: "error on foreign key violations",
 			cards: []CreditCardConfig{
 				{
 					Name:           "test",
@@ -275,7 +266,7 @@ func TestCreateCreditCardHistory(t *testing.T) {
 			expectedError: ErrForeignKey,
 		},
 		{
-			should: "panic on due day constraint violation",
+			should: "error on due day constraint violation",
 			cards: []CreditCardConfig{
 				{
 					Name:           "test",
@@ -304,30 +295,25 @@ func TestCreateCreditCardHistory(t *testing.T) {
 	for _, mock := range table {
 		t.Run("should "+mock.should, func(t *testing.T) {
 			t.Parallel()
-			dir := t.TempDir()
 			a := assert.New(t)
 			r := require.New(t)
+			db := newMockDb(t)
 
-			db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-			defer db.Close()
-
-			db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local))
+			r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
 
 			for _, cardConfig := range mock.cards {
-				db.CreateCreditCard(cardConfig)
+				r.NoError(db.CreateCreditCard(cardConfig))
 			}
 
 			if mock.expectedError != nil {
 				for _, histConfig := range mock.actual {
-					a.PanicsWithValue(mock.expectedError, func() {
-						db.CreateCreditCardHistory(histConfig)
-					})
+					a.ErrorIs(db.CreateCreditCardHistory(histConfig), mock.expectedError)
 				}
 				return
 			}
 
 			for _, histConfig := range mock.actual {
-				db.CreateCreditCardHistory(histConfig)
+				r.NoError(db.CreateCreditCardHistory(histConfig))
 			}
 
 			res, err := db.QueryCreditCardHistory(QueryMap{})
@@ -415,27 +401,24 @@ func TestSetCreditCardHistory(t *testing.T) {
 	for _, mock := range table {
 		t.Run("should "+mock.should, func(t *testing.T) {
 			t.Parallel()
-			dir := t.TempDir()
 			a := assert.New(t)
 			r := require.New(t)
+			db := newMockDb(t)
 
-			db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-			defer db.Close()
-
-			db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local))
+			r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
 
-			db.CreateCreditCard(CreditCardConfig{
+			r.NoError(db.CreateCreditCard(CreditCardConfig{
 				Name:           "test",
 				DueDay:         1,
 				LastFourDigits: "1234",
-			})
+			}))
 
-			db.CreateCreditCardHistory(CreditCardHistoryConfig{
+			r.NoError(db.CreateCreditCardHistory(CreditCardHistoryConfig{
 				CreditCardID: 1,
 				MonthID:      1,
 				Balance:      lib.NewCurrency("0", lib.USD),
 				DueDay:       1,
-			})
+			}))
 
 			if mock.expectedErrContains != nil {
 				err := db.SetCreditCardHistory(1, mock.actual)
@@ -444,7 +427,7 @@ func TestSetCreditCardHistory(t *testing.T) {
 				return
 			}
 
-			db.SetCreditCardHistory(1, mock.actual)
+			r.NoError(db.SetCreditCardHistory(1, mock.actual))
 
 			res, err := db.QueryCreditCardHistory(QueryMap{})
 			r.NoError(err)
@@ -453,3 +436,237 @@ func TestSetCreditCardHistory(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateCreditCards(t *testing.T) {
+	t.Run("should update a credit card", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+		r.NoError(db.SetPassword("password"))
+
+		r.NoError(db.CreateCreditCard(CreditCardConfig{
+			Name:           "test",
+			DueDay:         5,
+			CardNumber:     lib.NewPointer("2382 3812 4582 5822"),
+			LastFourDigits: "5822",
+		}))
+
+		r.NoError(db.UpdateCreditCard(1, CreditCardConfig{
+			Name:           "renamed",
+			DueDay:         9,
+			CreditLimit:    lib.NewPointer(lib.NewCurrency("2500", lib.USD)),
+			LastFourDigits: "5822",
+			Notes:          lib.NewPointer("new notes"),
+		}))
+
+		res, err := db.QueryCreditCards(QueryMap{})
+		r.NoError(err)
+		a.Equal([]CreditCardRecord{
+			{
+				ID:             1,
+				Name:           "renamed",
+				DueDay:         9,
+				CreditLimit:    lib.NewPointer(lib.NewCurrency("2500", lib.USD)),
+				CardNumber:     nil,
+				LastFourDigits: "5822",
+				Notes:          lib.NewPointer("new notes"),
+			},
+		}, res)
+	})
+
+	t.Run("should update credit card history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateCreditCard(CreditCardConfig{
+			Name:           "test",
+			DueDay:         5,
+			LastFourDigits: "1234",
+		}))
+		r.NoError(db.CreateCreditCardHistory(CreditCardHistoryConfig{
+			CreditCardID: 1,
+			MonthID:      1,
+			Balance:      lib.NewCurrency("500", lib.USD),
+			DueDay:       5,
+		}))
+		r.NoError(db.SetCreditCardHistory(1, CCFieldMap{
+			CC_PAID_AMOUNT: lib.NewCurrency("100", lib.USD),
+		}))
+
+		r.NoError(db.UpdateCreditCardHistory(1, CreditCardHistoryConfig{
+			CreditCardID: 1,
+			MonthID:      1,
+			Balance:      lib.NewCurrency("750", lib.USD),
+			CreditLimit:  lib.NewPointer(lib.NewCurrency("1000", lib.USD)),
+			DueDay:       7,
+		}))
+
+		res, err := db.QueryCreditCardHistory(QueryMap{})
+		r.NoError(err)
+		a.Equal([]CardHistoryRecord{
+			{
+				ID:           1,
+				CreditCardID: 1,
+				MonthID:      1,
+				Balance:      lib.NewCurrency("750", lib.USD),
+				CreditLimit:  lib.NewPointer(lib.NewCurrency("1000", lib.USD)),
+				PaidAmount:   lib.NewCurrency("100", lib.USD),
+				DueDay:       7,
+				Period:       MONTHLY,
+			},
+		}, res)
+	})
+
+	t.Run("should error when updating with invalid values", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateCreditCard(CreditCardConfig{
+			Name:           "test",
+			DueDay:         5,
+			LastFourDigits: "1234",
+		}))
+
+		err := db.UpdateCreditCard(1, CreditCardConfig{
+			Name:           "test",
+			DueDay:         5,
+			CardNumber:     lib.NewPointer("1234"),
+			LastFourDigits: "1234",
+		})
+		a.ErrorIs(err, lib.ErrVaultLocked)
+
+		err = db.UpdateCreditCard(1, CreditCardConfig{
+			Name:           "test",
+			DueDay:         0,
+			LastFourDigits: "1234",
+		})
+		a.ErrorIs(err, ErrDueDayInvalid)
+
+		err = db.SetCreditCardHistory(1, CCFieldMap{CC_DUE_DAY: 5})
+		a.ErrorIs(err, ErrNoRows)
+	})
+
+	t.Run("should only change the currency before there is history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+		yen := lib.JPY
+		card := CreditCardConfig{Name: "test", DueDay: 5, LastFourDigits: "1234"}
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateCreditCard(card))
+		r.NoError(db.UpdateCreditCard(1, CreditCardConfig{
+			Name:           "test",
+			DueDay:         5,
+			LastFourDigits: "1234",
+			CurrencyCode:   &yen,
+		}))
+		r.NoError(db.UpdateCreditCard(1, card))
+
+		r.NoError(db.CreateCreditCardHistory(CreditCardHistoryConfig{
+			CreditCardID: 1,
+			MonthID:      1,
+			Balance:      lib.NewCurrency("500", lib.USD),
+			DueDay:       5,
+		}))
+		err := db.UpdateCreditCard(1, CreditCardConfig{
+			Name:           "renamed",
+			DueDay:         5,
+			LastFourDigits: "1234",
+			CurrencyCode:   &yen,
+		})
+		a.ErrorIs(err, ErrCurrencyHasHistory)
+
+		cards, err := db.QueryCreditCards(QueryMap{})
+		r.NoError(err)
+		a.Equal("test", cards[0].Name)
+		a.Equal(lib.USD, cards[0].CurrencyCode)
+
+		history, err := db.QueryCreditCardHistory(QueryMap{})
+		r.NoError(err)
+		a.Equal(lib.NewCurrency("500", lib.USD), history[0].Balance)
+	})
+}
+
+func TestDeleteCreditCards(t *testing.T) {
+	card := CreditCardConfig{
+		Name:           "test",
+		DueDay:         5,
+		LastFourDigits: "1234",
+	}
+
+	t.Run("should delete a credit card without history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateCreditCard(card))
+		r.NoError(db.DeleteCreditCard(1))
+
+		_, err := db.QueryCreditCards(QueryMap{WHERE_ARCHIVED: true})
+		a.ErrorIs(err, ErrNoRows)
+	})
+
+	t.Run("should archive a credit card with history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateCreditCard(card))
+		r.NoError(db.CreateCreditCardHistory(CreditCardHistoryConfig{
+			CreditCardID: 1,
+			MonthID:      1,
+			Balance:      lib.NewCurrency("500", lib.USD),
+			DueDay:       5,
+		}))
+
+		r.NoError(db.DeleteCreditCard(1))
+
+		_, err := db.QueryCreditCards(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+
+		archived, err := db.QueryCreditCards(QueryMap{WHERE_ARCHIVED: true})
+		r.NoError(err)
+		r.Len(archived, 1)
+		a.Equal("test", archived[0].Name)
+
+		history, err := db.QueryCreditCardHistory(QueryMap{})
+		r.NoError(err)
+		a.Len(history, 1)
+
+		// The name is free to use again once the card is archived
+		r.NoError(db.CreateCreditCard(card))
+	})
+
+	t.Run("should delete credit card history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateCreditCard(card))
+		r.NoError(db.CreateCreditCardHistory(CreditCardHistoryConfig{
+			CreditCardID: 1,
+			MonthID:      1,
+			Balance:      lib.NewCurrency("500", lib.USD),
+			DueDay:       5,
+		}))
+
+		r.NoError(db.DeleteCreditCardHistory(1))
+
+		_, err := db.QueryCreditCardHistory(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+		a.ErrorIs(db.DeleteCreditCardHistory(1), ErrNoRows)
+	})
+}
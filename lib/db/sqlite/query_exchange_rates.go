@@ -0,0 +1,179 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jaeiya/billbank/lib"
+)
+
+var (
+	ErrMissingExchangeRate = fmt.Errorf("missing exchange rate")
+	ErrCurrencyHasHistory  = fmt.Errorf("cannot change the currency of a template with history")
+)
+
+type ExchangeRateRecord struct {
+	ID      int
+	MonthID int
+	Rate    lib.ExchangeRate
+}
+
+/*
+SetExchangeRate stores the rate for the month, replacing the rate that was
+already entered for the same pair of currencies.
+*/
+func (sdb SqliteDb) SetExchangeRate(monthID int, rate lib.ExchangeRate) error {
+	if _, err := sdb.conn().Exec(
+		`INSERT INTO exchange_rates (month_id, from_code, to_code, rate) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (month_id, from_code, to_code) DO UPDATE SET rate = excluded.rate`,
+		monthID,
+		rate.From.String(),
+		rate.To.String(),
+		rate.GetStoredValue(),
+	); err != nil {
+		return fmt.Errorf("cannot set exchange rate %s: %w", rate, toExecErr(err))
+	}
+	return nil
+}
+
+func (sdb SqliteDb) QueryExchangeRates(
+	qm QueryMap,
+	opts ...QueryOption,
+) ([]ExchangeRateRecord, error) {
+	records, err := allRows(sdb, EXCHANGE_RATES, qm, opts, scanExchangeRate)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query exchange rates: %w", err)
+	}
+
+	if len(records) == 0 {
+		return []ExchangeRateRecord{}, fmt.Errorf("exchange rates: %w", ErrNoRows)
+	}
+
+	return records, nil
+}
+
+func scanExchangeRate(row scanner) (ExchangeRateRecord, error) {
+	var from, to string
+	var rate int64
+	var record ExchangeRateRecord
+
+	if err := row.Scan(
+		&record.ID,
+		&record.MonthID,
+		&from,
+		&to,
+		&rate,
+	); err != nil {
+		return ExchangeRateRecord{}, fmt.Errorf("cannot scan exchange rate: %w", err)
+	}
+
+	var err error
+	if record.Rate, err = loadExchangeRate(from, to, rate); err != nil {
+		return ExchangeRateRecord{}, fmt.Errorf("cannot scan exchange rate: %w", err)
+	}
+	return record, nil
+}
+
+func (sdb SqliteDb) DeleteExchangeRate(id int) error {
+	if err := sdb.deleteByID(EXCHANGE_RATES, id); err != nil {
+		return fmt.Errorf("cannot delete exchange rate %d: %w", id, err)
+	}
+	return nil
+}
+
+/*
+GetExchangeRate returns the month's rate between two currencies. A rate
+entered the other way around works just as well, since rates convert in
+both directions.
+
+Returns ErrMissingExchangeRate if neither has been entered.
+*/
+func (sdb SqliteDb) GetExchangeRate(
+	monthID int,
+	from, to lib.CurrencyCode,
+) (lib.ExchangeRate, error) {
+	var fromCode, toCode string
+	var rate int64
+	err := sdb.conn().QueryRow(
+		`SELECT from_code, to_code, rate FROM exchange_rates
+		 WHERE month_id = ?1 AND (
+		     (from_code = ?2 AND to_code = ?3) OR (from_code = ?3 AND to_code = ?2)
+		 )
+		 ORDER BY from_code = ?2 DESC
+		 LIMIT 1`,
+		monthID,
+		from.String(),
+		to.String(),
+	).Scan(&fromCode, &toCode, &rate)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return lib.ExchangeRate{}, fmt.Errorf(
+			"%w: %s to %s in month %d",
+			ErrMissingExchangeRate,
+			from,
+			to,
+			monthID,
+		)
+	}
+	if err != nil {
+		return lib.ExchangeRate{}, fmt.Errorf("cannot query exchange rate: %w", err)
+	}
+
+	return loadExchangeRate(fromCode, toCode, rate)
+}
+
+/*
+ConvertToBase converts c into the base currency with the month's exchange
+rate. Amounts already in the base currency are returned as is.
+*/
+func (sdb SqliteDb) ConvertToBase(monthID int, c lib.Currency) (lib.Currency, error) {
+	if c.GetCode() == sdb.currencyCode {
+		return c, nil
+	}
+
+	rate, err := sdb.GetExchangeRate(monthID, c.GetCode(), sdb.currencyCode)
+	if err != nil {
+		return lib.Currency{}, err
+	}
+	return rate.Convert(c)
+}
+
+/*
+sumInBase converts every amount into the base currency before adding them
+up. Returns lib.ErrCurrencyOverflow if the total gets too large.
+*/
+func (sdb SqliteDb) sumInBase(monthID int, amounts ...lib.Currency) (lib.Currency, error) {
+	total := lib.NewCurrency("", sdb.currencyCode)
+	for _, amount := range amounts {
+		converted, err := sdb.ConvertToBase(monthID, amount)
+		if err != nil {
+			return lib.Currency{}, err
+		}
+		if total, err = total.CheckedAdd(converted); err != nil {
+			return lib.Currency{}, fmt.Errorf("cannot add up month %d: %w", monthID, err)
+		}
+	}
+	return total, nil
+}
+
+func (sdb SqliteDb) currencyOrBase(code *lib.CurrencyCode) lib.CurrencyCode {
+	if code == nil {
+		return sdb.currencyCode
+	}
+	return *code
+}
+
+func loadExchangeRate(from, to string, rate int64) (lib.ExchangeRate, error) {
+	fromCode, err := lib.ParseCurrencyCode(from)
+	if err != nil {
+		return lib.ExchangeRate{}, err
+	}
+
+	toCode, err := lib.ParseCurrencyCode(to)
+	if err != nil {
+		return lib.ExchangeRate{}, err
+	}
+
+	return lib.NewExchangeRateFromStore(fromCode, toCode, rate), nil
+}
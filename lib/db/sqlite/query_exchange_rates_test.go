@@ -0,0 +1,171 @@
+package sqlite
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jaeiya/billbank/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExchangeRates(t *testing.T) {
+	january := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	eur := lib.EUR
+
+	mustRate := func(t *testing.T, from, to lib.CurrencyCode, rate string) lib.ExchangeRate {
+		r, err := lib.NewExchangeRate(from, to, rate)
+		require.NoError(t, err)
+		return r
+	}
+
+	t.Run("should replace the rate entered for the same month", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(january))
+		r.NoError(db.SetExchangeRate(1, mustRate(t, lib.EUR, lib.USD, "1.05")))
+		r.NoError(db.SetExchangeRate(1, mustRate(t, lib.EUR, lib.USD, "1.08")))
+
+		rates, err := db.QueryExchangeRates(QueryMap{WHERE_MONTH_ID: 1})
+		r.NoError(err)
+		r.Len(rates, 1)
+		a.Equal(mustRate(t, lib.EUR, lib.USD, "1.08"), rates[0].Rate)
+
+		r.NoError(db.DeleteExchangeRate(rates[0].ID))
+		_, err = db.QueryExchangeRates(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+	})
+
+	t.Run("should find rates entered the other way around", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(january))
+		r.NoError(db.SetExchangeRate(1, mustRate(t, lib.USD, lib.EUR, "0.5")))
+
+		rate, err := db.GetExchangeRate(1, lib.EUR, lib.USD)
+		r.NoError(err)
+		a.Equal(lib.USD, rate.From)
+
+		_, err = db.GetExchangeRate(1, lib.JPY, lib.USD)
+		a.ErrorIs(err, ErrMissingExchangeRate)
+	})
+
+	t.Run("should fail month constraint", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+
+		err := db.SetExchangeRate(1, mustRate(t, lib.EUR, lib.USD, "1.08"))
+		a.ErrorIs(err, ErrForeignKey)
+	})
+
+	t.Run("should keep balances in the account's currency", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "savings", CurrencyCode: &eur}))
+		month, err := db.StartNewMonth(january)
+		r.NoError(err)
+
+		accounts, err := db.QueryBankAccounts(QueryMap{})
+		r.NoError(err)
+		a.Equal(lib.EUR, accounts[0].CurrencyCode)
+
+		err = db.UpdateBankAccountHistory(1, BankHistoryConfig{
+			MonthID:       month.ID,
+			BankAccountID: 1,
+			Balance:       lib.NewCurrency("100", lib.USD),
+		})
+		a.ErrorIs(err, lib.ErrCurrencyKind)
+
+		balance := lib.NewCurrency("100", lib.EUR)
+		r.NoError(db.UpdateBankAccountHistory(1, BankHistoryConfig{
+			MonthID:       month.ID,
+			BankAccountID: 1,
+			Balance:       balance,
+		}))
+
+		history, err := db.QueryBankAccountHistory(QueryMap{})
+		r.NoError(err)
+		a.Equal(balance, history[0].Balance)
+	})
+
+	t.Run("should convert totals into the base currency", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "savings", CurrencyCode: &eur}))
+		r.NoError(db.CreateCreditCard(CreditCardConfig{
+			Name:           "travel",
+			DueDay:         15,
+			LastFourDigits: "1234",
+			CurrencyCode:   &eur,
+		}))
+		month, err := db.StartNewMonth(january)
+		r.NoError(err)
+
+		r.NoError(db.UpdateBankAccountHistory(1, BankHistoryConfig{
+			MonthID:       month.ID,
+			BankAccountID: 1,
+			Balance:       lib.NewCurrency("50", lib.USD),
+		}))
+		r.NoError(db.UpdateBankAccountHistory(2, BankHistoryConfig{
+			MonthID:       month.ID,
+			BankAccountID: 2,
+			Balance:       lib.NewCurrency("100", lib.EUR),
+		}))
+		r.NoError(db.SetCreditCardHistory(1, CCFieldMap{
+			CC_BALANCE: lib.NewCurrency("10", lib.EUR),
+		}))
+
+		_, err = db.TotalBankBalance(month.ID)
+		a.ErrorIs(err, ErrMissingExchangeRate)
+
+		r.NoError(db.SetExchangeRate(month.ID, mustRate(t, lib.EUR, lib.USD, "1.08")))
+
+		total, err := db.TotalBankBalance(month.ID)
+		r.NoError(err)
+		a.Equal(lib.NewCurrency("158", lib.USD), total)
+
+		cardTotal, err := db.TotalCreditCardBalance(month.ID)
+		r.NoError(err)
+		a.Equal(lib.NewCurrency("10.80", lib.USD), cardTotal)
+	})
+
+	t.Run("should refuse totals that are too large", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "savings"}))
+		month, err := db.StartNewMonth(january)
+		r.NoError(err)
+
+		for id := 1; id <= 2; id++ {
+			r.NoError(db.UpdateBankAccountHistory(id, BankHistoryConfig{
+				MonthID:       month.ID,
+				BankAccountID: id,
+				Balance:       lib.NewCurrencyFromStore(math.MaxInt64-1, lib.USD),
+			}))
+		}
+
+		a.NotPanics(func() {
+			_, err = db.TotalBankBalance(month.ID)
+		})
+		a.ErrorIs(err, lib.ErrCurrencyOverflow)
+	})
+}
@@ -1,15 +1,22 @@
 package sqlite
 
 import (
+	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/jaeiya/billbank/lib"
 )
 
+var ErrPayDateRequired = fmt.Errorf("period requires a pay date")
+
 type IncomeConfig struct {
-	Name   string
+	Name string
+	// The amount of a single paycheck for weekly, biweekly and yearly income
 	Amount lib.Currency
 	Period Period
+	// Any day the income was paid. Required for weekly, biweekly and yearly income.
+	PayDate time.Time
 }
 
 type IncomeRecord struct {
@@ -46,125 +53,346 @@ type AffixIncomeRecord struct {
 	Amount          lib.Currency
 }
 
-func (sdb SqliteDb) CreateIncome(config IncomeConfig) int64 {
-	res, err := sdb.handle.Exec(
-		sdb.InsertInto(INCOME, config.Name, config.Amount.GetStoredValue(), config.Period),
+func (sdb SqliteDb) CreateIncome(config IncomeConfig) (int64, error) {
+	if err := config.validatePayDate(); err != nil {
+		return 0, fmt.Errorf("cannot create income %q: %w", config.Name, err)
+	}
+
+	if err := requireCurrency(sdb.currencyCode, &config.Amount); err != nil {
+		return 0, fmt.Errorf("cannot create income %q: %w", config.Name, err)
+	}
+
+	res, err := sdb.insert(
+		INCOME,
+		config.Name,
+		config.Amount,
+		config.Period,
+		config.payDateValue(),
 	)
 	if err != nil {
-		panicOnExecErr(err)
+		return 0, fmt.Errorf("cannot create income %q: %w", config.Name, err)
 	}
 
 	id, err := res.LastInsertId()
 	if err != nil {
-		panic(err)
+		return 0, fmt.Errorf("cannot get income id: %w", err)
 	}
 
-	return id
+	return id, nil
 }
 
-func (sdb SqliteDb) SetIncome(id int, amount lib.Currency) {
-	_, err := sdb.handle.Exec(
-		fmt.Sprintf("UPDATE income SET amount=%d WHERE id=%d", amount.GetStoredValue(), id),
-	)
-	if err != nil {
-		panic(err)
+func (sdb SqliteDb) SetIncome(id int, amount lib.Currency) error {
+	if err := requireCurrency(sdb.currencyCode, &amount); err != nil {
+		return fmt.Errorf("cannot set income %d: %w", id, err)
 	}
+
+	if err := sdb.update(INCOME, id, FieldMap{"amount": amount}); err != nil {
+		return fmt.Errorf("cannot set income %d: %w", id, err)
+	}
+	return nil
 }
 
-func (sdb SqliteDb) QueryIncome(qm QueryMap) ([]IncomeRecord, error) {
-	rows := sdb.query(INCOME, qm)
-	var amount int
-	var records []IncomeRecord
+func (sdb SqliteDb) UpdateIncome(id int, config IncomeConfig) error {
+	if err := config.validatePayDate(); err != nil {
+		return fmt.Errorf("cannot update income %d: %w", id, err)
+	}
 
-	for rows.Next() {
-		var record IncomeRecord
-		if err := rows.Scan(
-			&record.ID,
-			&record.Name,
-			&amount,
-			&record.Period,
-		); err != nil {
-			panic(err)
-		}
-		record.Amount = lib.NewCurrencyFromStore(amount, sdb.currencyCode)
-		records = append(records, record)
+	if err := requireCurrency(sdb.currencyCode, &config.Amount); err != nil {
+		return fmt.Errorf("cannot update income %d: %w", id, err)
+	}
+
+	if err := sdb.update(INCOME, id, FieldMap{
+		"name":     config.Name,
+		"amount":   config.Amount,
+		"period":   config.Period,
+		"pay_date": config.payDateValue(),
+	}); err != nil {
+		return fmt.Errorf("cannot update income %d: %w", id, err)
+	}
+	return nil
+}
+
+/*
+DeleteIncome removes an income. Income that already has history is archived
+instead, so its history is kept and it no longer shows up in QueryIncome
+unless WHERE_ARCHIVED is set.
+*/
+func (sdb SqliteDb) DeleteIncome(id int) error {
+	if err := sdb.archiveOrDelete(INCOME, id); err != nil {
+		return fmt.Errorf("cannot delete income %d: %w", id, err)
+	}
+	return nil
+}
+
+func (sdb SqliteDb) QueryIncome(qm QueryMap, opts ...QueryOption) ([]IncomeRecord, error) {
+	records, err := allRows(sdb, INCOME, qm, opts, sdb.scanIncome)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query income: %w", err)
 	}
 
 	if len(records) == 0 {
-		return []IncomeRecord{}, fmt.Errorf("table is empty")
+		return []IncomeRecord{}, fmt.Errorf("income: %w", ErrNoRows)
 	}
 
 	return records, nil
 }
 
-func (sdb SqliteDb) CreateIncomeHistory(config IncomeHistoryConfig) {
-	if _, err := sdb.handle.Exec(
-		sdb.InsertInto(INCOME_HISTORY, config.IncomeID, config.MonthID, config.Amount.GetStoredValue()),
+func (sdb SqliteDb) scanIncome(row scanner) (IncomeRecord, error) {
+	var payDate sql.NullString
+	var record IncomeRecord
+	record.Amount = lib.NewCurrency("", sdb.currencyCode)
+
+	if err := row.Scan(
+		&record.ID,
+		&record.Name,
+		&record.Amount,
+		&record.Period,
+		&payDate,
 	); err != nil {
-		panicOnExecErr(err)
+		return IncomeRecord{}, fmt.Errorf("cannot scan income: %w", err)
 	}
+
+	if payDate.Valid {
+		var err error
+		if record.PayDate, err = time.ParseInLocation(time.DateOnly, payDate.String, time.Local); err != nil {
+			return IncomeRecord{}, fmt.Errorf("cannot parse pay date of income %d: %w", record.ID, err)
+		}
+	}
+	return record, nil
+}
+
+/*
+PaychecksIn returns how many times the income is paid in the month of t.
+Weekly and biweekly income counts the pay days that land in the month,
+starting from its pay date, so some months get five weekly or three
+biweekly paychecks. Yearly income is only paid in the month of its pay
+date, and monthly income is paid once every month.
+
+Weekly and biweekly income without a pay date counts as a single
+paycheck, which is how it was stored before pay dates existed. Yearly
+income without a pay date isn't paid at all until it's given one, since
+there's no telling which month it's paid in.
+*/
+func (config IncomeConfig) PaychecksIn(t time.Time) int {
+	if interval := config.Period.Months(); interval > 1 {
+		if config.PayDate.IsZero() {
+			return 0
+		}
+		diff := monthIndex(t.Year(), int(t.Month())) -
+			monthIndex(config.PayDate.Year(), int(config.PayDate.Month()))
+		if diff%interval != 0 {
+			return 0
+		}
+		return 1
+	}
+
+	var step int
+	switch config.Period {
+	case WEEKLY:
+		step = 7
+	case BIWEEKLY:
+		step = 14
+	default:
+		return 1
+	}
+
+	if config.PayDate.IsZero() {
+		return 1
+	}
+
+	start := dayNumber(t.Year(), t.Month(), 1)
+	end := dayNumber(t.Year(), t.Month()+1, 1)
+	anchor := dayNumber(config.PayDate.Year(), config.PayDate.Month(), config.PayDate.Day())
+
+	// The first pay day on or after the start of the month
+	first := start + ((anchor-start)%step+step)%step
+	if first >= end {
+		return 0
+	}
+	return (end-1-first)/step + 1
 }
 
-func (sdb SqliteDb) QueryIncomeHistory(qm QueryMap) ([]IncomeHistoryRecord, error) {
-	rows := sdb.query(INCOME_HISTORY, qm)
-	var amount int
-	var records []IncomeHistoryRecord
+/*
+MonthlyAmount returns the total the income pays out in the month of t.
+Returns lib.ErrCurrencyOverflow if the total is too large to store.
+*/
+func (config IncomeConfig) MonthlyAmount(t time.Time) (lib.Currency, error) {
+	return config.Amount.CheckedMul(int64(config.PaychecksIn(t)))
+}
 
-	for rows.Next() {
-		var record IncomeHistoryRecord
-		if err := rows.Scan(
-			&record.ID,
-			&record.IncomeID,
-			&record.MonthID,
-			&amount,
-		); err != nil {
-			panic(err)
+func (config IncomeConfig) validatePayDate() error {
+	switch config.Period {
+	case WEEKLY, BIWEEKLY, YEARLY:
+		if config.PayDate.IsZero() {
+			return fmt.Errorf("%w: %s", ErrPayDateRequired, config.Period)
 		}
-		record.Amount = lib.NewCurrencyFromStore(amount, sdb.currencyCode)
-		records = append(records, record)
+	}
+	return nil
+}
+
+func (config IncomeConfig) payDateValue() any {
+	if config.PayDate.IsZero() {
+		return nil
+	}
+	return config.PayDate.Format(time.DateOnly)
+}
+
+// dayNumber counts the days since the unix epoch, ignoring time zones
+func dayNumber(year int, month time.Month, day int) int {
+	return int(time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+func (sdb SqliteDb) CreateIncomeHistory(config IncomeHistoryConfig) error {
+	if err := requireCurrency(sdb.currencyCode, &config.Amount); err != nil {
+		return fmt.Errorf("cannot create history for income %d: %w", config.IncomeID, err)
+	}
+
+	if _, err := sdb.insert(
+		INCOME_HISTORY,
+		config.IncomeID,
+		config.MonthID,
+		config.Amount,
+	); err != nil {
+		return fmt.Errorf("cannot create history for income %d: %w", config.IncomeID, err)
+	}
+	return nil
+}
+
+func (sdb SqliteDb) QueryIncomeHistory(
+	qm QueryMap,
+	opts ...QueryOption,
+) ([]IncomeHistoryRecord, error) {
+	records, err := allRows(sdb, INCOME_HISTORY, qm, opts, sdb.scanIncomeHistory)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query income history: %w", err)
 	}
 
 	if len(records) == 0 {
-		return []IncomeHistoryRecord{}, fmt.Errorf("query returned no results")
+		return []IncomeHistoryRecord{}, fmt.Errorf("income history: %w", ErrNoRows)
 	}
 
 	return records, nil
 }
 
 /*
-AffixIncome tracks an appended amount to an existing income. This could
-be a bonus or overtime amount.
+EachIncomeHistory calls fn with every income history record matching qm,
+one at a time. See ErrStop to stop early. fn must not use the database.
 */
-func (sdb SqliteDb) AffixIncome(historyID int, name string, amount lib.Currency) {
-	if _, err := sdb.handle.Exec(
-		sdb.InsertInto(INCOME_AFFIXES, historyID, name, amount.GetStoredValue()),
+func (sdb SqliteDb) EachIncomeHistory(
+	qm QueryMap,
+	fn func(IncomeHistoryRecord) error,
+	opts ...QueryOption,
+) error {
+	if err := eachRow(sdb, INCOME_HISTORY, qm, opts, sdb.scanIncomeHistory, fn); err != nil {
+		return fmt.Errorf("cannot query income history: %w", err)
+	}
+	return nil
+}
+
+func (sdb SqliteDb) scanIncomeHistory(row scanner) (IncomeHistoryRecord, error) {
+	var record IncomeHistoryRecord
+	record.Amount = lib.NewCurrency("", sdb.currencyCode)
+
+	if err := row.Scan(
+		&record.ID,
+		&record.IncomeID,
+		&record.MonthID,
+		&record.Amount,
 	); err != nil {
-		panic(err)
+		return IncomeHistoryRecord{}, fmt.Errorf("cannot scan income history: %w", err)
 	}
+	return record, nil
 }
 
-func (sdb SqliteDb) QueryAffixIncome(qm QueryMap) ([]AffixIncomeRecord, error) {
-	rows := sdb.query(INCOME_AFFIXES, qm)
-	var amount int
-	var records []AffixIncomeRecord
+func (sdb SqliteDb) UpdateIncomeHistory(id int, config IncomeHistoryConfig) error {
+	if err := requireCurrency(sdb.currencyCode, &config.Amount); err != nil {
+		return fmt.Errorf("cannot update income history %d: %w", id, err)
+	}
 
-	for rows.Next() {
-		var record AffixIncomeRecord
-		if err := rows.Scan(
-			&record.ID,
-			&record.IncomeHistoryID,
-			&record.Name,
-			&amount,
-		); err != nil {
-			panic(err)
-		}
-		record.Amount = lib.NewCurrencyFromStore(amount, sdb.currencyCode)
-		records = append(records, record)
+	if err := sdb.update(INCOME_HISTORY, id, FieldMap{
+		"income_id": config.IncomeID,
+		"month_id":  config.MonthID,
+		"amount":    config.Amount,
+	}); err != nil {
+		return fmt.Errorf("cannot update income history %d: %w", id, err)
+	}
+	return nil
+}
+
+/*
+DeleteIncomeHistory removes an income history record along with all of
+its affixes.
+*/
+func (sdb SqliteDb) DeleteIncomeHistory(id int) error {
+	if err := sdb.deleteByID(INCOME_HISTORY, id); err != nil {
+		return fmt.Errorf("cannot delete income history %d: %w", id, err)
+	}
+	return nil
+}
+
+/*
+AffixIncome tracks an appended amount to an existing income. This could
+be a bonus or overtime amount.
+*/
+func (sdb SqliteDb) AffixIncome(historyID int, name string, amount lib.Currency) error {
+	if err := requireCurrency(sdb.currencyCode, &amount); err != nil {
+		return fmt.Errorf("cannot affix %q to income history %d: %w", name, historyID, err)
+	}
+
+	if _, err := sdb.insert(INCOME_AFFIXES, historyID, name, amount); err != nil {
+		return fmt.Errorf("cannot affix %q to income history %d: %w", name, historyID, err)
+	}
+	return nil
+}
+
+func (sdb SqliteDb) QueryAffixIncome(
+	qm QueryMap,
+	opts ...QueryOption,
+) ([]AffixIncomeRecord, error) {
+	records, err := allRows(sdb, INCOME_AFFIXES, qm, opts, sdb.scanIncomeAffix)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query income affixes: %w", err)
 	}
 
 	if len(records) == 0 {
-		return []AffixIncomeRecord{}, fmt.Errorf("no results from query")
+		return []AffixIncomeRecord{}, fmt.Errorf("income affixes: %w", ErrNoRows)
 	}
 
 	return records, nil
 }
+
+func (sdb SqliteDb) scanIncomeAffix(row scanner) (AffixIncomeRecord, error) {
+	var record AffixIncomeRecord
+	record.Amount = lib.NewCurrency("", sdb.currencyCode)
+
+	if err := row.Scan(
+		&record.ID,
+		&record.IncomeHistoryID,
+		&record.Name,
+		&record.Amount,
+	); err != nil {
+		return AffixIncomeRecord{}, fmt.Errorf("cannot scan income affix: %w", err)
+	}
+	return record, nil
+}
+
+func (sdb SqliteDb) UpdateIncomeAffix(id int, name string, amount lib.Currency) error {
+	if err := requireCurrency(sdb.currencyCode, &amount); err != nil {
+		return fmt.Errorf("cannot update income affix %d: %w", id, err)
+	}
+
+	if err := sdb.update(INCOME_AFFIXES, id, FieldMap{
+		"name":   name,
+		"amount": amount,
+	}); err != nil {
+		return fmt.Errorf("cannot update income affix %d: %w", id, err)
+	}
+	return nil
+}
+
+func (sdb SqliteDb) DeleteIncomeAffix(id int) error {
+	if err := sdb.deleteByID(INCOME_AFFIXES, id); err != nil {
+		return fmt.Errorf("cannot delete income affix %d: %w", id, err)
+	}
+	return nil
+}
@@ -1,7 +1,7 @@
 package sqlite
 
 import (
-	"path/filepath"
+	"errors"
 	"testing"
 	"time"
 
@@ -40,7 +40,7 @@ func TestCreateIncome(t *testing.T) {
 			},
 		},
 		{
-			should: "panic on amount constraint violation",
+			should: "error on amount constraint violation",
 			actual: []IncomeConfig{
 				{Name: "test", Amount: lib.NewCurrency("-5", lib.USD)},
 			},
@@ -51,24 +51,21 @@ func TestCreateIncome(t *testing.T) {
 	for _, mock := range table {
 		t.Run("should "+mock.should, func(t *testing.T) {
 			t.Parallel()
-			dir := t.TempDir()
 			a := assert.New(t)
 			r := require.New(t)
-
-			db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-			defer db.Close()
+			db := newMockDb(t)
 
 			if mock.expectedError != nil {
 				for _, iConfig := range mock.actual {
-					a.PanicsWithValue(mock.expectedError, func() {
-						db.CreateIncome(iConfig)
-					})
+					_, err := db.CreateIncome(iConfig)
+					a.ErrorIs(err, mock.expectedError)
 				}
 				return
 			}
 
 			for _, iConfig := range mock.actual {
-				db.CreateIncome(iConfig)
+				_, err := db.CreateIncome(iConfig)
+				r.NoError(err)
 			}
 
 			res, err := db.QueryIncome(QueryMap{})
@@ -78,27 +75,25 @@ func TestCreateIncome(t *testing.T) {
 		})
 	}
 
-	t.Run("should panic on unique name constraint violation", func(t *testing.T) {
+	t.Run("should error on unique name constraint violation", func(t *testing.T) {
 		t.Parallel()
-		dir := t.TempDir()
 		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
 
-		db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-		defer db.Close()
-
-		db.CreateIncome(IncomeConfig{
+		_, err := db.CreateIncome(IncomeConfig{
 			Name:   "name",
 			Amount: lib.NewCurrency("13.37", lib.USD),
 			Period: MONTHLY,
 		})
+		r.NoError(err)
 
-		a.PanicsWithValue(ErrUniqueName, func() {
-			db.CreateIncome(IncomeConfig{
-				Name:   "name",
-				Amount: lib.NewCurrency("133.7", lib.USD),
-				Period: MONTHLY,
-			})
+		_, err = db.CreateIncome(IncomeConfig{
+			Name:   "name",
+			Amount: lib.NewCurrency("133.7", lib.USD),
+			Period: MONTHLY,
 		})
+		a.ErrorIs(err, ErrUniqueName)
 	})
 }
 
@@ -116,9 +111,10 @@ func TestCreateIncomeHistory(t *testing.T) {
 			should: "create an income history record",
 			incomes: []IncomeConfig{
 				{
-					Name:   "test",
-					Amount: lib.NewCurrency("250", lib.USD),
-					Period: BIWEEKLY,
+					Name:    "test",
+					Amount:  lib.NewCurrency("250", lib.USD),
+					Period:  BIWEEKLY,
+					PayDate: time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local),
 				},
 			},
 			actual: []IncomeHistoryConfig{
@@ -140,12 +136,13 @@ func TestCreateIncomeHistory(t *testing.T) {
 			},
 		},
 		{
-			should: "panic on amount constraint violation",
+			should: "error on amount constraint violation",
 			incomes: []IncomeConfig{
 				{
-					Name:   "test",
-					Amount: lib.NewCurrency("250", lib.USD),
-					Period: BIWEEKLY,
+					Name:    "test",
+					Amount:  lib.NewCurrency("250", lib.USD),
+					Period:  BIWEEKLY,
+					PayDate: time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local),
 				},
 			},
 			actual: []IncomeHistoryConfig{
@@ -158,12 +155,13 @@ func TestCreateIncomeHistory(t *testing.T) {
 			expectedError: ErrAmountInvalid,
 		},
 		{
-			should: "panic on foreign key constraint violation",
+			should: "error on foreign key constraint violation",
 			incomes: []IncomeConfig{
 				{
-					Name:   "test",
-					Amount: lib.NewCurrency("250", lib.USD),
-					Period: BIWEEKLY,
+					Name:    "test",
+					Amount:  lib.NewCurrency("250", lib.USD),
+					Period:  BIWEEKLY,
+					PayDate: time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local),
 				},
 			},
 			actual: []IncomeHistoryConfig{
@@ -185,30 +183,26 @@ func TestCreateIncomeHistory(t *testing.T) {
 	for _, mock := range table {
 		t.Run("should "+mock.should, func(t *testing.T) {
 			t.Parallel()
-			dir := t.TempDir()
 			a := assert.New(t)
 			r := require.New(t)
+			db := newMockDb(t)
 
-			db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-			defer db.Close()
-
-			db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local))
+			r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
 
 			for _, iConfig := range mock.incomes {
-				db.CreateIncome(iConfig)
+				_, err := db.CreateIncome(iConfig)
+				r.NoError(err)
 			}
 
 			if mock.expectedError != nil {
 				for _, ihConfig := range mock.actual {
-					a.PanicsWithValue(mock.expectedError, func() {
-						db.CreateIncomeHistory(ihConfig)
-					})
+					a.ErrorIs(db.CreateIncomeHistory(ihConfig), mock.expectedError)
 				}
 				return
 			}
 
 			for _, ihConfig := range mock.actual {
-				db.CreateIncomeHistory(ihConfig)
+				r.NoError(db.CreateIncomeHistory(ihConfig))
 			}
 
 			res, err := db.QueryIncomeHistory(QueryMap{})
@@ -218,3 +212,420 @@ func TestCreateIncomeHistory(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateIncome(t *testing.T) {
+	t.Run("should update income, history and affixes", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		_, err := db.CreateIncome(IncomeConfig{
+			Name:    "job",
+			Amount:  lib.NewCurrency("1000", lib.USD),
+			Period:  BIWEEKLY,
+			PayDate: time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local),
+		})
+		r.NoError(err)
+		r.NoError(db.CreateIncomeHistory(IncomeHistoryConfig{
+			IncomeID: 1,
+			MonthID:  1,
+			Amount:   lib.NewCurrency("2000", lib.USD),
+		}))
+		r.NoError(db.AffixIncome(1, "bonus", lib.NewCurrency("100", lib.USD)))
+
+		updated := IncomeConfig{
+			Name:    "new job",
+			Amount:  lib.NewCurrency("1200", lib.USD),
+			Period:  WEEKLY,
+			PayDate: time.Date(2024, 1, 12, 0, 0, 0, 0, time.Local),
+		}
+		r.NoError(db.UpdateIncome(1, updated))
+		r.NoError(db.UpdateIncomeHistory(1, IncomeHistoryConfig{
+			IncomeID: 1,
+			MonthID:  1,
+			Amount:   lib.NewCurrency("4800", lib.USD),
+		}))
+		r.NoError(db.UpdateIncomeAffix(1, "overtime", lib.NewCurrency("250", lib.USD)))
+
+		income, err := db.QueryIncome(QueryMap{})
+		r.NoError(err)
+		a.Equal([]IncomeRecord{{ID: 1, IncomeConfig: updated}}, income)
+
+		history, err := db.QueryIncomeHistory(QueryMap{})
+		r.NoError(err)
+		a.Equal(lib.NewCurrency("4800", lib.USD), history[0].Amount)
+
+		affixes, err := db.QueryAffixIncome(QueryMap{})
+		r.NoError(err)
+		a.Equal([]AffixIncomeRecord{{
+			ID:              1,
+			IncomeHistoryID: 1,
+			Name:            "overtime",
+			Amount:          lib.NewCurrency("250", lib.USD),
+		}}, affixes)
+	})
+
+	t.Run("should error when updating with invalid values", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		_, err := db.CreateIncome(IncomeConfig{
+			Name:   "job",
+			Amount: lib.NewCurrency("1000", lib.USD),
+			Period: MONTHLY,
+		})
+		r.NoError(err)
+
+		err = db.UpdateIncome(1, IncomeConfig{
+			Name:   "job",
+			Amount: lib.NewCurrency("-1", lib.USD),
+			Period: MONTHLY,
+		})
+		a.ErrorIs(err, ErrAmountInvalid)
+
+		a.ErrorIs(db.SetIncome(2, lib.NewCurrency("1", lib.USD)), ErrNoRows)
+		a.ErrorIs(db.UpdateIncomeAffix(1, "bonus", lib.NewCurrency("1", lib.USD)), ErrNoRows)
+	})
+
+	t.Run("should refuse amounts in another currency than the bank", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+		yen := lib.NewCurrency("1000", lib.JPY)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		_, err := db.CreateIncome(IncomeConfig{
+			Name:   "job",
+			Amount: lib.NewCurrency("1000", lib.USD),
+			Period: MONTHLY,
+		})
+		r.NoError(err)
+		r.NoError(db.CreateIncomeHistory(IncomeHistoryConfig{
+			IncomeID: 1,
+			MonthID:  1,
+			Amount:   lib.NewCurrency("1000", lib.USD),
+		}))
+		r.NoError(db.AffixIncome(1, "bonus", lib.NewCurrency("100", lib.USD)))
+
+		_, err = db.CreateIncome(IncomeConfig{Name: "side job", Amount: yen, Period: MONTHLY})
+		a.ErrorIs(err, lib.ErrCurrencyKind)
+		a.ErrorIs(db.SetIncome(1, yen), lib.ErrCurrencyKind)
+		a.ErrorIs(
+			db.UpdateIncome(1, IncomeConfig{Name: "job", Amount: yen, Period: MONTHLY}),
+			lib.ErrCurrencyKind,
+		)
+		a.ErrorIs(db.CreateIncomeHistory(IncomeHistoryConfig{
+			IncomeID: 1,
+			MonthID:  1,
+			Amount:   yen,
+		}), lib.ErrCurrencyKind)
+		a.ErrorIs(db.UpdateIncomeHistory(1, IncomeHistoryConfig{
+			IncomeID: 1,
+			MonthID:  1,
+			Amount:   yen,
+		}), lib.ErrCurrencyKind)
+		a.ErrorIs(db.AffixIncome(1, "overtime", yen), lib.ErrCurrencyKind)
+		a.ErrorIs(db.UpdateIncomeAffix(1, "bonus", yen), lib.ErrCurrencyKind)
+
+		income, err := db.QueryIncome(QueryMap{})
+		r.NoError(err)
+		r.Len(income, 1)
+		a.Equal(lib.NewCurrency("1000", lib.USD), income[0].Amount)
+
+		affixes, err := db.QueryAffixIncome(QueryMap{})
+		r.NoError(err)
+		a.Equal(lib.NewCurrency("100", lib.USD), affixes[0].Amount)
+	})
+}
+
+func TestDeleteIncome(t *testing.T) {
+	job := IncomeConfig{
+		Name:   "job",
+		Amount: lib.NewCurrency("1000", lib.USD),
+		Period: MONTHLY,
+	}
+
+	t.Run("should delete income without history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		_, err := db.CreateIncome(job)
+		r.NoError(err)
+		r.NoError(db.DeleteIncome(1))
+
+		_, err = db.QueryIncome(QueryMap{WHERE_ARCHIVED: true})
+		a.ErrorIs(err, ErrNoRows)
+	})
+
+	t.Run("should archive income with history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		_, err := db.CreateIncome(job)
+		r.NoError(err)
+		r.NoError(db.CreateIncomeHistory(IncomeHistoryConfig{
+			IncomeID: 1,
+			MonthID:  1,
+			Amount:   job.Amount,
+		}))
+
+		r.NoError(db.DeleteIncome(1))
+
+		_, err = db.QueryIncome(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+
+		archived, err := db.QueryIncome(QueryMap{WHERE_ARCHIVED: true})
+		r.NoError(err)
+		a.Equal([]IncomeRecord{{ID: 1, IncomeConfig: job}}, archived)
+
+		history, err := db.QueryIncomeHistory(QueryMap{})
+		r.NoError(err)
+		a.Len(history, 1)
+	})
+
+	t.Run("should delete income history along with its affixes", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		_, err := db.CreateIncome(job)
+		r.NoError(err)
+		for range 2 {
+			r.NoError(db.CreateIncomeHistory(IncomeHistoryConfig{
+				IncomeID: 1,
+				MonthID:  1,
+				Amount:   job.Amount,
+			}))
+		}
+		r.NoError(db.AffixIncome(1, "bonus", lib.NewCurrency("100", lib.USD)))
+		r.NoError(db.AffixIncome(2, "overtime", lib.NewCurrency("50", lib.USD)))
+
+		r.NoError(db.DeleteIncomeHistory(1))
+
+		history, err := db.QueryIncomeHistory(QueryMap{})
+		r.NoError(err)
+		r.Len(history, 1)
+		a.Equal(2, history[0].ID)
+
+		affixes, err := db.QueryAffixIncome(QueryMap{})
+		r.NoError(err)
+		r.Len(affixes, 1)
+		a.Equal("overtime", affixes[0].Name)
+	})
+
+	t.Run("should delete an income affix", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		_, err := db.CreateIncome(job)
+		r.NoError(err)
+		r.NoError(db.CreateIncomeHistory(IncomeHistoryConfig{
+			IncomeID: 1,
+			MonthID:  1,
+			Amount:   job.Amount,
+		}))
+		r.NoError(db.AffixIncome(1, "bonus", lib.NewCurrency("100", lib.USD)))
+
+		r.NoError(db.DeleteIncomeAffix(1))
+
+		_, err = db.QueryAffixIncome(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+		a.ErrorIs(db.DeleteIncomeAffix(1), ErrNoRows)
+	})
+}
+
+func TestIncomePaychecks(t *testing.T) {
+	friday := time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local)
+	month := func(year int, m time.Month) time.Time {
+		return time.Date(year, m, 1, 0, 0, 0, 0, time.Local)
+	}
+
+	type MockTable struct {
+		should   string
+		income   IncomeConfig
+		month    time.Time
+		expected int
+	}
+
+	table := []MockTable{
+		{
+			should:   "pay weekly income four times",
+			income:   IncomeConfig{Period: WEEKLY, PayDate: friday},
+			month:    month(2024, 2),
+			expected: 4,
+		},
+		{
+			should:   "pay weekly income on five fridays",
+			income:   IncomeConfig{Period: WEEKLY, PayDate: friday},
+			month:    month(2024, 3),
+			expected: 5,
+		},
+		{
+			should:   "pay biweekly income twice",
+			income:   IncomeConfig{Period: BIWEEKLY, PayDate: friday},
+			month:    month(2024, 5),
+			expected: 2,
+		},
+		{
+			should:   "pay biweekly income three times",
+			income:   IncomeConfig{Period: BIWEEKLY, PayDate: friday},
+			month:    month(2024, 8),
+			expected: 3,
+		},
+		{
+			should:   "count paychecks across the new year",
+			income:   IncomeConfig{Period: BIWEEKLY, PayDate: friday},
+			month:    month(2025, 1),
+			expected: 3,
+		},
+		{
+			should:   "count paychecks before the pay date",
+			income:   IncomeConfig{Period: WEEKLY, PayDate: friday},
+			month:    month(2023, 12),
+			expected: 5,
+		},
+		{
+			should:   "pay monthly income once",
+			income:   IncomeConfig{Period: MONTHLY},
+			month:    month(2024, 3),
+			expected: 1,
+		},
+		{
+			should:   "pay yearly income in the month of its pay date",
+			income:   IncomeConfig{Period: YEARLY, PayDate: friday},
+			month:    month(2025, 1),
+			expected: 1,
+		},
+		{
+			should:   "not pay yearly income in any other month",
+			income:   IncomeConfig{Period: YEARLY, PayDate: friday},
+			month:    month(2024, 12),
+			expected: 0,
+		},
+		{
+			should:   "not pay yearly income without a pay date",
+			income:   IncomeConfig{Period: YEARLY},
+			month:    month(2024, 1),
+			expected: 0,
+		},
+	}
+
+	for _, mock := range table {
+		t.Run("should "+mock.should, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, mock.expected, mock.income.PaychecksIn(mock.month))
+		})
+	}
+
+	t.Run("should roll the monthly total over", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		_, err := db.CreateIncome(IncomeConfig{
+			Name:    "job",
+			Amount:  lib.NewCurrency("1250.50", lib.USD),
+			Period:  BIWEEKLY,
+			PayDate: friday,
+		})
+		r.NoError(err)
+
+		march, err := db.StartNewMonth(month(2024, 3))
+		r.NoError(err)
+		april, err := db.StartNewMonth(month(2024, 4))
+		r.NoError(err)
+
+		history, err := db.QueryIncomeHistory(QueryMap{WHERE_MONTH_ID: march.ID})
+		r.NoError(err)
+		a.Equal(lib.NewCurrency("3751.50", lib.USD), history[0].Amount)
+
+		history, err = db.QueryIncomeHistory(QueryMap{WHERE_MONTH_ID: april.ID})
+		r.NoError(err)
+		a.Equal(lib.NewCurrency("2501", lib.USD), history[0].Amount)
+	})
+
+	t.Run("should only roll yearly income over once a year", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		_, err := db.CreateIncome(IncomeConfig{
+			Name:    "bonus",
+			Amount:  lib.NewCurrency("1200", lib.USD),
+			Period:  YEARLY,
+			PayDate: time.Date(2024, 1, 15, 0, 0, 0, 0, time.Local),
+		})
+		r.NoError(err)
+
+		paid := map[int]lib.Currency{}
+		for offset := range 14 {
+			m, err := db.StartNewMonth(month(2023, 12).AddDate(0, offset, 0))
+			r.NoError(err)
+
+			history, err := db.QueryIncomeHistory(QueryMap{WHERE_MONTH_ID: m.ID})
+			if errors.Is(err, ErrNoRows) {
+				continue
+			}
+			r.NoError(err)
+			r.Len(history, 1)
+			paid[monthIndex(m.Year, m.Month)] = history[0].Amount
+		}
+
+		a.Equal(map[int]lib.Currency{
+			monthIndex(2024, 1): lib.NewCurrency("1200", lib.USD),
+			monthIndex(2025, 1): lib.NewCurrency("1200", lib.USD),
+		}, paid)
+	})
+
+	t.Run("should require a pay date for weekly, biweekly and yearly income", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		_, err := db.CreateIncome(IncomeConfig{
+			Name:   "job",
+			Amount: lib.NewCurrency("500", lib.USD),
+			Period: WEEKLY,
+		})
+		a.ErrorIs(err, ErrPayDateRequired)
+
+		_, err = db.CreateIncome(IncomeConfig{
+			Name:   "job",
+			Amount: lib.NewCurrency("500", lib.USD),
+			Period: MONTHLY,
+		})
+		r.NoError(err)
+
+		err = db.UpdateIncome(1, IncomeConfig{
+			Name:   "job",
+			Amount: lib.NewCurrency("500", lib.USD),
+			Period: BIWEEKLY,
+		})
+		a.ErrorIs(err, ErrPayDateRequired)
+
+		err = db.UpdateIncome(1, IncomeConfig{
+			Name:   "job",
+			Amount: lib.NewCurrency("500", lib.USD),
+			Period: YEARLY,
+		})
+		a.ErrorIs(err, ErrPayDateRequired)
+	})
+}
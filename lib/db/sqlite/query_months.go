@@ -1,8 +1,12 @@
 package sqlite
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
+
+	"github.com/jaeiya/billbank/lib"
 )
 
 var ErrDirtyDate = fmt.Errorf("not a clean date")
@@ -13,43 +17,351 @@ type MonthRecord struct {
 	Month int
 }
 
-func (sdb SqliteDb) CreateMonth(t time.Time) {
-	// Make sure any prior date arithmetic, used a clean date
-	isClean := t.Day() == 1 &&
-		t.Hour() == 0 &&
-		t.Minute() == 0 &&
-		t.Second() == 0 &&
-		t.Nanosecond() == 0
+// Time is the clean date of the first day of the month
+func (m MonthRecord) Time() time.Time {
+	return time.Date(m.Year, time.Month(m.Month), 1, 0, 0, 0, 0, time.Local)
+}
+
+/*
+PreviousTime is the clean date of the month before. January goes back to
+December of the year before. See PreviousMonth for its record.
+*/
+func (m MonthRecord) PreviousTime() time.Time {
+	return m.Time().AddDate(0, -1, 0)
+}
+
+/*
+NextTime is the clean date of the month after. December goes on to January
+of the year after. See NextMonth for its record.
+*/
+func (m MonthRecord) NextTime() time.Time {
+	return m.Time().AddDate(0, 1, 0)
+}
+
+/*
+CreateMonth adds the month of t, which must be a clean date. Each month can
+only be created once, so creating it again returns ErrDuplicateMonth. Use
+GetOrCreateMonth to get the month that already exists instead.
+*/
+func (sdb SqliteDb) CreateMonth(t time.Time) error {
+	if !isCleanDate(t) {
+		return fmt.Errorf("cannot create month from %s: %w", t, ErrDirtyDate)
+	}
+	if _, err := sdb.insert(MONTHS, t.Year(), t.Month()); err != nil {
+		return fmt.Errorf("cannot create month %d-%02d: %w", t.Year(), t.Month(), err)
+	}
+	return nil
+}
+
+/*
+UpdateMonth moves a month to the month of t. Returns ErrDuplicateMonth if
+that month already exists.
+*/
+func (sdb SqliteDb) UpdateMonth(id int, t time.Time) error {
+	if !isCleanDate(t) {
+		return fmt.Errorf("cannot update month %d to %s: %w", id, t, ErrDirtyDate)
+	}
+	if err := sdb.update(MONTHS, id, FieldMap{"year": t.Year(), "month": t.Month()}); err != nil {
+		return fmt.Errorf("cannot update month %d: %w", id, err)
+	}
+	return nil
+}
+
+/*
+DeleteMonth removes a month. Months that are still referenced by any
+history fail with ErrForeignKey.
+*/
+func (sdb SqliteDb) DeleteMonth(id int) error {
+	if err := sdb.deleteByID(MONTHS, id); err != nil {
+		return fmt.Errorf("cannot delete month %d: %w", id, err)
+	}
+	return nil
+}
 
-	if !isClean {
-		panic(ErrDirtyDate)
+func (sdb SqliteDb) QueryMonths(qm QueryMap, opts ...QueryOption) ([]MonthRecord, error) {
+	records, err := allRows(sdb, MONTHS, qm, opts, scanMonth)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query months: %w", err)
 	}
-	if _, err := sdb.handle.Exec(
-		sdb.InsertInto(MONTHS, t.Year(), t.Month()),
+
+	if len(records) == 0 {
+		return []MonthRecord{}, fmt.Errorf("months: %w", ErrNoRows)
+	}
+
+	return records, nil
+}
+
+func scanMonth(row scanner) (MonthRecord, error) {
+	var record MonthRecord
+	if err := row.Scan(
+		&record.ID,
+		&record.Year,
+		&record.Month,
 	); err != nil {
-		panicOnExecErr(err)
+		return MonthRecord{}, fmt.Errorf("cannot scan month: %w", err)
+	}
+	return record, nil
+}
+
+/*
+StartNewMonth rolls every active template over into the month of t. Credit
+cards and bank accounts each get a history row for the month with balances
+carried over from the latest earlier month, income gets the total of its
+paychecks in the months it's paid, and bills only get one in the months
+they're due. The bank's current month is then advanced.
+
+Running it again for the same month only fills in templates that are
+still missing a history row, so it's safe to call more than once. The
+month is started in a single transaction, so it's never left half rolled
+over. Bills that are still missing an anchor month are skipped, see
+QueryBillsWithoutAnchor.
+*/
+func (sdb SqliteDb) StartNewMonth(t time.Time) (MonthRecord, error) {
+	if !isCleanDate(t) {
+		return MonthRecord{}, fmt.Errorf("cannot start month from %s: %w", t, ErrDirtyDate)
+	}
+
+	var month MonthRecord
+	err := sdb.WithTx(func(tx SqliteDb) (err error) {
+		month, err = tx.startMonth(t)
+		return err
+	})
+	if err != nil {
+		return MonthRecord{}, err
 	}
+	return month, nil
 }
 
-func (sdb SqliteDb) QueryMonths(qm QueryMap) ([]MonthRecord, error) {
-	rows := sdb.query(MONTHS, qm)
-	var records []MonthRecord
+// startMonth does the work of StartNewMonth, within its transaction
+func (sdb SqliteDb) startMonth(t time.Time) (MonthRecord, error) {
+	month, err := sdb.GetOrCreateMonth(t)
+	if err != nil {
+		return MonthRecord{}, fmt.Errorf("cannot start month %d-%02d: %w", t.Year(), t.Month(), err)
+	}
+
+	// Bills only show up in the months they're due
+	dueBills, err := sdb.QueryBillsDueIn(t)
+	if err != nil && !errors.Is(err, ErrNoRows) {
+		return MonthRecord{}, fmt.Errorf("cannot start month %d-%02d: %w", t.Year(), t.Month(), err)
+	}
+
+	// Income is stored as the total of every paycheck in the month
+	incomes, err := sdb.QueryIncome(QueryMap{})
+	if err != nil && !errors.Is(err, ErrNoRows) {
+		return MonthRecord{}, fmt.Errorf("cannot start month %d-%02d: %w", t.Year(), t.Month(), err)
+	}
+
+	conn := sdb.conn()
+	for _, income := range incomes {
+		// There's nothing to roll over in months the income isn't paid
+		if income.PaychecksIn(t) == 0 {
+			continue
+		}
 
-	for rows.Next() {
-		var record MonthRecord
-		if err := rows.Scan(
-			&record.ID,
-			&record.Year,
-			&record.Month,
+		amount, err := income.MonthlyAmount(t)
+		if err != nil {
+			return MonthRecord{}, fmt.Errorf("cannot roll income %d over: %w", income.ID, err)
+		}
+		if _, err := conn.Exec(
+			`INSERT INTO income_history (income_id, month_id, amount)
+			 SELECT ?1, ?2, ?3 WHERE NOT EXISTS (
+			     SELECT 1 FROM income_history WHERE income_id = ?1 AND month_id = ?2
+			 )`,
+			income.ID,
+			month.ID,
+			amount,
 		); err != nil {
-			panic(err)
+			return MonthRecord{}, fmt.Errorf(
+				"cannot roll income %d over into month %d: %w",
+				income.ID,
+				month.ID,
+				toExecErr(err),
+			)
 		}
-		records = append(records, record)
 	}
 
-	if len(records) == 0 {
-		return []MonthRecord{}, fmt.Errorf("empty month table")
+	for _, bill := range dueBills {
+		if _, err := conn.Exec(
+			`INSERT INTO bill_history (bill_id, month_id, amount, due_day)
+			 SELECT ?1, ?2, ?3, ?4 WHERE NOT EXISTS (
+			     SELECT 1 FROM bill_history WHERE bill_id = ?1 AND month_id = ?2
+			 )`,
+			bill.ID,
+			month.ID,
+			bill.Amount,
+			bill.DueDay,
+		); err != nil {
+			return MonthRecord{}, fmt.Errorf(
+				"cannot roll bill %d over into month %d: %w",
+				bill.ID,
+				month.ID,
+				toExecErr(err),
+			)
+		}
 	}
 
-	return records, nil
+	// Months are compared by their index, so the previous year's December
+	// comes right before January.
+	index := monthIndex(month.Year, month.Month)
+	for _, rollover := range rolloverQueries {
+		if _, err := conn.Exec(rollover.query, month.ID, index, month.ID); err != nil {
+			return MonthRecord{}, fmt.Errorf(
+				"cannot roll %s over into month %d: %w",
+				rollover.table,
+				month.ID,
+				toExecErr(err),
+			)
+		}
+	}
+
+	if err := advanceCurrentMonth(conn, sdb.currencyCode, month); err != nil {
+		return MonthRecord{}, fmt.Errorf("cannot advance current month: %w", err)
+	}
+
+	return month, nil
+}
+
+/*
+GetCurrentMonth returns the month that was last started with StartNewMonth.
+Returns ErrNoRows if a month has never been started.
+*/
+func (sdb SqliteDb) GetCurrentMonth() (MonthRecord, error) {
+	var record MonthRecord
+	err := sdb.conn().QueryRow(
+		`SELECT m.id, m.year, m.month FROM bank b
+		 JOIN months m ON m.id = b.current_month_id
+		 WHERE b.id = 1`,
+	).Scan(&record.ID, &record.Year, &record.Month)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return MonthRecord{}, fmt.Errorf("current month: %w", ErrNoRows)
+	}
+	if err != nil {
+		return MonthRecord{}, fmt.Errorf("cannot scan current month: %w", err)
+	}
+	return record, nil
+}
+
+/*
+GetMonth returns the month that t falls in. Returns ErrNoRows if the month
+hasn't been created.
+*/
+func (sdb SqliteDb) GetMonth(t time.Time) (MonthRecord, error) {
+	months, err := sdb.QueryMonths(QueryMap{WHERE_YEAR: t.Year(), WHERE_MONTH: t.Month()})
+	if err != nil {
+		return MonthRecord{}, err
+	}
+	return months[0], nil
+}
+
+/*
+PreviousMonth returns the month before m. Returns ErrNoRows if it hasn't
+been created.
+*/
+func (sdb SqliteDb) PreviousMonth(m MonthRecord) (MonthRecord, error) {
+	return sdb.GetMonth(m.PreviousTime())
+}
+
+/*
+NextMonth returns the month after m. Returns ErrNoRows if it hasn't been
+created.
+*/
+func (sdb SqliteDb) NextMonth(m MonthRecord) (MonthRecord, error) {
+	return sdb.GetMonth(m.NextTime())
+}
+
+// GetOrCreateMonth returns the month that t falls in, creating it if needed
+func (sdb SqliteDb) GetOrCreateMonth(t time.Time) (MonthRecord, error) {
+	var month MonthRecord
+	err := sdb.WithTx(func(tx SqliteDb) (err error) {
+		month, err = tx.GetMonth(t)
+		if !errors.Is(err, ErrNoRows) {
+			return err
+		}
+
+		if err := tx.CreateMonth(time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())); err != nil {
+			return err
+		}
+		month, err = tx.GetMonth(t)
+		return err
+	})
+	if err != nil {
+		return MonthRecord{}, err
+	}
+	return month, nil
+}
+
+/*
+advanceCurrentMonth points the bank at month, creating the bank row the
+first time around. The current month never moves backwards, so starting an
+older month to backfill it leaves the current month alone.
+*/
+func advanceCurrentMonth(conn dbConn, cc lib.CurrencyCode, month MonthRecord) error {
+	_, err := conn.Exec(
+		`INSERT INTO bank (id, currency_code, current_month_id) VALUES (1, ?, ?)
+		 ON CONFLICT (id) DO UPDATE SET current_month_id = excluded.current_month_id
+		 WHERE current_month_id IS NULL OR ? > (
+		     SELECT year * 12 + month FROM months WHERE id = current_month_id
+		 )`,
+		cc.String(),
+		month.ID,
+		monthIndex(month.Year, month.Month),
+	)
+	return err
+}
+
+func monthIndex(year, month int) int {
+	return year*12 + month
+}
+
+/*
+rolloverQueries copy the active templates into their history tables. Each
+query takes the new month id, the month's index and the month id again,
+and skips templates that already have history for that month.
+*/
+var rolloverQueries = []struct {
+	table Table
+	query string
+}{
+	{
+		// What was paid off last month comes off of the carried balance
+		table: CREDIT_CARDS,
+		query: `INSERT INTO credit_card_history
+				(card_id, month_id, balance, credit_limit, due_day, period)
+			SELECT c.id, ?1, COALESCE((
+				SELECT h.balance - COALESCE(h.paid_amount, 0) FROM credit_card_history h
+				JOIN months m ON m.id = h.month_id
+				WHERE h.card_id = c.id AND m.year * 12 + m.month < ?2
+				ORDER BY m.year DESC, m.month DESC, h.id DESC
+				LIMIT 1
+			), 0), c.credit_limit, c.due_day, 'monthly' FROM credit_cards c
+			WHERE c.archived = 0 AND NOT EXISTS (
+				SELECT 1 FROM credit_card_history WHERE card_id = c.id AND month_id = ?3
+			)
+			ORDER BY c.id`,
+	},
+	{
+		table: BANK_ACCOUNTS,
+		query: `INSERT INTO bank_account_history (account_id, month_id, balance)
+			SELECT a.id, ?1, COALESCE((
+				SELECT h.balance FROM bank_account_history h
+				JOIN months m ON m.id = h.month_id
+				WHERE h.account_id = a.id AND m.year * 12 + m.month < ?2
+				ORDER BY m.year DESC, m.month DESC, h.id DESC
+				LIMIT 1
+			), 0) FROM bank_accounts a
+			WHERE a.archived = 0 AND NOT EXISTS (
+				SELECT 1 FROM bank_account_history WHERE account_id = a.id AND month_id = ?3
+			)
+			ORDER BY a.id`,
+	},
+}
+
+// isCleanDate makes sure any prior date arithmetic, used a clean date
+func isCleanDate(t time.Time) bool {
+	return t.Day() == 1 &&
+		t.Hour() == 0 &&
+		t.Minute() == 0 &&
+		t.Second() == 0 &&
+		t.Nanosecond() == 0
 }
@@ -1,6 +1,7 @@
 package sqlite
 
 import (
+	"fmt"
 	"path/filepath"
 	"testing"
 	"time"
@@ -29,7 +30,7 @@ func TestCreateMonth(t *testing.T) {
 			},
 		},
 		{
-			should:        "panic on dirty date",
+			should:        "error on dirty date",
 			actual:        time.Now(),
 			expectedError: ErrDirtyDate,
 		},
@@ -38,20 +39,15 @@ func TestCreateMonth(t *testing.T) {
 	for _, mock := range table {
 		t.Run("should "+mock.should, func(t *testing.T) {
 			t.Parallel()
-			dir := t.TempDir()
 			a := assert.New(t)
 			r := require.New(t)
-
-			db := NewSqliteDb(filepath.Join(dir, "mock.db"), lib.USD)
-			defer db.Close()
+			db := newMockDb(t)
 
 			if mock.expectedError != nil {
-				a.PanicsWithValue(mock.expectedError, func() {
-					db.CreateMonth(mock.actual)
-				})
+				a.ErrorIs(db.CreateMonth(mock.actual), mock.expectedError)
 				return
 			}
-			db.CreateMonth(mock.actual)
+			r.NoError(db.CreateMonth(mock.actual))
 
 			res, err := db.QueryMonths(QueryMap{})
 			r.NoError(err)
@@ -59,4 +55,576 @@ func TestCreateMonth(t *testing.T) {
 			a.Equal(mock.expected, res[0])
 		})
 	}
+
+	t.Run("should refuse a month that already exists", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)))
+		a.ErrorIs(db.CreateMonth(time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)), ErrDuplicateMonth)
+
+		res, err := db.QueryMonths(QueryMap{})
+		r.NoError(err)
+		a.Len(res, 1)
+	})
+}
+
+func TestMonthNavigation(t *testing.T) {
+	type MockTable struct {
+		should   string
+		month    MonthRecord
+		previous time.Time
+		next     time.Time
+	}
+
+	table := []MockTable{
+		{
+			should:   "stay within the year",
+			month:    MonthRecord{ID: 1, Year: 2024, Month: 5},
+			previous: time.Date(2024, 4, 1, 0, 0, 0, 0, time.Local),
+			next:     time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local),
+		},
+		{
+			should:   "go back to the year before from january",
+			month:    MonthRecord{ID: 1, Year: 2024, Month: 1},
+			previous: time.Date(2023, 12, 1, 0, 0, 0, 0, time.Local),
+			next:     time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local),
+		},
+		{
+			should:   "go on to the year after from december",
+			month:    MonthRecord{ID: 1, Year: 2024, Month: 12},
+			previous: time.Date(2024, 11, 1, 0, 0, 0, 0, time.Local),
+			next:     time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local),
+		},
+	}
+
+	for _, mock := range table {
+		t.Run("should "+mock.should, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			a.Equal(mock.previous, mock.month.PreviousTime())
+			a.Equal(mock.next, mock.month.NextTime())
+			a.True(isCleanDate(mock.month.PreviousTime()))
+			a.True(isCleanDate(mock.month.NextTime()))
+		})
+	}
+
+	t.Run("should look up the months around a month", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		for _, month := range []time.Month{time.May, time.April, time.June} {
+			r.NoError(db.CreateMonth(time.Date(2024, month, 1, 0, 0, 0, 0, time.Local)))
+		}
+
+		may, err := db.GetMonth(time.Date(2024, time.May, 1, 0, 0, 0, 0, time.Local))
+		r.NoError(err)
+
+		april, err := db.PreviousMonth(may)
+		r.NoError(err)
+		a.Equal(MonthRecord{ID: 2, Year: 2024, Month: 4}, april)
+
+		june, err := db.NextMonth(may)
+		r.NoError(err)
+		a.Equal(MonthRecord{ID: 3, Year: 2024, Month: 6}, june)
+
+		_, err = db.NextMonth(june)
+		a.ErrorIs(err, ErrNoRows)
+
+		_, err = db.PreviousMonth(april)
+		a.ErrorIs(err, ErrNoRows)
+	})
+}
+
+func TestUpdateMonth(t *testing.T) {
+	t.Run("should update a month record", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.UpdateMonth(1, time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local)))
+
+		res, err := db.QueryMonths(QueryMap{})
+		r.NoError(err)
+		a.Equal([]MonthRecord{{ID: 1, Year: 2024, Month: 2}}, res)
+	})
+
+	t.Run("should error on dirty date", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		a.ErrorIs(db.UpdateMonth(1, time.Now()), ErrDirtyDate)
+	})
+
+	t.Run("should refuse to move onto a month that already exists", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateMonth(time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local)))
+		a.ErrorIs(db.UpdateMonth(2, time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)), ErrDuplicateMonth)
+	})
+}
+
+func TestDeleteMonth(t *testing.T) {
+	t.Run("should delete an unused month", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.DeleteMonth(1))
+
+		_, err := db.QueryMonths(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+	})
+
+	t.Run("should refuse to delete a month that has history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+		r.NoError(db.CreateBankAccountHistory(BankHistoryConfig{MonthID: 1, BankAccountID: 1}))
+
+		a.ErrorIs(db.DeleteMonth(1), ErrForeignKey)
+
+		res, err := db.QueryMonths(QueryMap{})
+		r.NoError(err)
+		a.Len(res, 1)
+	})
+}
+
+func TestStartNewMonth(t *testing.T) {
+	january := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	february := time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local)
+
+	seed := func(t *testing.T, db *SqliteDb) {
+		r := require.New(t)
+		r.NoError(db.CreateNewBill(BillsConfig{
+			Name:   "rent",
+			Amount: lib.NewCurrency("1200", lib.USD),
+			DueDay: 1,
+			Period: MONTHLY,
+		}))
+		_, err := db.CreateIncome(IncomeConfig{
+			Name:   "job",
+			Amount: lib.NewCurrency("3000", lib.USD),
+			Period: MONTHLY,
+		})
+		r.NoError(err)
+		r.NoError(db.CreateCreditCard(CreditCardConfig{
+			Name:           "visa",
+			DueDay:         20,
+			CreditLimit:    lib.NewPointer(lib.NewCurrency("5000", lib.USD)),
+			LastFourDigits: "1234",
+		}))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+	}
+
+	t.Run("should create history for every active template", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+		seed(t, db)
+
+		month, err := db.StartNewMonth(january)
+		r.NoError(err)
+		a.Equal(MonthRecord{ID: 1, Year: 2024, Month: 1}, month)
+
+		bills, err := db.QueryBillHistory(QueryMap{WHERE_MONTH_ID: month.ID})
+		r.NoError(err)
+		a.Equal([]BillHistoryRecord{{
+			ID: 1,
+			BillHistoryConfig: BillHistoryConfig{
+				BillID:     1,
+				MonthID:    1,
+				Amount:     lib.NewCurrency("1200", lib.USD),
+				PaidAmount: lib.NewPointer(lib.NewCurrency("0", lib.USD)),
+				DueDay:     1,
+			},
+		}}, bills)
+
+		income, err := db.QueryIncomeHistory(QueryMap{WHERE_MONTH_ID: month.ID})
+		r.NoError(err)
+		r.Len(income, 1)
+		a.Equal(lib.NewCurrency("3000", lib.USD), income[0].Amount)
+
+		cards, err := db.QueryCreditCardHistory(QueryMap{WHERE_MONTH_ID: month.ID})
+		r.NoError(err)
+		r.Len(cards, 1)
+		a.Equal(lib.NewCurrency("0", lib.USD), cards[0].Balance)
+		a.Equal(lib.NewPointer(lib.NewCurrency("5000", lib.USD)), cards[0].CreditLimit)
+		a.Equal(20, cards[0].DueDay)
+
+		accounts, err := db.QueryBankAccountHistory(QueryMap{WHERE_MONTH_ID: month.ID})
+		r.NoError(err)
+		r.Len(accounts, 1)
+		a.Equal(lib.NewCurrency("0", lib.USD), accounts[0].Balance)
+
+		current, err := db.GetCurrentMonth()
+		r.NoError(err)
+		a.Equal(month, current)
+	})
+
+	t.Run("should carry balances over from the previous month", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+		seed(t, db)
+
+		_, err := db.StartNewMonth(january)
+		r.NoError(err)
+		r.NoError(db.UpdateBankAccountHistory(1, BankHistoryConfig{
+			MonthID:       1,
+			BankAccountID: 1,
+			Balance:       lib.NewCurrency("850.25", lib.USD),
+		}))
+		r.NoError(db.UpdateCreditCardHistory(1, CreditCardHistoryConfig{
+			CreditCardID: 1,
+			MonthID:      1,
+			Balance:      lib.NewCurrency("400", lib.USD),
+			CreditLimit:  lib.NewPointer(lib.NewCurrency("5000", lib.USD)),
+			DueDay:       20,
+		}))
+		r.NoError(db.SetCreditCardHistory(1, CCFieldMap{
+			CC_PAID_AMOUNT: lib.NewCurrency("150", lib.USD),
+		}))
+
+		month, err := db.StartNewMonth(february)
+		r.NoError(err)
+
+		accounts, err := db.QueryBankAccountHistory(QueryMap{WHERE_MONTH_ID: month.ID})
+		r.NoError(err)
+		r.Len(accounts, 1)
+		a.Equal(lib.NewCurrency("850.25", lib.USD), accounts[0].Balance)
+
+		cards, err := db.QueryCreditCardHistory(QueryMap{WHERE_MONTH_ID: month.ID})
+		r.NoError(err)
+		r.Len(cards, 1)
+		a.Equal(lib.NewCurrency("250", lib.USD), cards[0].Balance)
+	})
+
+	t.Run("should carry balances across a year boundary", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+
+		_, err := db.StartNewMonth(time.Date(2023, 12, 1, 0, 0, 0, 0, time.Local))
+		r.NoError(err)
+		r.NoError(db.UpdateBankAccountHistory(1, BankHistoryConfig{
+			MonthID:       1,
+			BankAccountID: 1,
+			Balance:       lib.NewCurrency("42", lib.USD),
+		}))
+
+		month, err := db.StartNewMonth(january)
+		r.NoError(err)
+
+		accounts, err := db.QueryBankAccountHistory(QueryMap{WHERE_MONTH_ID: month.ID})
+		r.NoError(err)
+		a.Equal(lib.NewCurrency("42", lib.USD), accounts[0].Balance)
+	})
+
+	t.Run("should be safe to run twice", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+		seed(t, db)
+
+		first, err := db.StartNewMonth(january)
+		r.NoError(err)
+		second, err := db.StartNewMonth(january)
+		r.NoError(err)
+		a.Equal(first, second)
+
+		months, err := db.QueryMonths(QueryMap{})
+		r.NoError(err)
+		a.Len(months, 1)
+
+		bills, err := db.QueryBillHistory(QueryMap{})
+		r.NoError(err)
+		a.Len(bills, 1)
+
+		income, err := db.QueryIncomeHistory(QueryMap{})
+		r.NoError(err)
+		a.Len(income, 1)
+
+		cards, err := db.QueryCreditCardHistory(QueryMap{})
+		r.NoError(err)
+		a.Len(cards, 1)
+
+		accounts, err := db.QueryBankAccountHistory(QueryMap{})
+		r.NoError(err)
+		a.Len(accounts, 1)
+	})
+
+	t.Run("should skip archived templates", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+		seed(t, db)
+
+		_, err := db.StartNewMonth(january)
+		r.NoError(err)
+		r.NoError(db.DeleteBill(1))
+
+		month, err := db.StartNewMonth(february)
+		r.NoError(err)
+
+		_, err = db.QueryBillHistory(QueryMap{WHERE_MONTH_ID: month.ID})
+		a.ErrorIs(err, ErrNoRows)
+	})
+
+	t.Run("should only roll bills over in the months they're due", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateNewBill(BillsConfig{
+			Name:        "domain",
+			Amount:      lib.NewCurrency("12", lib.USD),
+			DueDay:      10,
+			Period:      YEARLY,
+			AnchorMonth: february,
+		}))
+
+		jan, err := db.StartNewMonth(january)
+		r.NoError(err)
+		_, err = db.QueryBillHistory(QueryMap{WHERE_MONTH_ID: jan.ID})
+		a.ErrorIs(err, ErrNoRows)
+
+		feb, err := db.StartNewMonth(february)
+		r.NoError(err)
+		bills, err := db.QueryBillHistory(QueryMap{WHERE_MONTH_ID: feb.ID})
+		r.NoError(err)
+		r.Len(bills, 1)
+		a.Equal(10, bills[0].DueDay)
+	})
+
+	t.Run("should not move the current month backwards", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		_, err := db.GetCurrentMonth()
+		a.ErrorIs(err, ErrNoRows)
+
+		feb, err := db.StartNewMonth(february)
+		r.NoError(err)
+		_, err = db.StartNewMonth(january)
+		r.NoError(err)
+
+		current, err := db.GetCurrentMonth()
+		r.NoError(err)
+		a.Equal(feb, current)
+	})
+
+	t.Run("should error on dirty date", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+
+		_, err := db.StartNewMonth(time.Now())
+		a.ErrorIs(err, ErrDirtyDate)
+	})
+}
+
+func TestGetMonth(t *testing.T) {
+	t.Run("should get the month any time falls in", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		_, err := db.GetMonth(time.Date(2024, 5, 17, 13, 30, 0, 0, time.Local))
+		a.ErrorIs(err, ErrNoRows)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)))
+		month, err := db.GetMonth(time.Date(2024, 5, 17, 13, 30, 0, 0, time.Local))
+		r.NoError(err)
+		a.Equal(MonthRecord{ID: 1, Year: 2024, Month: 5}, month)
+	})
+
+	t.Run("should only create a month once", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		created, err := db.GetOrCreateMonth(time.Date(2024, 5, 17, 13, 30, 0, 0, time.Local))
+		r.NoError(err)
+		a.Equal(MonthRecord{ID: 1, Year: 2024, Month: 5}, created)
+
+		month, err := db.GetOrCreateMonth(time.Date(2024, 5, 31, 0, 0, 0, 0, time.Local))
+		r.NoError(err)
+		a.Equal(created, month)
+
+		months, err := db.QueryMonths(QueryMap{})
+		r.NoError(err)
+		a.Len(months, 1)
+	})
+}
+
+func TestMonthsBetween(t *testing.T) {
+	seed := func(t *testing.T) *SqliteDb {
+		r := require.New(t)
+		db := newMockDb(t)
+		r.NoError(db.CreateCreditCard(CreditCardConfig{
+			Name:           "visa",
+			DueDay:         20,
+			LastFourDigits: "1234",
+		}))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+
+		// Backfilled, so month ids don't follow the calendar
+		start := time.Date(2023, time.November, 1, 0, 0, 0, 0, time.Local)
+		for _, offset := range []int{11, 3, 0, 7, 1, 9, 2, 10, 4, 6, 5, 8, 12} {
+			month, err := db.StartNewMonth(start.AddDate(0, offset, 0))
+			r.NoError(err)
+
+			history, err := db.QueryBankAccountHistory(QueryMap{WHERE_MONTH_ID: month.ID})
+			r.NoError(err)
+			r.NoError(db.CreateTransfer(TransferConfig{
+				HistoryID:    history[0].ID,
+				MonthID:      month.ID,
+				Name:         "savings",
+				Amount:       lib.NewCurrency("10", lib.USD),
+				DueDay:       1,
+				TransferType: MOVE,
+			}))
+		}
+		return db
+	}
+
+	monthsOf := func(t *testing.T, db *SqliteDb, monthIDs []int) []string {
+		var months []string
+		for _, id := range monthIDs {
+			records, err := db.QueryMonths(QueryMap{WHERE_ID: id})
+			require.NoError(t, err)
+			months = append(months, fmt.Sprintf("%d-%02d", records[0].Year, records[0].Month))
+		}
+		return months
+	}
+
+	t.Run("should query history between two months", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := seed(t)
+
+		history, err := db.QueryCreditCardHistory(
+			QueryMap{WHERE_MONTH_ID: MonthsBetween(
+				time.Date(2024, time.March, 1, 0, 0, 0, 0, time.Local),
+				time.Date(2024, time.September, 30, 0, 0, 0, 0, time.Local),
+			)},
+			OrderByMonth(ASC),
+		)
+		r.NoError(err)
+
+		var monthIDs []int
+		for _, h := range history {
+			monthIDs = append(monthIDs, h.MonthID)
+		}
+		a.Equal(
+			[]string{"2024-03", "2024-04", "2024-05", "2024-06", "2024-07", "2024-08", "2024-09"},
+			monthsOf(t, db, monthIDs),
+		)
+	})
+
+	t.Run("should query transfers across years", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := seed(t)
+
+		transfers, err := db.QueryTransfers(
+			QueryMap{WHERE_MONTH_ID: YearMonthsBetween(2023, time.December, 2024, time.February)},
+			OrderByMonth(DESC),
+		)
+		r.NoError(err)
+
+		var monthIDs []int
+		for _, transfer := range transfers {
+			monthIDs = append(monthIDs, transfer.MonthID)
+		}
+		a.Equal([]string{"2024-02", "2024-01", "2023-12"}, monthsOf(t, db, monthIDs))
+	})
+
+	t.Run("should return ErrNoRows outside of every month", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := seed(t)
+
+		_, err := db.QueryBankAccountHistory(QueryMap{
+			WHERE_MONTH_ID: YearMonthsBetween(2020, time.January, 2020, time.December),
+		})
+		a.ErrorIs(err, ErrNoRows)
+	})
+
+	t.Run("should only be used on months", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+
+		_, err := db.QueryBills(QueryMap{WHERE_ID: MonthsBetween(time.Now(), time.Now())})
+		a.ErrorIs(err, ErrInvalidCondition)
+	})
+}
+
+func TestCurrencyCodes(t *testing.T) {
+	for _, code := range []lib.CurrencyCode{lib.USD, lib.EUR, lib.JPY} {
+		t.Run("should store amounts in "+code.String(), func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+
+			db, err := NewSqliteDb(filepath.Join(t.TempDir(), "mock.db"), code)
+			r.NoError(err)
+			t.Cleanup(func() { assert.NoError(t, db.Close()) })
+
+			r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+			month, err := db.StartNewMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local))
+			r.NoError(err)
+
+			balance := lib.NewCurrency("1234", code)
+			r.NoError(db.UpdateBankAccountHistory(1, BankHistoryConfig{
+				MonthID:       month.ID,
+				BankAccountID: 1,
+				Balance:       balance,
+			}))
+
+			history, err := db.QueryBankAccountHistory(QueryMap{})
+			r.NoError(err)
+			a.Equal(balance, history[0].Balance)
+			a.Equal(code, history[0].Balance.GetCode())
+
+			var stored string
+			r.NoError(db.handle.QueryRow("SELECT currency_code FROM bank").Scan(&stored))
+			a.Equal(code.String(), stored)
+		})
+	}
 }
@@ -0,0 +1,341 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jaeiya/billbank/lib"
+)
+
+var (
+	ErrNoPassword     = fmt.Errorf("database has no password")
+	ErrPasswordExists = fmt.Errorf("database already has a password")
+)
+
+// A database can be locked behind a lib.Session
+var _ lib.Locker = SqliteDb{}
+
+// HasPassword reports whether a master password has been set on the database
+func (sdb SqliteDb) HasPassword() (bool, error) {
+	_, err := sdb.vaultKeys()
+	if errors.Is(err, ErrNoPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+/*
+SetPassword sets the master password of a database that doesn't have one
+yet, and leaves the database unlocked. Fields that were encrypted with the
+same password before the database had a vault are moved into the vault.
+
+Returns ErrPasswordExists if the database already has a password, or
+lib.ErrEmptyPassword if password is empty.
+*/
+func (sdb SqliteDb) SetPassword(password string) error {
+	if sdb.tx != nil {
+		return fmt.Errorf("cannot set password: %w", ErrTxActive)
+	}
+
+	hasPassword, err := sdb.HasPassword()
+	if err != nil {
+		return fmt.Errorf("cannot set password: %w", err)
+	}
+	if hasPassword {
+		return fmt.Errorf("cannot set password: %w", ErrPasswordExists)
+	}
+
+	keys, err := sdb.vault.Create(password)
+	if err != nil {
+		return fmt.Errorf("cannot create vault: %w", err)
+	}
+
+	if err := sdb.storeVault(keys, func(value string, aad []byte) (string, error) {
+		data, err := lib.DecryptLegacyData(value, password)
+		if err != nil {
+			return "", err
+		}
+		return sdb.vault.Encrypt(data, aad)
+	}); err != nil {
+		// The password was set, it just isn't in the file yet
+		if !errors.Is(err, ErrNotFlushed) {
+			sdb.vault.Lock()
+		}
+		return fmt.Errorf("cannot set password: %w", err)
+	}
+	return nil
+}
+
+/*
+Unlock validates the master password and unlocks the data key, so encrypted
+fields can be read and written until the database is locked or closed.
+
+Returns ErrNoPassword if no password has been set, or lib.ErrWrongPassword.
+*/
+func (sdb SqliteDb) Unlock(password string) error {
+	keys, err := sdb.vaultKeys()
+	if err != nil {
+		return fmt.Errorf("cannot unlock database: %w", err)
+	}
+
+	if err := sdb.vault.Unlock(password, keys); err != nil {
+		return fmt.Errorf("cannot unlock database: %w", err)
+	}
+	return nil
+}
+
+/*
+ChangePassword replaces the master password along with the data key, and
+re-encrypts every encrypted field with the new key. Everything happens in a
+single transaction, so if any field fails to decrypt nothing is changed and
+the old password keeps working. The database is left unlocked with the new
+password, and an encrypted database is flushed with a key derived from it.
+
+If the change was committed but couldn't be flushed, the new password is
+kept and the error wraps ErrNotFlushed.
+
+Returns lib.ErrWrongPassword if oldPassword does not match.
+*/
+func (sdb SqliteDb) ChangePassword(oldPassword, newPassword string) error {
+	if sdb.tx != nil {
+		return fmt.Errorf("cannot change password: %w", ErrTxActive)
+	}
+
+	keys, err := sdb.vaultKeys()
+	if err != nil {
+		return fmt.Errorf("cannot change password: %w", err)
+	}
+
+	var oldVault, newVault lib.Vault
+	defer oldVault.Lock()
+	defer newVault.Lock()
+
+	if err := oldVault.Unlock(oldPassword, keys); err != nil {
+		return fmt.Errorf("cannot change password: %w", err)
+	}
+
+	newKeys, err := newVault.Create(newPassword)
+	if err != nil {
+		return fmt.Errorf("cannot create vault: %w", err)
+	}
+
+	// The file is flushed along with the new vault, so it has to be sealed
+	// with the new password by then.
+	var oldKey *lib.PasswordKey
+	if sdb.file != nil {
+		if oldKey, err = sdb.file.rekey(newPassword); err != nil {
+			return fmt.Errorf("cannot change database key: %w", err)
+		}
+	}
+
+	err = sdb.storeVault(newKeys, func(value string, aad []byte) (string, error) {
+		data, err := oldVault.Decrypt(value, aad)
+		if err != nil {
+			return "", err
+		}
+		return newVault.Encrypt(data, aad)
+	})
+	if err != nil && !errors.Is(err, ErrNotFlushed) {
+		if sdb.file != nil {
+			sdb.file.setKey(oldKey)
+		}
+		return fmt.Errorf("cannot change password: %w", err)
+	}
+
+	sdb.vault.Replace(&newVault)
+
+	if err != nil {
+		return fmt.Errorf("cannot change password: %w", err)
+	}
+	return nil
+}
+
+// Lock wipes the data key, until the database is unlocked again
+func (sdb SqliteDb) Lock() {
+	sdb.vault.Lock()
+}
+
+func (sdb SqliteDb) IsLocked() bool {
+	return sdb.vault.IsLocked()
+}
+
+/*
+insertEncrypted inserts a row along with its encrypted fields, which are
+left out of values. Fields are bound to the id of their row, so they're
+encrypted once the insert has given it one, within the same transaction.
+*/
+func (sdb SqliteDb) insertEncrypted(t Table, fields map[string]*string, values ...any) error {
+	return sdb.WithTx(func(tx SqliteDb) error {
+		res, err := tx.insert(t, values...)
+		if err != nil {
+			return err
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		for _, column := range encryptedColumns[t] {
+			value, err := tx.encryptField(t, column, int(id), fields[column])
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				continue
+			}
+
+			if _, err := tx.conn().Exec(
+				fmt.Sprintf("UPDATE %s SET %s = ? WHERE id = ?", t, column),
+				value,
+				id,
+			); err != nil {
+				return toExecErr(err)
+			}
+		}
+		return nil
+	})
+}
+
+func (sdb SqliteDb) encryptField(t Table, column string, id int, data *string) (any, error) {
+	value, err := sdb.vault.EncryptNonNil(data, fieldAAD(t, column, id))
+	if err != nil {
+		return nil, fmt.Errorf("cannot encrypt %s: %w", column, err)
+	}
+	return value, nil
+}
+
+/*
+decryptField decrypts a field of the row with the matching id. Returns
+lib.ErrVaultLocked for a field that's set while the vault is locked, so its
+cipher text is never mistaken for the field itself.
+*/
+func (sdb SqliteDb) decryptField(t Table, column string, id int, data *string) (*string, error) {
+	value, err := sdb.vault.DecryptNonNil(data, fieldAAD(t, column, id))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt %s: %w", column, err)
+	}
+	return value, nil
+}
+
+/*
+decryptSecret decrypts a field of the row with the matching id into a
+lib.Secret. Like decryptField, it returns lib.ErrVaultLocked for a field
+that's set while the vault is locked.
+*/
+func (sdb SqliteDb) decryptSecret(
+	t Table,
+	column string,
+	id int,
+	data *string,
+) (*lib.Secret, error) {
+	value, err := sdb.vault.DecryptSecret(data, fieldAAD(t, column, id))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt %s: %w", column, err)
+	}
+	return value, nil
+}
+
+/*
+fieldAAD is the additional data a field is encrypted with, so its cipher
+text can't be moved to another column or row.
+*/
+func fieldAAD(t Table, column string, id int) []byte {
+	return []byte(fmt.Sprintf("%s.%s:%d", t, column, id))
+}
+
+func (sdb SqliteDb) vaultKeys() (lib.VaultKeys, error) {
+	var verifier, wrappedKey sql.NullString
+	if err := sdb.conn().QueryRow(
+		"SELECT password_hash, wrapped_key FROM bank WHERE id = 1",
+	).Scan(&verifier, &wrappedKey); err != nil {
+		return lib.VaultKeys{}, fmt.Errorf("cannot load vault: %w", err)
+	}
+
+	if !verifier.Valid || !wrappedKey.Valid {
+		return lib.VaultKeys{}, ErrNoPassword
+	}
+	return lib.VaultKeys{Verifier: verifier.String, WrappedKey: wrappedKey.String}, nil
+}
+
+/*
+storeVault runs every encrypted field through convert and stores the vault
+keys in a single transaction, so a field that can't be converted leaves the
+database as it was.
+*/
+func (sdb SqliteDb) storeVault(keys lib.VaultKeys, convert fieldConverter) error {
+	return sdb.WithTx(func(tx SqliteDb) error {
+		if err := reencryptColumns(tx.conn(), convert); err != nil {
+			return err
+		}
+
+		if _, err := tx.conn().Exec(
+			"UPDATE bank SET password_hash = ?, wrapped_key = ? WHERE id = 1",
+			keys.Verifier,
+			keys.WrappedKey,
+		); err != nil {
+			return fmt.Errorf("cannot store vault: %w", err)
+		}
+		return nil
+	})
+}
+
+// fieldConverter turns an encrypted field into its cipher text under a new key
+type fieldConverter = func(value string, aad []byte) (string, error)
+
+func reencryptColumns(tx dbConn, convert fieldConverter) error {
+	tables := make([]Table, 0, len(encryptedColumns))
+	for t := range encryptedColumns {
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i] < tables[j] })
+
+	for _, t := range tables {
+		for _, column := range encryptedColumns[t] {
+			values, err := encryptedValues(tx, t, column)
+			if err != nil {
+				return err
+			}
+
+			for id, value := range values {
+				converted, err := convert(value, fieldAAD(t, column, id))
+				if err != nil {
+					return fmt.Errorf("cannot re-encrypt %s.%s of id %d: %w", t, column, id, err)
+				}
+
+				if _, err := tx.Exec(
+					fmt.Sprintf("UPDATE %s SET %s = ? WHERE id = ?", t, column),
+					converted,
+					id,
+				); err != nil {
+					return toExecErr(err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// encryptedValues maps the id of every row to its value of column, skipping NULLs
+func encryptedValues(tx dbConn, t Table, column string) (map[int]string, error) {
+	rows, err := tx.Query(
+		fmt.Sprintf("SELECT id, %[1]s FROM %[2]s WHERE %[1]s IS NOT NULL", column, t),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query %s.%s: %w", t, column, err)
+	}
+	defer rows.Close()
+
+	values := map[int]string{}
+	for rows.Next() {
+		var id int
+		var value string
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, fmt.Errorf("cannot scan %s.%s: %w", t, column, err)
+		}
+		values[id] = value
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,198 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jaeiya/billbank/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// "2382 3812 4582 5822" encrypted with "password" the way fields were before the vault
+const legacyCardNumber = "hYxtKqQ4c3D7s9CRRFa6mp/sz81IW7XHueeWCcCJDer6cSAFGnRL3aUNIqrt7h9JGYscMDEwVVUpDxMPyRdn"
+
+func TestVault(t *testing.T) {
+	t.Run("should unlock encrypted fields after reopening", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		path := filepath.Join(t.TempDir(), "vault.db")
+
+		db, err := NewSqliteDb(path, lib.USD)
+		r.NoError(err)
+		r.NoError(db.SetPassword("password"))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{
+			Name:          "checking",
+			AccountNumber: lib.NewPointer("282841"),
+		}))
+		r.NoError(db.Close())
+
+		db, err = NewSqliteDb(path, lib.USD)
+		r.NoError(err)
+		t.Cleanup(func() { assert.NoError(t, db.Close()) })
+		a.True(db.IsLocked())
+
+		hasPassword, err := db.HasPassword()
+		r.NoError(err)
+		a.True(hasPassword)
+
+		a.ErrorIs(db.Unlock("wrong"), lib.ErrWrongPassword)
+		r.NoError(db.Unlock("password"))
+
+		accounts, err := db.QueryBankAccounts(QueryMap{})
+		r.NoError(err)
+		a.Equal(lib.NewSecret("282841"), accounts[0].AccountNumber)
+	})
+
+	t.Run("should only set the password once", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		hasPassword, err := db.HasPassword()
+		r.NoError(err)
+		a.False(hasPassword)
+		a.ErrorIs(db.Unlock("password"), ErrNoPassword)
+
+		r.NoError(db.SetPassword("password"))
+		a.ErrorIs(db.SetPassword("other"), ErrPasswordExists)
+	})
+
+	t.Run("should refuse an empty password", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		a.ErrorIs(db.SetPassword(""), lib.ErrEmptyPassword)
+		a.True(db.IsLocked())
+		hasPassword, err := db.HasPassword()
+		r.NoError(err)
+		a.False(hasPassword)
+
+		r.NoError(db.SetPassword("password"))
+		a.ErrorIs(db.ChangePassword("password", ""), lib.ErrEmptyPassword)
+		a.NoError(db.Unlock("password"))
+	})
+
+	t.Run("should move fields encrypted before the vault into it", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		_, err := db.insert(CREDIT_CARDS, "travel", 5, nil, legacyCardNumber, "5822", nil, lib.USD)
+		r.NoError(err)
+
+		a.Error(db.SetPassword("wrong"))
+		a.True(db.IsLocked())
+		hasPassword, err := db.HasPassword()
+		r.NoError(err)
+		a.False(hasPassword)
+
+		r.NoError(db.SetPassword("password"))
+		cards, err := db.QueryCreditCards(QueryMap{})
+		r.NoError(err)
+		a.Equal(lib.NewSecret("2382 3812 4582 5822"), cards[0].CardNumber)
+	})
+
+	t.Run("should re-encrypt every field with the new password", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		path := filepath.Join(t.TempDir(), "vault.db")
+
+		db, err := NewSqliteDb(path, lib.USD)
+		r.NoError(err)
+		r.NoError(db.SetPassword("old"))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{
+			Name:          "checking",
+			AccountNumber: lib.NewPointer("282841"),
+			Notes:         lib.NewPointer("joint account"),
+		}))
+		r.NoError(db.CreateCreditCard(CreditCardConfig{
+			Name:           "travel",
+			DueDay:         5,
+			CardNumber:     lib.NewPointer("2382 3812 4582 5822"),
+			LastFourDigits: "5822",
+		}))
+
+		a.ErrorIs(db.ChangePassword("wrong", "new"), lib.ErrWrongPassword)
+		r.NoError(db.ChangePassword("old", "new"))
+		r.NoError(db.Close())
+
+		db, err = NewSqliteDb(path, lib.USD)
+		r.NoError(err)
+		t.Cleanup(func() { assert.NoError(t, db.Close()) })
+		a.ErrorIs(db.Unlock("old"), lib.ErrWrongPassword)
+		r.NoError(db.Unlock("new"))
+
+		accounts, err := db.QueryBankAccounts(QueryMap{})
+		r.NoError(err)
+		a.Equal(lib.NewSecret("282841"), accounts[0].AccountNumber)
+		a.Equal(lib.NewPointer("joint account"), accounts[0].Notes)
+
+		cards, err := db.QueryCreditCards(QueryMap{})
+		r.NoError(err)
+		a.Equal(lib.NewSecret("2382 3812 4582 5822"), cards[0].CardNumber)
+	})
+
+	t.Run("should keep the old password when a field fails to decrypt", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.SetPassword("old"))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{
+			Name:          "checking",
+			AccountNumber: lib.NewPointer("282841"),
+		}))
+		r.NoError(db.CreateCreditCard(CreditCardConfig{
+			Name:           "travel",
+			DueDay:         5,
+			Notes:          lib.NewPointer("corrupted"),
+			LastFourDigits: "5822",
+		}))
+		_, err := db.handle.Exec("UPDATE credit_cards SET notes = 'c2hvcnQ=' WHERE id = 1")
+		r.NoError(err)
+
+		a.ErrorIs(db.ChangePassword("old", "new"), lib.ErrCipherText)
+
+		db.Lock()
+		a.ErrorIs(db.Unlock("new"), lib.ErrWrongPassword)
+		r.NoError(db.Unlock("old"))
+
+		accounts, err := db.QueryBankAccounts(QueryMap{})
+		r.NoError(err)
+		a.Equal(lib.NewSecret("282841"), accounts[0].AccountNumber)
+	})
+
+	t.Run("should not decrypt fields swapped between rows", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.SetPassword("password"))
+		for _, name := range []string{"checking", "savings"} {
+			r.NoError(db.CreateBankAccount(BankAccountConfig{
+				Name:          name,
+				AccountNumber: lib.NewPointer(name),
+			}))
+		}
+
+		_, err := db.handle.Exec(`UPDATE bank_accounts SET account_number =
+			(SELECT account_number FROM bank_accounts WHERE id = 1) WHERE id = 2`)
+		r.NoError(err)
+
+		_, err = db.QueryBankAccounts(QueryMap{WHERE_ID: 2})
+		a.ErrorIs(err, lib.ErrDecrypt)
+
+		accounts, err := db.QueryBankAccounts(QueryMap{WHERE_ID: 1})
+		r.NoError(err)
+		a.Equal(lib.NewSecret("checking"), accounts[0].AccountNumber)
+	})
+}
@@ -2,10 +2,11 @@ package sqlite
 
 import (
 	"database/sql"
-	_ "embed"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -16,50 +17,125 @@ import (
 type SqliteDb struct {
 	handle       *sql.DB
 	currencyCode lib.CurrencyCode
+	vault        *lib.Vault
+	// Only set when the whole database is encrypted
+	file *encryptedFile
+	// Only set on the copy bound to a transaction by WithTx
+	tx *sql.Tx
 }
 
-//go:embed sql/init_db.sqlite
-var initBankSQL string
-
 var (
 	ErrForeignKey          = fmt.Errorf("foreign key failed validation")
 	ErrDueDayInvalid       = fmt.Errorf("failed to validate due_day constraint")
 	ErrTransferTypeInvalid = fmt.Errorf("failed to valid transfer_type constraint")
 	ErrAmountInvalid       = fmt.Errorf("failed to validate amount constraint")
 	ErrMonthInvalid        = fmt.Errorf("failed to validate month constraint")
+	ErrYearInvalid         = fmt.Errorf("failed to validate year constraint")
 	ErrUniqueName          = fmt.Errorf("failed unique 'name' constraint requirement")
+	ErrDuplicateMonth      = fmt.Errorf("month already exists")
+	ErrNoRows              = fmt.Errorf("no rows found")
+	ErrUnsupportedTable    = fmt.Errorf("unsupported table")
+	ErrUnsupportedField    = fmt.Errorf("unsupported field")
+	ErrFieldNotAllowed     = fmt.Errorf("field not allowed")
+	ErrUnsupportedType     = fmt.Errorf("unsupported type")
+	ErrNoColumns           = fmt.Errorf("no columns to update")
+	ErrInvalidCondition    = fmt.Errorf("invalid condition")
+	ErrInvalidOption       = fmt.Errorf("invalid query option")
 )
 
-func NewSqliteDb(filePath string, cc lib.CurrencyCode) *SqliteDb {
+/*
+dsnOptions are applied to every connection the pool opens. A pragma that's
+only executed once is lost on the next connection.
+*/
+const dsnOptions = "?_pragma=foreign_keys(1)"
+
+/*
+NewSqliteDb opens the database at filePath, creating and migrating it as
+needed. The currency code is the base currency of a new database, while an
+existing database keeps the base currency it was created with.
+
+Returns ErrEncryptedDb if the file has to be opened with NewEncryptedSqliteDb.
+*/
+func NewSqliteDb(filePath string, cc lib.CurrencyCode) (*SqliteDb, error) {
 	_, err := os.ReadDir(filepath.Dir(filePath))
 	if err != nil {
-		panic(fmt.Errorf("cannot load database: %w", err))
+		return nil, fmt.Errorf("cannot load database: %w", err)
 	}
 
-	db, err := sql.Open("sqlite", filePath)
+	encrypted, err := isEncryptedFile(filePath)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("cannot load database: %w", err)
+	}
+	if encrypted {
+		return nil, fmt.Errorf("cannot load database: %w: %s", ErrEncryptedDb, filePath)
 	}
 
-	_, err = db.Exec(initBankSQL)
+	db, err := sql.Open("sqlite", filePath+dsnOptions)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("cannot open database: %w", err)
 	}
 
-	_, err = db.Exec("PRAGMA foreign_keys = ON;")
+	return newSqliteDb(db, cc)
+}
+
+// newSqliteDb migrates the database and loads its base currency
+func newSqliteDb(db *sql.DB, cc lib.CurrencyCode) (*SqliteDb, error) {
+	err := initDb(db)
 	if err != nil {
-		panic(err)
+		_ = db.Close()
+		return nil, err
+	}
+
+	if cc, err = loadBaseCurrency(db, cc); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &SqliteDb{handle: db, currencyCode: cc, vault: &lib.Vault{}}, nil
+}
+
+// loadBaseCurrency stores cc as the bank's currency unless it already has one
+func loadBaseCurrency(db *sql.DB, cc lib.CurrencyCode) (lib.CurrencyCode, error) {
+	if _, err := db.Exec(
+		"INSERT OR IGNORE INTO bank (id, currency_code) VALUES (1, ?)",
+		cc.String(),
+	); err != nil {
+		return cc, fmt.Errorf("cannot store base currency: %w", err)
 	}
 
-	_, err = db.Exec("PRAGMA user_version = 1;")
+	var stored string
+	if err := db.QueryRow("SELECT currency_code FROM bank WHERE id = 1").Scan(&stored); err != nil {
+		return cc, fmt.Errorf("cannot load base currency: %w", err)
+	}
+	return lib.ParseCurrencyCode(stored)
+}
+
+// BaseCurrency returns the currency every total is converted into
+func (sdb SqliteDb) BaseCurrency() lib.CurrencyCode {
+	return sdb.currencyCode
+}
+
+func initDb(db *sql.DB) error {
+	migrations, err := loadMigrations()
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	return &SqliteDb{db, cc}
+	if err = migrate(db, migrations); err != nil {
+		return fmt.Errorf("cannot migrate database: %w", err)
+	}
+	return nil
 }
 
-func (sdb SqliteDb) InsertInto(t Table, values ...any) string {
+/*
+InsertInto builds an insert statement for the table along with the args
+that belong to its placeholders. Nil values are left out of the statement
+so the column falls back to its default.
+
+🟠 Panics if the table or value count does not match tableData, since
+that can only happen through a programming error.
+*/
+func (sdb SqliteDb) InsertInto(t Table, values ...any) (string, []any) {
 	columns, exists := tableData[t]
 	if !exists {
 		panic("unsupported table")
@@ -70,149 +146,547 @@ func (sdb SqliteDb) InsertInto(t Table, values ...any) string {
 	}
 
 	var realCols []string
-	var realValues []string
+	var placeholders []string
+	var args []any
 
 	for i, col := range columns {
 		if values[i] == nil {
 			continue
 		}
 		realCols = append(realCols, col)
-
-		switch v := values[i].(type) {
-		case string, Period, TransferType:
-			realValues = append(realValues, fmt.Sprintf("'%v'", v))
-		case time.Month:
-			realValues = append(realValues, fmt.Sprintf("%d", v))
-		default:
-			realValues = append(realValues, fmt.Sprintf("%v", v))
-		}
+		placeholders = append(placeholders, "?")
+		args = append(args, toSqlArg(values[i]))
 	}
 
 	return fmt.Sprintf(
 		"INSERT INTO %s (%s) VALUES (%s)",
 		t,
 		strings.Join(realCols, ","),
-		strings.Join(realValues, ","),
+		strings.Join(placeholders, ","),
+	), args
+}
+
+func (sdb SqliteDb) insert(t Table, values ...any) (sql.Result, error) {
+	query, args := sdb.InsertInto(t, values...)
+	res, err := sdb.conn().Exec(query, args...)
+	if err != nil {
+		return nil, toExecErr(err)
+	}
+	return res, nil
+}
+
+/*
+update sets the columns in fm on the row with the matching id. A nil value
+sets the column to NULL.
+*/
+func (sdb SqliteDb) update(t Table, id int, fm FieldMap) error {
+	columns, exists := tableData[t]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUnsupportedTable, t)
+	}
+
+	if len(fm) == 0 {
+		return ErrNoColumns
+	}
+
+	fields := make([]string, 0, len(fm))
+	for field := range fm {
+		if !lib.StrSliceContains(columns, field) {
+			return fmt.Errorf("%w: %s is not a column of %s", ErrUnsupportedField, field, t)
+		}
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	assignments := make([]string, 0, len(fields))
+	args := make([]any, 0, len(fields)+1)
+	for _, field := range fields {
+		assignments = append(assignments, field+"=?")
+		args = append(args, toSqlArg(fm[field]))
+	}
+	args = append(args, id)
+
+	res, err := sdb.conn().Exec(
+		fmt.Sprintf("UPDATE %s SET %s WHERE id=?", t, strings.Join(assignments, ",")),
+		args...,
 	)
+	if err != nil {
+		return toExecErr(err)
+	}
+
+	return requireAffected(res, t, id)
 }
 
-func (sdb SqliteDb) Close() {
-	_ = sdb.handle.Close()
+func (sdb SqliteDb) deleteByID(t Table, id int) error {
+	res, err := sdb.conn().Exec(fmt.Sprintf("DELETE FROM %s WHERE id=?", t), id)
+	if err != nil {
+		return toExecErr(err)
+	}
+
+	return requireAffected(res, t, id)
 }
 
-func (sdb SqliteDb) query(t Table, qm QueryMap) *sql.Rows {
-	var fm FieldMap
-	whereIDOrMonthID := WHERE_ID | WHERE_MONTH_ID
-	switch t {
-	case MONTHS:
-		fm = buildFieldMap(WHERE_ID|WHERE_MONTH|WHERE_YEAR, qm)
+/*
+archiveOrDelete archives a template row when history still references it,
+otherwise the row is deleted outright.
+*/
+func (sdb SqliteDb) archiveOrDelete(t Table, id int) error {
+	ref, ok := archiveTables[t]
+	if !ok {
+		return fmt.Errorf("%w: %s cannot be archived", ErrUnsupportedTable, t)
+	}
 
-	case BANK_ACCOUNTS, INCOME, BILLS:
-		fm = buildFieldMap(WHERE_ID, qm)
+	res, err := sdb.conn().Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET archived=1 WHERE id=? AND EXISTS (SELECT 1 FROM %s WHERE %s=?)",
+			t,
+			ref.history,
+			ref.column,
+		),
+		id,
+		id,
+	)
+	if err != nil {
+		return toExecErr(err)
+	}
 
-	case BANK_ACCOUNT_HISTORY:
-		fm = buildFieldMap(whereIDOrMonthID|WHERE_BANK_ACCOUNT_ID, qm)
+	archived, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if archived > 0 {
+		return nil
+	}
 
-	case TRANSFERS:
-		fm = buildFieldMap(whereIDOrMonthID|WHERE_BANK_ACCOUNT_ID, qm)
+	return sdb.deleteByID(t, id)
+}
 
-	case CREDIT_CARDS:
-		fm = buildFieldMap(WHERE_ID|WHERE_NAME, qm)
+const (
+	accountCurrencyQuery     = "SELECT id, currency_code FROM bank_accounts"
+	cardCurrencyQuery        = "SELECT id, currency_code FROM credit_cards"
+	bankHistoryCurrencyQuery = `SELECT h.id, a.currency_code FROM bank_account_history h
+		JOIN bank_accounts a ON a.id = h.account_id`
+	cardHistoryCurrencyQuery = `SELECT h.id, c.currency_code FROM credit_card_history h
+		JOIN credit_cards c ON c.id = h.card_id`
+)
 
-	case CREDIT_CARD_HISTORY:
-		fm = buildFieldMap(whereIDOrMonthID|WHERE_CREDIT_CARD_ID, qm)
+/*
+currencyOf returns the currency of a single id from one of the currency
+queries. The bool is false when the id doesn't exist, which is left for
+the write it's checked for to reject.
+*/
+func (sdb SqliteDb) currencyOf(query string, id int) (lib.CurrencyCode, bool, error) {
+	var code string
+	err := sdb.conn().QueryRow(
+		fmt.Sprintf("SELECT currency_code FROM (%s) WHERE id = ?", query),
+		id,
+	).Scan(&code)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("cannot query currency code: %w", err)
+	}
 
-	case INCOME_HISTORY:
-		fm = buildFieldMap(whereIDOrMonthID|WHERE_INCOME_ID, qm)
+	cc, err := lib.ParseCurrencyCode(code)
+	return cc, err == nil, err
+}
 
-	case INCOME_AFFIXES:
-		fm = buildFieldMap(WHERE_ID|WHERE_INCOME_ID, qm)
+// requireCurrency makes sure amounts are never stored in the wrong currency
+func requireCurrency(code lib.CurrencyCode, amounts ...*lib.Currency) error {
+	for _, amount := range amounts {
+		if amount != nil && amount.GetCode() != code {
+			return fmt.Errorf(
+				"%w: expected %s, but got %s",
+				lib.ErrCurrencyKind,
+				code,
+				amount.GetCode(),
+			)
+		}
+	}
+	return nil
+}
 
-	case BILL_HISTORY:
-		fm = buildFieldMap(whereIDOrMonthID|WHERE_BILL_ID, qm)
+/*
+requireCurrencyUnchanged makes sure the currency of a bank account or credit
+card only changes while it has no history, since the amounts in its history
+are stored in the minor units of the currency it had.
+
+Returns ErrCurrencyHasHistory otherwise.
+*/
+func (sdb SqliteDb) requireCurrencyUnchanged(t Table, id int, code lib.CurrencyCode) error {
+	ref := archiveTables[t]
+
+	var changed bool
+	err := sdb.conn().QueryRow(
+		fmt.Sprintf(
+			`SELECT EXISTS (
+			     SELECT 1 FROM %s WHERE id=? AND currency_code!=?
+			     AND EXISTS (SELECT 1 FROM %s WHERE %s=?)
+			 )`,
+			t,
+			ref.history,
+			ref.column,
+		),
+		id,
+		code.String(),
+		id,
+	).Scan(&changed)
+	if err != nil {
+		return fmt.Errorf("cannot query currency code: %w", err)
+	}
 
-	default:
-		panic(fmt.Sprintf("unsupported table: %s", t))
+	if changed {
+		return fmt.Errorf("%w: %s id %d", ErrCurrencyHasHistory, t, id)
 	}
+	return nil
+}
 
-	queryStr := buildQueryStr(t, fm)
-	rows, err := sdb.handle.Query(queryStr)
+func requireAffected(res sql.Result, t Table, id int) error {
+	affected, err := res.RowsAffected()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	return rows
+	if affected == 0 {
+		return fmt.Errorf("%s id %d: %w", t, id, ErrNoRows)
+	}
+	return nil
 }
 
-func buildFieldMap(allowedFields WhereFlag, qm QueryMap) FieldMap {
+/*
+Close flushes an encrypted database before closing it. The database is
+closed even when the flush fails, and the error is returned so that what
+wasn't written can be reported.
+*/
+func (sdb SqliteDb) Close() error {
+	flushErr := sdb.Flush()
+	sdb.vault.Lock()
+	closeErr := sdb.handle.Close()
+
+	if flushErr != nil {
+		return flushErr
+	}
+	if closeErr != nil {
+		return fmt.Errorf("cannot close database: %w", closeErr)
+	}
+	return nil
+}
+
+func (sdb SqliteDb) query(t Table, qm QueryMap, opts ...QueryOption) (*sql.Rows, error) {
+	fm, err := buildFieldMap(t, qm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Archived templates are hidden unless they're asked for
+	if _, ok := archiveTables[t]; ok {
+		if _, ok := fm["archived"]; !ok {
+			fm["archived"] = false
+		}
+	}
+
+	queryStr, args, err := buildQueryStr(t, fm, newQueryOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	return sdb.conn().Query(queryStr, args...)
+}
+
+// buildFieldMap resolves every flag in qm to its column on the table
+func buildFieldMap(t Table, qm QueryMap) (FieldMap, error) {
+	columns, ok := whereColumns[t]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedTable, t)
+	}
+
 	fm := FieldMap{}
 	for ff, fieldValue := range qm {
-		field, fieldExists := WhereFieldMap[ff]
-		if !fieldExists {
-			panic("unsupported field")
-		}
-		if allowedFields&ff == 0 {
-			panic(fmt.Sprintf("field not allowed: %v", WhereFieldMap[ff]))
+		field, allowed := columns[ff]
+		if !allowed {
+			return nil, fmt.Errorf("%w: flag %d on %s", ErrFieldNotAllowed, ff, t)
 		}
 		fm[field] = fieldValue
 	}
 
-	return fm
+	return fm, nil
 }
 
-func buildQueryStr(t Table, fm FieldMap) string {
+/*
+buildQueryStr builds the select for t. Conditions are joined in the order
+of their columns, so the same query always builds the same statement.
+*/
+func buildQueryStr(t Table, fm FieldMap, options queryOptions) (string, []any, error) {
 	td, ok := tableData[t]
 	if !ok {
-		panic("table does not exist")
+		return "", nil, fmt.Errorf("%w: %s", ErrUnsupportedTable, t)
 	}
 
-	var conditions []string
-	if len(fm) == 0 {
-		return fmt.Sprintf("SELECT * FROM %s", t)
+	orderStr, err := buildOrderStr(t, options)
+	if err != nil {
+		return "", nil, err
+	}
+
+	fields := make([]string, 0, len(fm))
+	for field := range fm {
+		fields = append(fields, field)
 	}
+	sort.Strings(fields)
 
-	for field, val := range fm {
+	_, isArchivable := archiveTables[t]
+
+	var conditions []string
+	var args []any
+	for _, field := range fields {
 		// id's are not part of the table data because they are created
-		// automatically by SQL.
-		if field != "id" {
+		// automatically by SQL. The same goes for the archived flag.
+		if field != "id" && !(field == "archived" && isArchivable) {
 			if !lib.StrSliceContains(td, field) {
-				panic(fmt.Sprintf("%s is an unsupported field for the table: %s", field, t))
+				return "", nil, fmt.Errorf(
+					"%w: %s is not a column of %s",
+					ErrUnsupportedField,
+					field,
+					t,
+				)
 			}
 		}
 
-		switch realVal := val.(type) {
-		case string, Period:
-			conditions = append(conditions, fmt.Sprintf("%s LIKE '%%%s%%'", field, realVal))
-		case int, int64, int32:
-			conditions = append(conditions, fmt.Sprintf("%s=%v", field, realVal))
-		case lib.Currency:
-			conditions = append(conditions, fmt.Sprintf("%s=%d", field, realVal.GetStoredValue()))
-		default:
-			panic(fmt.Sprintf("unsupported type: %T", val))
+		cond, ok := fm[field].(Condition)
+		if !ok {
+			cond = Equal(fm[field])
+		}
+
+		condStr, condArgs, err := buildCondition(field, cond)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, condStr)
+		args = append(args, condArgs...)
+	}
+
+	columns := append(append([]string{"id"}, td...), joinedColumns[t]...)
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ","), t)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " " + orderStr
+
+	if options.limit >= 0 || options.offset > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, options.limit, options.offset)
+	}
+	return query, args, nil
+}
+
+/*
+buildOrderStr builds the ORDER BY clause of the options. It always ends
+with the id, which is the order rows were created in when nothing else is
+asked for.
+*/
+func buildOrderStr(t Table, options queryOptions) (string, error) {
+	if options.err != nil {
+		return "", options.err
+	}
+
+	var orders []string
+	for _, o := range options.orders {
+		if o.dir != ASC && o.dir != DESC {
+			return "", fmt.Errorf("%w: sort direction %q", ErrInvalidOption, o.dir)
+		}
+
+		column, err := orderColumn(t, o)
+		if err != nil {
+			return "", err
 		}
+		orders = append(orders, fmt.Sprintf("%s %s", column, o.dir))
 	}
 
-	return fmt.Sprintf("SELECT * FROM %s WHERE %s", t, strings.Join(conditions, " AND "))
+	return "ORDER BY " + strings.Join(append(orders, "id"), ", "), nil
 }
 
-func panicOnExecErr(err error) {
-	if strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
-		panic(ErrForeignKey)
+// orderColumn resolves what an order sorts by on t
+func orderColumn(t Table, o order) (string, error) {
+	if !o.byMonth {
+		column, ok := whereColumns[t][o.flag]
+		if !ok {
+			return "", fmt.Errorf("%w: cannot order %s by flag %d", ErrFieldNotAllowed, t, o.flag)
+		}
+		return column, nil
 	}
-	if strings.Contains(err.Error(), "CHECK constraint failed: due_day") {
-		panic(ErrDueDayInvalid)
+
+	if t == MONTHS {
+		return "year * 12 + month", nil
 	}
-	if strings.Contains(err.Error(), "CHECK constraint failed: transfer_type") {
-		panic(ErrTransferTypeInvalid)
+	if !lib.StrSliceContains(tableData[t], "month_id") {
+		return "", fmt.Errorf("%w: %s has no month to order by", ErrFieldNotAllowed, t)
+	}
+	return fmt.Sprintf("(SELECT year * 12 + month FROM months WHERE months.id = %s.month_id)", t), nil
+}
+
+var operatorArgCount = map[Operator]int{
+	OP_EQUAL:          1,
+	OP_NOT_EQUAL:      1,
+	OP_CONTAINS:       1,
+	OP_GREATER:        1,
+	OP_GREATER_EQUAL:  1,
+	OP_LESS:           1,
+	OP_LESS_EQUAL:     1,
+	OP_BETWEEN:        2,
+	OP_IS_NULL:        0,
+	OP_NOT_NULL:       0,
+	OP_MONTHS_BETWEEN: 2,
+}
+
+// buildCondition renders a single condition on field and the args it needs
+func buildCondition(field string, cond Condition) (string, []any, error) {
+	if want, ok := operatorArgCount[cond.Op]; ok && len(cond.Values) != want {
+		return "", nil, fmt.Errorf(
+			"%w: %s expects %d values, but got %d",
+			ErrInvalidCondition,
+			field,
+			want,
+			len(cond.Values),
+		)
 	}
-	if strings.Contains(err.Error(), "CHECK constraint failed: amount") {
-		panic(ErrAmountInvalid)
+
+	// A nil comparison can only ever be answered by IS NULL or IS NOT NULL
+	if len(cond.Values) == 1 && cond.Values[0] == nil {
+		switch cond.Op {
+		case OP_EQUAL:
+			cond = IsNull()
+		case OP_NOT_EQUAL:
+			cond = NotNull()
+		default:
+			return "", nil, fmt.Errorf("%w: cannot compare %s to nil", ErrInvalidCondition, field)
+		}
 	}
-	if strings.Contains(err.Error(), "CHECK constraint failed: month") {
-		panic(ErrMonthInvalid)
+
+	args := make([]any, 0, len(cond.Values))
+	for _, v := range cond.Values {
+		arg, err := toQueryArg(v)
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, arg)
 	}
-	if strings.Contains(err.Error(), "UNIQUE constraint failed") &&
-		strings.Contains(err.Error(), ".name (") {
-		panic(ErrUniqueName)
+
+	switch cond.Op {
+	case OP_EQUAL:
+		return field + "=?", args, nil
+	case OP_NOT_EQUAL:
+		return field + "!=?", args, nil
+	case OP_GREATER:
+		return field + ">?", args, nil
+	case OP_GREATER_EQUAL:
+		return field + ">=?", args, nil
+	case OP_LESS:
+		return field + "<?", args, nil
+	case OP_LESS_EQUAL:
+		return field + "<=?", args, nil
+	case OP_BETWEEN:
+		return field + " BETWEEN ? AND ?", args, nil
+	case OP_IS_NULL:
+		return field + " IS NULL", nil, nil
+	case OP_NOT_NULL:
+		return field + " IS NOT NULL", nil, nil
+
+	case OP_CONTAINS:
+		text, ok := args[0].(string)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: contains needs text, got %T", ErrUnsupportedType, cond.Values[0])
+		}
+		return field + ` LIKE ? ESCAPE '\'`, []any{"%" + escapeLike(text) + "%"}, nil
+
+	case OP_MONTHS_BETWEEN:
+		if field != "month_id" {
+			return "", nil, fmt.Errorf("%w: %s is not a month", ErrInvalidCondition, field)
+		}
+		return field + " IN (SELECT id FROM months WHERE year * 12 + month BETWEEN ? AND ?)", args, nil
+
+	case OP_IN:
+		if len(args) == 0 {
+			return "", nil, fmt.Errorf("%w: %s IN needs at least one value", ErrInvalidCondition, field)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+		return fmt.Sprintf("%s IN (%s)", field, placeholders), args, nil
+	}
+
+	return "", nil, fmt.Errorf("%w: unknown operator %d", ErrInvalidCondition, cond.Op)
+}
+
+// toQueryArg converts a value being compared against into its stored form
+func toQueryArg(v any) (any, error) {
+	switch realVal := v.(type) {
+	case string, int, int64, int32, bool:
+		return realVal, nil
+	case Period, TransferType, time.Month, lib.CurrencyCode:
+		return toSqlArg(realVal), nil
+	case lib.Currency:
+		return realVal.GetStoredValue(), nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedType, v)
+	}
+}
+
+/*
+toSqlArg converts the named types used by the tables into the basic types
+the sqlite driver understands.
+*/
+func toSqlArg(v any) any {
+	switch v := v.(type) {
+	case Period:
+		return string(v)
+	case TransferType:
+		return string(v)
+	case time.Month:
+		return int(v)
+	case lib.CurrencyCode:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+// escapeLike escapes the LIKE wildcards so they match literally
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+/*
+toExecErr converts the constraint failures reported by sqlite into their
+matching sentinel errors, so callers can check them with errors.Is.
+*/
+func toExecErr(err error) error {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "FOREIGN KEY constraint failed"):
+		return ErrForeignKey
+	case strings.Contains(msg, "CHECK constraint failed: due_day"):
+		return ErrDueDayInvalid
+	case strings.Contains(msg, "CHECK constraint failed: transfer_type"):
+		return ErrTransferTypeInvalid
+	case strings.Contains(msg, "CHECK constraint failed: amount"):
+		return ErrAmountInvalid
+	case strings.Contains(msg, "CHECK constraint failed: anchor_year"):
+		return ErrYearInvalid
+	case strings.Contains(msg, "CHECK constraint failed: anchor_month"):
+		return ErrMonthInvalid
+	case strings.Contains(msg, "CHECK constraint failed: anchor"):
+		return ErrAnchorRequired
+	case strings.Contains(msg, "CHECK constraint failed: every_n_months"),
+		strings.Contains(msg, "CHECK constraint failed: period"):
+		return ErrPeriodInvalid
+	case strings.Contains(msg, "CHECK constraint failed: month"):
+		return ErrMonthInvalid
+	case strings.Contains(msg, "CHECK constraint failed: year"):
+		return ErrYearInvalid
+	case strings.Contains(msg, "UNIQUE constraint failed") && strings.Contains(msg, ".name ("):
+		return ErrUniqueName
+	case strings.Contains(msg, "UNIQUE constraint failed: months.year, months.month"):
+		return ErrDuplicateMonth
 	}
-	panic(err)
+	return err
 }
@@ -0,0 +1,408 @@
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jaeiya/billbank/lib"
+)
+
+var (
+	ErrEncryptedDb = fmt.Errorf("database is encrypted")
+	ErrDbFile      = fmt.Errorf("not a billbank database")
+)
+
+var (
+	// encryptedMagic starts every encrypted database file
+	encryptedMagic = []byte("billbank encrypted\x00")
+	sqliteMagic    = []byte("SQLite format 3\x00")
+	fileAAD        = []byte("billbank database")
+)
+
+/*
+encryptedFile is where an encrypted database is kept. The database itself
+only ever lives in memory, and a dump of it is sealed into the file with a
+key derived from the master password whenever it's flushed.
+*/
+type encryptedFile struct {
+	mu   sync.Mutex
+	path string
+	key  *lib.PasswordKey
+}
+
+/*
+NewEncryptedSqliteDb opens a database that's encrypted as a whole, so none
+of it can be read from the file, names and amounts included. The file is
+decrypted into memory with a key derived from the master password, and
+written back after every committed write, as well as by Flush and Close. A
+database that isn't encrypted yet is encrypted as soon as it's opened.
+
+The master password also unlocks the vault, which is set up with it if the
+database doesn't have a password yet.
+
+Returns lib.ErrWrongPassword if the file can't be decrypted.
+*/
+func NewEncryptedSqliteDb(filePath, password string, cc lib.CurrencyCode) (*SqliteDb, error) {
+	_, err := os.ReadDir(filepath.Dir(filePath))
+	if err != nil {
+		return nil, fmt.Errorf("cannot load database: %w", err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("cannot load database: %w", err)
+	}
+
+	file := &encryptedFile{path: filePath}
+	encrypted := bytes.HasPrefix(data, encryptedMagic)
+
+	switch {
+	case encrypted:
+		data, file.key, err = lib.OpenWithPassword(data[len(encryptedMagic):], fileAAD, password)
+		if errors.Is(err, lib.ErrDecrypt) {
+			return nil, fmt.Errorf("cannot decrypt database: %w", lib.ErrWrongPassword)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt database: %w", err)
+		}
+
+	case len(data) == 0 || bytes.HasPrefix(data, sqliteMagic):
+		if len(data) > 0 {
+			if data, err = dumpFile(filePath); err != nil {
+				return nil, fmt.Errorf("cannot load database: %w", err)
+			}
+		}
+
+		if file.key, err = lib.NewPasswordKey(password); err != nil {
+			return nil, fmt.Errorf("cannot create database key: %w", err)
+		}
+
+	default:
+		return nil, fmt.Errorf("cannot load database: %w: %s", ErrDbFile, filePath)
+	}
+
+	db, err := openInMemory(data)
+	if err != nil {
+		return nil, err
+	}
+
+	sdb, err := newSqliteDb(db, cc)
+	if err != nil {
+		return nil, err
+	}
+	sdb.file = file
+
+	if err := sdb.unlockOrSetPassword(password); err != nil {
+		sdb.vault.Lock()
+		_ = db.Close()
+		return nil, err
+	}
+
+	if !encrypted {
+		if err := sdb.Flush(); err != nil {
+			sdb.vault.Lock()
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	return sdb, nil
+}
+
+/*
+openInMemory opens an in-memory database loaded from a dump. Every
+connection to ":memory:" is a database of its own, so the pool is kept to
+the one connection that holds it.
+
+Older dumps can list rows before the rows they reference, so foreign keys
+are only turned on once the dump is loaded and has been checked.
+*/
+func openInMemory(dump []byte) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("cannot open database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	if err := loadDump(db, dump); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("cannot load database: %w", err)
+	}
+	return db, nil
+}
+
+// loadDump runs the dump on db and turns foreign keys on if nothing dangles
+func loadDump(db *sql.DB, dump []byte) error {
+	if _, err := db.Exec(string(dump)); err != nil {
+		return err
+	}
+
+	var table string
+	err := db.QueryRow("PRAGMA foreign_key_check;").Scan(&table, new(any), new(any), new(any))
+	if err == nil {
+		return fmt.Errorf("%w: %s", ErrForeignKey, table)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	_, err = db.Exec("PRAGMA foreign_keys = ON;")
+	return err
+}
+
+/*
+Flush seals the in-memory copy of an encrypted database into its file. The
+file is replaced all at once, so a failed flush leaves the last one intact.
+It does nothing for a database that isn't encrypted.
+*/
+func (sdb SqliteDb) Flush() error {
+	if sdb.file == nil {
+		return nil
+	}
+	if sdb.tx != nil {
+		return fmt.Errorf("cannot flush database: %w", ErrTxActive)
+	}
+
+	dump, err := dumpDb(sdb.handle)
+	if err != nil {
+		return fmt.Errorf("cannot flush database: %w", err)
+	}
+
+	if err := sdb.file.write(dump); err != nil {
+		return fmt.Errorf("cannot flush database: %w", err)
+	}
+	return nil
+}
+
+/*
+dumpDb writes the database out as the SQL that recreates it, much like the
+sqlite3 shell's .dump. Tables are filled before their indexes and triggers
+are created, so the triggers don't fire on rows that are only being copied.
+*/
+func dumpDb(db *sql.DB) ([]byte, error) {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return nil, err
+	}
+
+	type schemaEntry struct{ kind, name, sql string }
+	var schema []schemaEntry
+
+	rows, err := db.Query("SELECT type, name, sql FROM sqlite_master WHERE sql IS NOT NULL ORDER BY rowid")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var entry schemaEntry
+		if err := rows.Scan(&entry.kind, &entry.name, &entry.sql); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		schema = append(schema, entry)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	var dump bytes.Buffer
+	// Rows are written in table order, which can put them before the rows
+	// they reference, so foreign keys are only checked once it's all in.
+	dump.WriteString("BEGIN;\nPRAGMA defer_foreign_keys = ON;\n")
+
+	for _, entry := range schema {
+		// sqlite_sequence is created along with the first AUTOINCREMENT table
+		if entry.kind == "table" && !strings.HasPrefix(entry.name, "sqlite_") {
+			dump.WriteString(entry.sql + ";\n")
+		}
+	}
+
+	hasSequence := false
+	for _, entry := range schema {
+		if entry.kind != "table" {
+			continue
+		}
+		if entry.name == "sqlite_sequence" {
+			hasSequence = true
+			continue
+		}
+		if err := dumpRows(db, &dump, entry.name); err != nil {
+			return nil, err
+		}
+	}
+
+	// The inserts above already counted the ids, which have to be replaced
+	// by the real counters so deleted ids aren't handed out again
+	if hasSequence {
+		dump.WriteString("DELETE FROM sqlite_sequence;\n")
+		if err := dumpRows(db, &dump, "sqlite_sequence"); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range schema {
+		if entry.kind != "table" {
+			dump.WriteString(entry.sql + ";\n")
+		}
+	}
+
+	fmt.Fprintf(&dump, "PRAGMA user_version = %d;\nCOMMIT;\n", version)
+	return dump.Bytes(), nil
+}
+
+// dumpRows writes an insert for every row of the table, quoted by sqlite itself
+func dumpRows(db *sql.DB, dump *bytes.Buffer, table string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+
+	var columns, quoted []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		columns = append(columns, quoteIdent(column))
+		quoted = append(quoted, fmt.Sprintf("quote(%s)", quoteIdent(column)))
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query(fmt.Sprintf(
+		"SELECT %s FROM %s",
+		strings.Join(quoted, " || ',' || "),
+		quoteIdent(table),
+	))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var values string
+		if err := rows.Scan(&values); err != nil {
+			return err
+		}
+		fmt.Fprintf(
+			dump,
+			"INSERT INTO %s (%s) VALUES (%s);\n",
+			quoteIdent(table),
+			strings.Join(columns, ","),
+			values,
+		)
+	}
+	return rows.Err()
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// dumpFile dumps the unencrypted database at path
+func dumpFile(path string) ([]byte, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return dumpDb(db)
+}
+
+func (f *encryptedFile) write(data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sealed, err := f.key.Seal(data, fileAAD)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if err := writeAll(tmp, encryptedMagic, sealed); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+/*
+rekey derives a new key from the password, for the next time it's written.
+The key it replaced is returned, so it can be put back with setKey.
+*/
+func (f *encryptedFile) rekey(password string) (*lib.PasswordKey, error) {
+	key, err := lib.NewPasswordKey(password)
+	if err != nil {
+		return nil, err
+	}
+	return f.setKey(key), nil
+}
+
+// setKey replaces the key the file is written with, returning the old one
+func (f *encryptedFile) setKey(key *lib.PasswordKey) *lib.PasswordKey {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old := f.key
+	f.key = key
+	return old
+}
+
+func (sdb SqliteDb) unlockOrSetPassword(password string) error {
+	hasPassword, err := sdb.HasPassword()
+	if err != nil {
+		return err
+	}
+	if hasPassword {
+		return sdb.Unlock(password)
+	}
+	return sdb.SetPassword(password)
+}
+
+// isEncryptedFile reports whether the file at path is an encrypted database
+func isEncryptedFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(encryptedMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false, nil
+	}
+	return bytes.Equal(magic, encryptedMagic), nil
+}
+
+func writeAll(w io.Writer, chunks ...[]byte) error {
+	for _, chunk := range chunks {
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaeiya/billbank/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedSqliteDb(t *testing.T) {
+	names := []string{"Mom's Rent", "Joint Checking", "Travel Rewards", "Grandma"}
+
+	fillDb := func(t *testing.T, db *SqliteDb) {
+		r := require.New(t)
+		r.NoError(db.CreateNewBill(BillsConfig{
+			Name:   names[0],
+			Amount: lib.NewCurrency("1500", lib.USD),
+			DueDay: 1,
+			Period: MONTHLY,
+		}))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{
+			Name:          names[1],
+			AccountNumber: lib.NewPointer("282841"),
+		}))
+		r.NoError(db.CreateCreditCard(CreditCardConfig{
+			Name:           names[2],
+			DueDay:         5,
+			LastFourDigits: "5822",
+		}))
+		_, err := db.CreateIncome(IncomeConfig{
+			Name:   names[3],
+			Amount: lib.NewCurrency("100", lib.USD),
+			Period: MONTHLY,
+		})
+		r.NoError(err)
+	}
+
+	requireNoPlaintext := func(t *testing.T, path string) {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.False(t, bytes.Contains(data, []byte("SQLite format")))
+		for _, name := range append(names, "282841", "bills") {
+			assert.False(t, bytes.Contains(data, []byte(name)), name)
+		}
+	}
+
+	t.Run("should keep nothing readable in the file", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		path := filepath.Join(t.TempDir(), "encrypted.db")
+
+		db, err := NewEncryptedSqliteDb(path, "password", lib.USD)
+		r.NoError(err)
+		fillDb(t, db)
+		r.NoError(db.Flush())
+		requireNoPlaintext(t, path)
+		r.NoError(db.Close())
+
+		_, err = NewSqliteDb(path, lib.USD)
+		a.ErrorIs(err, ErrEncryptedDb)
+
+		_, err = NewEncryptedSqliteDb(path, "wrong", lib.USD)
+		a.ErrorIs(err, lib.ErrWrongPassword)
+
+		db, err = NewEncryptedSqliteDb(path, "password", lib.EUR)
+		r.NoError(err)
+		t.Cleanup(func() { assert.NoError(t, db.Close()) })
+		a.Equal(lib.USD, db.BaseCurrency())
+
+		bills, err := db.QueryBills(QueryMap{})
+		r.NoError(err)
+		a.Equal(names[0], bills[0].Name)
+
+		accounts, err := db.QueryBankAccounts(QueryMap{})
+		r.NoError(err)
+		a.Equal(lib.NewSecret("282841"), accounts[0].AccountNumber)
+	})
+
+	t.Run("should encrypt a database that was not encrypted yet", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		path := filepath.Join(t.TempDir(), "plain.db")
+
+		db, err := NewSqliteDb(path, lib.USD)
+		r.NoError(err)
+		r.NoError(db.CreateNewBill(BillsConfig{
+			Name:   names[0],
+			Amount: lib.NewCurrency("1500", lib.USD),
+			DueDay: 1,
+			Period: MONTHLY,
+		}))
+		r.NoError(db.Close())
+
+		db, err = NewEncryptedSqliteDb(path, "password", lib.USD)
+		r.NoError(err)
+		t.Cleanup(func() { assert.NoError(t, db.Close()) })
+		requireNoPlaintext(t, path)
+
+		bills, err := db.QueryBills(QueryMap{})
+		r.NoError(err)
+		a.Equal(names[0], bills[0].Name)
+	})
+
+	t.Run("should flush with the new password after changing it", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		path := filepath.Join(t.TempDir(), "encrypted.db")
+
+		db, err := NewEncryptedSqliteDb(path, "old", lib.USD)
+		r.NoError(err)
+		fillDb(t, db)
+		r.NoError(db.ChangePassword("old", "new"))
+		r.NoError(db.Close())
+		requireNoPlaintext(t, path)
+
+		_, err = NewEncryptedSqliteDb(path, "old", lib.USD)
+		a.ErrorIs(err, lib.ErrWrongPassword)
+
+		db, err = NewEncryptedSqliteDb(path, "new", lib.USD)
+		r.NoError(err)
+		t.Cleanup(func() { assert.NoError(t, db.Close()) })
+
+		accounts, err := db.QueryBankAccounts(QueryMap{})
+		r.NoError(err)
+		a.Equal(lib.NewSecret("282841"), accounts[0].AccountNumber)
+	})
+
+	t.Run("should flush every committed write", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		path := filepath.Join(t.TempDir(), "encrypted.db")
+
+		db, err := NewEncryptedSqliteDb(path, "password", lib.USD)
+		r.NoError(err)
+		t.Cleanup(func() { assert.NoError(t, db.Close()) })
+		fillDb(t, db)
+		requireNoPlaintext(t, path)
+
+		// Opened again without flushing or closing the first one
+		reopened, err := NewEncryptedSqliteDb(path, "password", lib.USD)
+		r.NoError(err)
+		bills, err := reopened.QueryBills(QueryMap{})
+		r.NoError(err)
+		a.Equal(names[0], bills[0].Name)
+
+		accounts, err := reopened.QueryBankAccounts(QueryMap{})
+		r.NoError(err)
+		a.Equal(lib.NewSecret("282841"), accounts[0].AccountNumber)
+		r.NoError(reopened.Close())
+	})
+
+	t.Run("should reopen a database with history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		path := filepath.Join(t.TempDir(), "encrypted.db")
+		may := time.Date(2024, time.May, 1, 0, 0, 0, 0, time.Local)
+
+		db, err := NewEncryptedSqliteDb(path, "password", lib.USD)
+		r.NoError(err)
+		fillDb(t, db)
+		_, err = db.StartNewMonth(may)
+		r.NoError(err)
+		r.NoError(db.Close())
+
+		for range 2 {
+			db, err = NewEncryptedSqliteDb(path, "password", lib.USD)
+			r.NoError(err)
+			r.NoError(db.Close())
+		}
+
+		db, err = NewEncryptedSqliteDb(path, "password", lib.USD)
+		r.NoError(err)
+		t.Cleanup(func() { assert.NoError(t, db.Close()) })
+
+		histories := []Table{INCOME_HISTORY, BANK_ACCOUNT_HISTORY, CREDIT_CARD_HISTORY, BILL_HISTORY}
+		for _, history := range histories {
+			var count int
+			r.NoError(db.handle.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", history)).Scan(&count))
+			a.Equal(1, count, history)
+		}
+
+		var sequences int
+		r.NoError(db.handle.QueryRow(
+			"SELECT COUNT(*) FROM sqlite_sequence WHERE name = 'months'",
+		).Scan(&sequences))
+		a.Equal(1, sequences)
+	})
+
+	t.Run("should not hand out deleted ids again after reopening", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		path := filepath.Join(t.TempDir(), "encrypted.db")
+
+		db, err := NewEncryptedSqliteDb(path, "password", lib.USD)
+		r.NoError(err)
+		for m := time.January; m <= time.March; m++ {
+			r.NoError(db.CreateMonth(time.Date(2024, m, 1, 0, 0, 0, 0, time.Local)))
+		}
+		r.NoError(db.DeleteMonth(3))
+		r.NoError(db.Close())
+
+		db, err = NewEncryptedSqliteDb(path, "password", lib.USD)
+		r.NoError(err)
+		t.Cleanup(func() { assert.NoError(t, db.Close()) })
+
+		april := time.Date(2024, time.April, 1, 0, 0, 0, 0, time.Local)
+		r.NoError(db.CreateMonth(april))
+		month, err := db.GetMonth(april)
+		r.NoError(err)
+		a.Equal(4, month.ID)
+	})
+
+	t.Run("should load dumps that list rows before what they reference", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		schema := `BEGIN;
+			CREATE TABLE months (id INTEGER PRIMARY KEY);
+			CREATE TABLE history (id INTEGER PRIMARY KEY, month_id INTEGER REFERENCES months (id));
+			INSERT INTO history VALUES (1, 1);
+			INSERT INTO months VALUES (%d);
+			COMMIT;`
+
+		db, err := openInMemory([]byte(fmt.Sprintf(schema, 1)))
+		r.NoError(err)
+		defer db.Close()
+
+		_, err = db.Exec("INSERT INTO history VALUES (2, 9)")
+		a.ErrorContains(err, "FOREIGN KEY")
+
+		_, err = openInMemory([]byte(fmt.Sprintf(schema, 2)))
+		a.ErrorIs(err, ErrForeignKey)
+	})
+
+	t.Run("should report writes that could not be flushed", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		dir := filepath.Join(t.TempDir(), "data")
+		r.NoError(os.Mkdir(dir, 0o700))
+
+		db, err := NewEncryptedSqliteDb(filepath.Join(dir, "encrypted.db"), "password", lib.USD)
+		r.NoError(err)
+		r.NoError(os.RemoveAll(dir))
+
+		err = db.CreateNewBill(BillsConfig{
+			Name:   names[0],
+			Amount: lib.NewCurrency("1500", lib.USD),
+			DueDay: 1,
+			Period: MONTHLY,
+		})
+		a.ErrorIs(err, ErrNotFlushed)
+
+		// The write is kept for the next flush
+		bills, err := db.QueryBills(QueryMap{})
+		r.NoError(err)
+		a.Equal(names[0], bills[0].Name)
+
+		a.Error(db.Close())
+	})
+
+	t.Run("should refuse files that are not databases", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		path := filepath.Join(t.TempDir(), "notes.txt")
+		require.NoError(t, os.WriteFile(path, []byte("shopping list"), 0o600))
+
+		_, err := NewEncryptedSqliteDb(path, "password", lib.USD)
+		a.ErrorIs(err, ErrDbFile)
+	})
+}
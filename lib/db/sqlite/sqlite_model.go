@@ -1,5 +1,10 @@
 package sqlite
 
+import (
+	"fmt"
+	"time"
+)
+
 type Table string
 
 const (
@@ -14,16 +19,17 @@ const (
 	CREDIT_CARD_HISTORY  = Table("credit_card_history")
 	BILLS                = Table("bills")
 	BILL_HISTORY         = Table("bill_history")
+	EXCHANGE_RATES       = Table("exchange_rates")
 )
 
 type TableFields = map[Table][]string
 
 var tableData = TableFields{
 	MONTHS:               {"year", "month"},
-	INCOME:               {"name", "amount", "period"},
+	INCOME:               {"name", "amount", "period", "pay_date"},
 	INCOME_HISTORY:       {"income_id", "month_id", "amount"},
 	INCOME_AFFIXES:       {"history_id", "name", "amount"},
-	BANK_ACCOUNTS:        {"name", "account_number", "notes"},
+	BANK_ACCOUNTS:        {"name", "account_number", "notes", "currency_code"},
 	BANK_ACCOUNT_HISTORY: {"account_id", "month_id", "balance"},
 	TRANSFERS: {
 		"history_id",
@@ -42,6 +48,7 @@ var tableData = TableFields{
 		"card_number",
 		"last_four_digits",
 		"notes",
+		"currency_code",
 	},
 	CREDIT_CARD_HISTORY: {
 		"card_id",
@@ -58,6 +65,9 @@ var tableData = TableFields{
 		"amount",
 		"due_day",
 		"period",
+		"anchor_year",
+		"anchor_month",
+		"every_n_months",
 	},
 	BILL_HISTORY: {
 		"bill_id",
@@ -68,6 +78,47 @@ var tableData = TableFields{
 		"due_day",
 		"notes",
 	},
+	EXCHANGE_RATES: {"month_id", "from_code", "to_code", "rate"},
+}
+
+/*
+joinedColumns are selected after the columns in tableData, for what a
+record needs from the rows it points at. History and transfers are kept in
+the currency of their account or card.
+*/
+var joinedColumns = TableFields{
+	BANK_ACCOUNT_HISTORY: {
+		"(SELECT currency_code FROM bank_accounts WHERE id = bank_account_history.account_id)",
+	},
+	CREDIT_CARD_HISTORY: {
+		"(SELECT currency_code FROM credit_cards WHERE id = credit_card_history.card_id)",
+	},
+	TRANSFERS: {
+		`(SELECT a.currency_code FROM bank_account_history h
+		  JOIN bank_accounts a ON a.id = h.account_id
+		  WHERE h.id = transfers.history_id)`,
+	},
+}
+
+/*
+archiveTables maps each template table to the history table that references
+it. Templates with history are archived instead of deleted, so the history
+keeps pointing at a real row.
+*/
+var archiveTables = map[Table]struct {
+	history Table
+	column  string
+}{
+	INCOME:        {INCOME_HISTORY, "income_id"},
+	BANK_ACCOUNTS: {BANK_ACCOUNT_HISTORY, "account_id"},
+	CREDIT_CARDS:  {CREDIT_CARD_HISTORY, "card_id"},
+	BILLS:         {BILL_HISTORY, "bill_id"},
+}
+
+// encryptedColumns lists the columns that are encrypted with the vault's data key
+var encryptedColumns = map[Table][]string{
+	BANK_ACCOUNTS: {"account_number", "notes"},
+	CREDIT_CARDS:  {"card_number", "notes"},
 }
 
 type (
@@ -89,28 +140,317 @@ const (
 	WHERE_INCOME_HISTORY_ID
 	WHERE_CREDIT_CARD_ID
 	WHERE_BILL_ID
+	WHERE_ARCHIVED
+	WHERE_BANK_HISTORY_ID
+	WHERE_DUE_DAY
+	WHERE_PERIOD
+	WHERE_CREDIT_LIMIT
+	WHERE_NOTES
+)
+
+/*
+whereColumns resolves each WhereFlag to the column it filters on, per table.
+The same flag can point at differently named columns, like
+WHERE_BANK_ACCOUNT_ID which is stored as account_id. Flags missing from a
+table are not allowed to be queried on it.
+
+Encrypted columns are left out, since the same text is never encrypted the
+same way twice and would never match.
+*/
+var whereColumns = map[Table]map[WhereFlag]string{
+	MONTHS: {
+		WHERE_ID:    "id",
+		WHERE_YEAR:  "year",
+		WHERE_MONTH: "month",
+	},
+	INCOME: {
+		WHERE_ID:       "id",
+		WHERE_NAME:     "name",
+		WHERE_AMOUNT:   "amount",
+		WHERE_PERIOD:   "period",
+		WHERE_ARCHIVED: "archived",
+	},
+	INCOME_HISTORY: {
+		WHERE_ID:        "id",
+		WHERE_INCOME_ID: "income_id",
+		WHERE_MONTH_ID:  "month_id",
+		WHERE_AMOUNT:    "amount",
+	},
+	INCOME_AFFIXES: {
+		WHERE_ID:                "id",
+		WHERE_INCOME_HISTORY_ID: "history_id",
+		WHERE_NAME:              "name",
+		WHERE_AMOUNT:            "amount",
+	},
+	BANK_ACCOUNTS: {
+		WHERE_ID:       "id",
+		WHERE_NAME:     "name",
+		WHERE_ARCHIVED: "archived",
+	},
+	BANK_ACCOUNT_HISTORY: {
+		WHERE_ID:              "id",
+		WHERE_BANK_ACCOUNT_ID: "account_id",
+		WHERE_MONTH_ID:        "month_id",
+		WHERE_BALANCE:         "balance",
+	},
+	TRANSFERS: {
+		WHERE_ID:              "id",
+		WHERE_BANK_HISTORY_ID: "history_id",
+		WHERE_MONTH_ID:        "month_id",
+		WHERE_NAME:            "name",
+		WHERE_AMOUNT:          "amount",
+		WHERE_DUE_DAY:         "due_day",
+	},
+	CREDIT_CARDS: {
+		WHERE_ID:           "id",
+		WHERE_NAME:         "name",
+		WHERE_DUE_DAY:      "due_day",
+		WHERE_CREDIT_LIMIT: "credit_limit",
+		WHERE_ARCHIVED:     "archived",
+	},
+	CREDIT_CARD_HISTORY: {
+		WHERE_ID:             "id",
+		WHERE_CREDIT_CARD_ID: "card_id",
+		WHERE_MONTH_ID:       "month_id",
+		WHERE_BALANCE:        "balance",
+		WHERE_CREDIT_LIMIT:   "credit_limit",
+		WHERE_DUE_DAY:        "due_day",
+		WHERE_PERIOD:         "period",
+	},
+	BILLS: {
+		WHERE_ID:       "id",
+		WHERE_NAME:     "name",
+		WHERE_AMOUNT:   "amount",
+		WHERE_DUE_DAY:  "due_day",
+		WHERE_PERIOD:   "period",
+		WHERE_ARCHIVED: "archived",
+	},
+	BILL_HISTORY: {
+		WHERE_ID:       "id",
+		WHERE_BILL_ID:  "bill_id",
+		WHERE_MONTH_ID: "month_id",
+		WHERE_AMOUNT:   "amount",
+		WHERE_DUE_DAY:  "due_day",
+		WHERE_NOTES:    "notes",
+	},
+	EXCHANGE_RATES: {
+		WHERE_ID:       "id",
+		WHERE_MONTH_ID: "month_id",
+	},
+}
+
+type Operator int
+
+const (
+	OP_EQUAL = Operator(iota)
+	OP_NOT_EQUAL
+	OP_CONTAINS
+	OP_GREATER
+	OP_GREATER_EQUAL
+	OP_LESS
+	OP_LESS_EQUAL
+	OP_BETWEEN
+	OP_IN
+	OP_IS_NULL
+	OP_NOT_NULL
+	OP_MONTHS_BETWEEN
 )
 
-var WhereFieldMap = map[WhereFlag]string{
-	WHERE_ID:                "id",
-	WHERE_NAME:              "name",
-	WHERE_AMOUNT:            "amount",
-	WHERE_BALANCE:           "balance",
-	WHERE_YEAR:              "year",
-	WHERE_MONTH:             "month",
-	WHERE_MONTH_ID:          "month_id",
-	WHERE_BANK_ACCOUNT_ID:   "bank_account_id",
-	WHERE_INCOME_ID:         "income_id",
-	WHERE_INCOME_HISTORY_ID: "income_history_id",
-	WHERE_CREDIT_CARD_ID:    "credit_card_id",
-	WHERE_BILL_ID:           "bill_id",
+/*
+Condition compares a column against its values with an Operator. Any value
+in a QueryMap that isn't a Condition is treated as an exact match.
+
+	QueryMap{
+		WHERE_NAME:    Contains("rent"),
+		WHERE_DUE_DAY: Between(1, 15),
+		WHERE_ID:      In(1, 2, 3),
+	}
+*/
+type Condition struct {
+	Op     Operator
+	Values []any
+}
+
+func Equal(v any) Condition {
+	return Condition{OP_EQUAL, []any{v}}
+}
+
+func NotEqual(v any) Condition {
+	return Condition{OP_NOT_EQUAL, []any{v}}
+}
+
+func GreaterThan(v any) Condition {
+	return Condition{OP_GREATER, []any{v}}
+}
+
+func GreaterOrEqual(v any) Condition {
+	return Condition{OP_GREATER_EQUAL, []any{v}}
+}
+
+func LessThan(v any) Condition {
+	return Condition{OP_LESS, []any{v}}
+}
+
+func LessOrEqual(v any) Condition {
+	return Condition{OP_LESS_EQUAL, []any{v}}
+}
+
+func Between(low, high any) Condition {
+	return Condition{OP_BETWEEN, []any{low, high}}
+}
+
+func In(values ...any) Condition {
+	return Condition{OP_IN, values}
+}
+
+func IsNull() Condition {
+	return Condition{Op: OP_IS_NULL}
+}
+
+func NotNull() Condition {
+	return Condition{Op: OP_NOT_NULL}
+}
+
+// Contains matches text anywhere in the column. Wildcards match literally.
+func Contains(text string) Condition {
+	return Condition{OP_CONTAINS, []any{text}}
+}
+
+/*
+MonthsBetween matches the rows of the months from through to, inclusive.
+Only the year and month of each are used. It can only be used on
+WHERE_MONTH_ID, which is how every history table and transfers are tied to
+their month.
+
+	db.QueryCreditCardHistory(QueryMap{
+		WHERE_MONTH_ID: MonthsBetween(march, september),
+	})
+*/
+func MonthsBetween(from, to time.Time) Condition {
+	return YearMonthsBetween(from.Year(), from.Month(), to.Year(), to.Month())
+}
+
+// YearMonthsBetween is MonthsBetween with (year, month) bounds
+func YearMonthsBetween(
+	fromYear int,
+	fromMonth time.Month,
+	toYear int,
+	toMonth time.Month,
+) Condition {
+	return Condition{OP_MONTHS_BETWEEN, []any{
+		monthIndex(fromYear, int(fromMonth)),
+		monthIndex(toYear, int(toMonth)),
+	}}
+}
+
+type SortDirection string
+
+const (
+	ASC  = SortDirection("ASC")
+	DESC = SortDirection("DESC")
+)
+
+/*
+QueryOption sorts and pages through the rows of a query, on top of the
+QueryMap that filters them. Rows are sorted by each order in turn, and then
+by id, so rows that tie still come back in the same order every time.
+
+	db.QueryBillHistory(
+		QueryMap{WHERE_BILL_ID: 1},
+		OrderByMonth(DESC),
+		Limit(12),
+	)
+*/
+type QueryOption func(*queryOptions)
+
+type queryOptions struct {
+	orders []order
+	// A negative limit is no limit
+	limit  int
+	offset int
+	err    error
+}
+
+type order struct {
+	flag WhereFlag
+	// Sorts by the month the row belongs to, instead of by flag
+	byMonth bool
+	dir     SortDirection
+}
+
+func newQueryOptions(opts []QueryOption) queryOptions {
+	options := queryOptions{limit: -1}
+	for _, o := range opts {
+		o(&options)
+	}
+	return options
+}
+
+// OrderBy sorts by the column that flag filters on
+func OrderBy(flag WhereFlag, dir SortDirection) QueryOption {
+	return func(o *queryOptions) {
+		o.orders = append(o.orders, order{flag: flag, dir: dir})
+	}
+}
+
+/*
+OrderByMonth sorts rows by the month they belong to, so DESC puts the newest
+month first. It works on months and every table with a month_id.
+*/
+func OrderByMonth(dir SortDirection) QueryOption {
+	return func(o *queryOptions) {
+		o.orders = append(o.orders, order{byMonth: true, dir: dir})
+	}
+}
+
+// Limit returns at most n rows
+func Limit(n int) QueryOption {
+	return func(o *queryOptions) {
+		if n < 0 {
+			o.err = fmt.Errorf("%w: negative limit %d", ErrInvalidOption, n)
+		}
+		o.limit = n
+	}
+}
+
+// Offset skips the first n rows
+func Offset(n int) QueryOption {
+	return func(o *queryOptions) {
+		if n < 0 {
+			o.err = fmt.Errorf("%w: negative offset %d", ErrInvalidOption, n)
+		}
+		o.offset = n
+	}
 }
 
 type Period string
 
 const (
-	YEARLY   = Period("yearly")
-	MONTHLY  = Period("monthly")
-	WEEKLY   = Period("weekly")
-	BIWEEKLY = Period("biweekly")
+	YEARLY         = Period("yearly")
+	SEMIANNUAL     = Period("semiannual")
+	QUARTERLY      = Period("quarterly")
+	MONTHLY        = Period("monthly")
+	WEEKLY         = Period("weekly")
+	BIWEEKLY       = Period("biweekly")
+	EVERY_N_MONTHS = Period("every_n_months")
 )
+
+/*
+Months returns how many months pass between each due date of the period.
+Periods that aren't measured in whole months return 0, and so does
+EVERY_N_MONTHS since its length is stored alongside it.
+*/
+func (p Period) Months() int {
+	switch p {
+	case MONTHLY:
+		return 1
+	case QUARTERLY:
+		return 3
+	case SEMIANNUAL:
+		return 6
+	case YEARLY:
+		return 12
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,83 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+ErrStop can be returned by the callback of an Each* method to stop early,
+without Each* returning an error.
+*/
+var ErrStop = fmt.Errorf("stop iterating")
+
+// scanner is the part of *sql.Rows that a row is scanned with
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanFunc scans the current row into a record
+type scanFunc[T any] func(row scanner) (T, error)
+
+/*
+eachRow scans every row of t matching qm with scan, and passes the records
+to fn one at a time, so a table never has to be loaded all at once. The
+rows are always closed, and an error that stopped the iteration early is
+returned instead of being mistaken for the last row.
+
+fn runs while the rows are still open, so it must not use the database. An
+encrypted database only has the one connection, which fn would wait on
+forever.
+*/
+func eachRow[T any](
+	sdb SqliteDb,
+	t Table,
+	qm QueryMap,
+	opts []QueryOption,
+	scan scanFunc[T],
+	fn func(T) error,
+) error {
+	rows, err := sdb.query(t, qm, opts...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scan(rows)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(record); err != nil {
+			if errors.Is(err, ErrStop) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// allRows scans every row of t matching qm with scan, sorted and paged by opts
+func allRows[T any](
+	sdb SqliteDb,
+	t Table,
+	qm QueryMap,
+	opts []QueryOption,
+	scan scanFunc[T],
+) ([]T, error) {
+	var records []T
+	err := eachRow(sdb, t, qm, opts, scan, func(record T) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
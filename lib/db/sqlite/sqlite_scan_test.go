@@ -0,0 +1,133 @@
+package sqlite
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jaeiya/billbank/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEachRow(t *testing.T) {
+	fillDb := func(t *testing.T, db *SqliteDb) {
+		r := require.New(t)
+		r.NoError(db.CreateNewBill(BillsConfig{
+			Name:   "Mom's Rent",
+			Amount: lib.NewCurrency("1500", lib.USD),
+			DueDay: 1,
+			Period: MONTHLY,
+		}))
+		for month := time.January; month <= time.March; month++ {
+			_, err := db.StartNewMonth(time.Date(2024, month, 1, 0, 0, 0, 0, time.Local))
+			r.NoError(err)
+		}
+	}
+
+	requireClosed := func(t *testing.T, db *SqliteDb) {
+		assert.Zero(t, db.handle.Stats().InUse, "rows were left open")
+	}
+
+	t.Run("should stream the same records as the query", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+		fillDb(t, db)
+
+		expected, err := db.QueryBillHistory(QueryMap{})
+		r.NoError(err)
+		r.Len(expected, 3)
+
+		var streamed []BillHistoryRecord
+		r.NoError(db.EachBillHistory(QueryMap{}, func(record BillHistoryRecord) error {
+			streamed = append(streamed, record)
+			return nil
+		}))
+		a.Equal(expected, streamed)
+		requireClosed(t, db)
+	})
+
+	t.Run("should stop early without an error", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+		fillDb(t, db)
+
+		calls := 0
+		r.NoError(db.EachBillHistory(QueryMap{}, func(BillHistoryRecord) error {
+			calls++
+			return ErrStop
+		}))
+		a.Equal(1, calls)
+		requireClosed(t, db)
+	})
+
+	t.Run("should return the error of fn", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+		fillDb(t, db)
+		errMock := errors.New("mock error")
+
+		err := db.EachBillHistory(QueryMap{}, func(BillHistoryRecord) error {
+			return errMock
+		})
+		a.ErrorIs(err, errMock)
+		requireClosed(t, db)
+	})
+
+	t.Run("should close the rows when a row fails to scan", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		_, err := db.CreateIncome(IncomeConfig{
+			Name:   "Salary",
+			Amount: lib.NewCurrency("1000", lib.USD),
+			Period: MONTHLY,
+		})
+		r.NoError(err)
+		_, err = db.handle.Exec("UPDATE income SET pay_date = 'not a date'")
+		r.NoError(err)
+
+		_, err = db.QueryIncome(QueryMap{})
+		a.ErrorContains(err, "cannot parse pay date")
+		requireClosed(t, db)
+	})
+
+	t.Run("should not call fn without any rows", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+
+		for _, each := range []func() error{
+			func() error {
+				return db.EachBankAccountHistory(QueryMap{}, func(BankHistoryRecord) error {
+					return errors.New("called")
+				})
+			},
+			func() error {
+				return db.EachCreditCardHistory(QueryMap{}, func(CardHistoryRecord) error {
+					return errors.New("called")
+				})
+			},
+			func() error {
+				return db.EachIncomeHistory(QueryMap{}, func(IncomeHistoryRecord) error {
+					return errors.New("called")
+				})
+			},
+			func() error {
+				return db.EachTransfer(QueryMap{}, func(TransferRecord) error {
+					return errors.New("called")
+				})
+			},
+		} {
+			a.NoError(each())
+		}
+		requireClosed(t, db)
+	})
+}
@@ -0,0 +1,537 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaeiya/billbank/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsertInto(t *testing.T) {
+	t.Run("should use placeholders for every value", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := SqliteDb{}
+
+		query, args := db.InsertInto(BILLS, "Mom's Rent", 150000, 1, MONTHLY, nil, nil, nil)
+		a.Equal("INSERT INTO bills (name,amount,due_day,period) VALUES (?,?,?,?)", query)
+		a.Equal([]any{"Mom's Rent", 150000, 1, "monthly"}, args)
+	})
+
+	t.Run("should leave out nil values", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := SqliteDb{}
+
+		query, args := db.InsertInto(BANK_ACCOUNTS, "checking", nil, nil, nil)
+		a.Equal("INSERT INTO bank_accounts (name) VALUES (?)", query)
+		a.Equal([]any{"checking"}, args)
+	})
+}
+
+func TestSpecialCharacterNames(t *testing.T) {
+	names := []string{
+		"Mom's Rent",
+		`"Quoted" Bill`,
+		"100% Juice",
+		"under_score",
+		`back\slash`,
+		"'); DROP TABLE bills; --",
+		"家賃",
+		"Café ☕",
+	}
+
+	t.Run("should store and load names with special characters", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		for _, name := range names {
+			r.NoError(db.CreateNewBill(BillsConfig{
+				Name:   name,
+				Amount: lib.NewCurrency("10", lib.USD),
+				DueDay: 1,
+				Period: MONTHLY,
+			}))
+		}
+
+		bills, err := db.QueryBills(QueryMap{})
+		r.NoError(err)
+		r.Len(bills, len(names))
+		for i, bill := range bills {
+			a.Equal(names[i], bill.Name)
+		}
+	})
+
+	t.Run("should match wildcard characters literally", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		cards := []string{"100% Cash Back", "1000 Points", "Mom's Card", "my_card", "mycard", "カード"}
+		for _, name := range cards {
+			r.NoError(db.CreateCreditCard(CreditCardConfig{
+				Name:           name,
+				DueDay:         1,
+				LastFourDigits: "1234",
+			}))
+		}
+
+		mocks := map[string][]string{
+			"100%":    {"100% Cash Back"},
+			"Mom's":   {"Mom's Card"},
+			"my_card": {"my_card"},
+			"カード":     {"カード"},
+		}
+
+		for search, expected := range mocks {
+			res, err := db.QueryCreditCards(QueryMap{WHERE_NAME: Contains(search)})
+			r.NoError(err)
+
+			var found []string
+			for _, card := range res {
+				found = append(found, card.Name)
+			}
+			a.Equal(expected, found, "search: %s", search)
+		}
+	})
+}
+
+func TestQueryErrors(t *testing.T) {
+	t.Run("should return ErrNoRows for empty results", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+
+		_, err := db.QueryBills(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+
+		_, err = db.QueryMonths(QueryMap{WHERE_YEAR: 2024})
+		a.ErrorIs(err, ErrNoRows)
+
+		_, err = db.QueryCreditCards(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+	})
+
+	t.Run("should error on fields the table does not allow", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+
+		_, err := db.QueryBills(QueryMap{WHERE_YEAR: 2024})
+		a.ErrorIs(err, ErrFieldNotAllowed)
+		a.NotErrorIs(err, ErrNoRows)
+	})
+
+	t.Run("should error on unsupported value types", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+
+		_, err := db.QueryBills(QueryMap{WHERE_ID: 1.5})
+		a.ErrorIs(err, ErrUnsupportedType)
+	})
+}
+
+func TestQueryColumnMapping(t *testing.T) {
+	t.Run("should filter history by bank account and credit card", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		for _, name := range []string{"checking", "savings"} {
+			r.NoError(db.CreateBankAccount(BankAccountConfig{Name: name}))
+			r.NoError(db.CreateCreditCard(CreditCardConfig{
+				Name:           name,
+				DueDay:         1,
+				LastFourDigits: "1234",
+			}))
+		}
+		for id := 1; id <= 2; id++ {
+			r.NoError(db.CreateBankAccountHistory(BankHistoryConfig{MonthID: 1, BankAccountID: id}))
+			r.NoError(db.CreateCreditCardHistory(CreditCardHistoryConfig{
+				CreditCardID: id,
+				MonthID:      1,
+				Balance:      lib.NewCurrency("10", lib.USD),
+				DueDay:       1,
+			}))
+		}
+
+		bankHistory, err := db.QueryBankAccountHistory(QueryMap{WHERE_BANK_ACCOUNT_ID: 2})
+		r.NoError(err)
+		r.Len(bankHistory, 1)
+		a.Equal(2, bankHistory[0].BankAccountID)
+
+		cardHistory, err := db.QueryCreditCardHistory(QueryMap{WHERE_CREDIT_CARD_ID: 2})
+		r.NoError(err)
+		r.Len(cardHistory, 1)
+		a.Equal(2, cardHistory[0].CreditCardID)
+	})
+
+	t.Run("should filter transfers and affixes by their history", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateMonth(time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)))
+		r.NoError(db.CreateBankAccount(BankAccountConfig{Name: "checking"}))
+		_, err := db.CreateIncome(IncomeConfig{
+			Name:   "job",
+			Amount: lib.NewCurrency("100", lib.USD),
+			Period: MONTHLY,
+		})
+		r.NoError(err)
+
+		for id := 1; id <= 2; id++ {
+			r.NoError(db.CreateBankAccountHistory(BankHistoryConfig{MonthID: 1, BankAccountID: 1}))
+			r.NoError(db.CreateTransfer(TransferConfig{
+				HistoryID:    id,
+				MonthID:      1,
+				Name:         "transfer",
+				Amount:       lib.NewCurrency("10", lib.USD),
+				DueDay:       1,
+				TransferType: MOVE,
+			}))
+			r.NoError(db.CreateIncomeHistory(IncomeHistoryConfig{
+				IncomeID: 1,
+				MonthID:  1,
+				Amount:   lib.NewCurrency("100", lib.USD),
+			}))
+			r.NoError(db.AffixIncome(id, "bonus", lib.NewCurrency("5", lib.USD)))
+		}
+
+		transfers, err := db.QueryTransfers(QueryMap{WHERE_BANK_HISTORY_ID: 2})
+		r.NoError(err)
+		r.Len(transfers, 1)
+		a.Equal(2, transfers[0].HistoryID)
+
+		affixes, err := db.QueryAffixIncome(QueryMap{WHERE_INCOME_HISTORY_ID: 2})
+		r.NoError(err)
+		r.Len(affixes, 1)
+		a.Equal(2, affixes[0].IncomeHistoryID)
+	})
+
+	t.Run("should error on flags the table does not have", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+
+		_, err := db.QueryTransfers(QueryMap{WHERE_CREDIT_CARD_ID: 1})
+		a.ErrorIs(err, ErrFieldNotAllowed)
+
+		_, err = db.QueryMonths(QueryMap{WHERE_NAME: "january"})
+		a.ErrorIs(err, ErrFieldNotAllowed)
+
+		// Encrypted notes can't be matched
+		_, err = db.QueryBankAccounts(QueryMap{WHERE_NOTES: "joint"})
+		a.ErrorIs(err, ErrFieldNotAllowed)
+
+		_, err = db.QueryCreditCards(QueryMap{WHERE_NOTES: Contains("travel")})
+		a.ErrorIs(err, ErrFieldNotAllowed)
+	})
+}
+
+func TestQueryOperators(t *testing.T) {
+	bills := []BillsConfig{
+		{Name: "rent", Amount: lib.NewCurrency("1200", lib.USD), DueDay: 1, Period: MONTHLY},
+		{Name: "rent insurance", Amount: lib.NewCurrency("20", lib.USD), DueDay: 10, Period: MONTHLY},
+		{Name: "internet", Amount: lib.NewCurrency("60", lib.USD), DueDay: 15, Period: MONTHLY},
+		{
+			Name:        "domain",
+			Amount:      lib.NewCurrency("12", lib.USD),
+			DueDay:      28,
+			Period:      YEARLY,
+			AnchorMonth: time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local),
+		},
+	}
+
+	billNames := func(records []BillRecord) []string {
+		var names []string
+		for _, b := range records {
+			names = append(names, b.Name)
+		}
+		return names
+	}
+
+	mocks := []struct {
+		should   string
+		qm       QueryMap
+		expected []string
+	}{
+		{
+			should:   "match names exactly",
+			qm:       QueryMap{WHERE_NAME: "rent"},
+			expected: []string{"rent"},
+		},
+		{
+			should:   "match part of a name",
+			qm:       QueryMap{WHERE_NAME: Contains("rent")},
+			expected: []string{"rent", "rent insurance"},
+		},
+		{
+			should:   "exclude a value",
+			qm:       QueryMap{WHERE_PERIOD: NotEqual(MONTHLY)},
+			expected: []string{"domain"},
+		},
+		{
+			should:   "match a range",
+			qm:       QueryMap{WHERE_DUE_DAY: Between(5, 15)},
+			expected: []string{"rent insurance", "internet"},
+		},
+		{
+			should:   "match an open range",
+			qm:       QueryMap{WHERE_AMOUNT: GreaterOrEqual(lib.NewCurrency("60", lib.USD))},
+			expected: []string{"rent", "internet"},
+		},
+		{
+			should:   "match a set of values",
+			qm:       QueryMap{WHERE_ID: In(1, 3, 4)},
+			expected: []string{"rent", "internet", "domain"},
+		},
+		{
+			should: "combine conditions",
+			qm: QueryMap{
+				WHERE_DUE_DAY: LessThan(20),
+				WHERE_AMOUNT:  LessThan(lib.NewCurrency("100", lib.USD)),
+			},
+			expected: []string{"rent insurance", "internet"},
+		},
+	}
+
+	for _, mock := range mocks {
+		t.Run("should "+mock.should, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+			db := newMockDb(t)
+
+			for _, b := range bills {
+				r.NoError(db.CreateNewBill(b))
+			}
+
+			res, err := db.QueryBills(mock.qm)
+			r.NoError(err)
+			a.Equal(mock.expected, billNames(res))
+		})
+	}
+
+	t.Run("should match null columns", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateCreditCard(CreditCardConfig{
+			Name:           "no limit",
+			DueDay:         1,
+			LastFourDigits: "1234",
+		}))
+		r.NoError(db.CreateCreditCard(CreditCardConfig{
+			Name:           "limited",
+			DueDay:         1,
+			CreditLimit:    lib.NewPointer(lib.NewCurrency("500", lib.USD)),
+			LastFourDigits: "5678",
+		}))
+
+		res, err := db.QueryCreditCards(QueryMap{WHERE_CREDIT_LIMIT: IsNull()})
+		r.NoError(err)
+		r.Len(res, 1)
+		a.Equal("no limit", res[0].Name)
+
+		res, err = db.QueryCreditCards(QueryMap{WHERE_CREDIT_LIMIT: NotNull()})
+		r.NoError(err)
+		r.Len(res, 1)
+		a.Equal("limited", res[0].Name)
+
+		res, err = db.QueryCreditCards(QueryMap{WHERE_CREDIT_LIMIT: nil})
+		r.NoError(err)
+		r.Len(res, 1)
+		a.Equal("no limit", res[0].Name)
+	})
+
+	t.Run("should error on invalid conditions", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+
+		_, err := db.QueryBills(QueryMap{WHERE_ID: In()})
+		a.ErrorIs(err, ErrInvalidCondition)
+
+		_, err = db.QueryBills(QueryMap{WHERE_ID: Condition{Op: OP_BETWEEN, Values: []any{1}}})
+		a.ErrorIs(err, ErrInvalidCondition)
+
+		_, err = db.QueryBills(QueryMap{WHERE_ID: GreaterThan(nil)})
+		a.ErrorIs(err, ErrInvalidCondition)
+
+		_, err = db.QueryBills(QueryMap{WHERE_NAME: Condition{Op: OP_CONTAINS, Values: []any{1}}})
+		a.ErrorIs(err, ErrUnsupportedType)
+
+		_, err = db.QueryBills(QueryMap{WHERE_ID: In(1, 2.5)})
+		a.ErrorIs(err, ErrUnsupportedType)
+	})
+}
+
+func TestQueryOptions(t *testing.T) {
+	bills := []BillsConfig{
+		{Name: "rent", Amount: lib.NewCurrency("1200", lib.USD), DueDay: 15, Period: MONTHLY},
+		{Name: "internet", Amount: lib.NewCurrency("60", lib.USD), DueDay: 1, Period: MONTHLY},
+		{Name: "phone", Amount: lib.NewCurrency("40", lib.USD), DueDay: 15, Period: MONTHLY},
+		{Name: "power", Amount: lib.NewCurrency("90", lib.USD), DueDay: 28, Period: MONTHLY},
+	}
+
+	billNames := func(records []BillRecord) []string {
+		var names []string
+		for _, b := range records {
+			names = append(names, b.Name)
+		}
+		return names
+	}
+
+	mocks := []struct {
+		should   string
+		opts     []QueryOption
+		expected []string
+	}{
+		{
+			should:   "order by id without any options",
+			expected: []string{"rent", "internet", "phone", "power"},
+		},
+		{
+			should:   "order by a column and then by id",
+			opts:     []QueryOption{OrderBy(WHERE_DUE_DAY, ASC)},
+			expected: []string{"internet", "rent", "phone", "power"},
+		},
+		{
+			should:   "order descending",
+			opts:     []QueryOption{OrderBy(WHERE_DUE_DAY, DESC)},
+			expected: []string{"power", "rent", "phone", "internet"},
+		},
+		{
+			should:   "order by each column in turn",
+			opts:     []QueryOption{OrderBy(WHERE_DUE_DAY, DESC), OrderBy(WHERE_NAME, ASC)},
+			expected: []string{"power", "phone", "rent", "internet"},
+		},
+		{
+			should:   "limit the rows",
+			opts:     []QueryOption{OrderBy(WHERE_AMOUNT, DESC), Limit(2)},
+			expected: []string{"rent", "power"},
+		},
+		{
+			should:   "page through the rows",
+			opts:     []QueryOption{OrderBy(WHERE_AMOUNT, DESC), Limit(2), Offset(2)},
+			expected: []string{"internet", "phone"},
+		},
+		{
+			should:   "skip rows without a limit",
+			opts:     []QueryOption{Offset(3)},
+			expected: []string{"power"},
+		},
+	}
+
+	for _, mock := range mocks {
+		t.Run("should "+mock.should, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			r := require.New(t)
+			db := newMockDb(t)
+
+			for _, b := range bills {
+				r.NoError(db.CreateNewBill(b))
+			}
+
+			res, err := db.QueryBills(QueryMap{}, mock.opts...)
+			r.NoError(err)
+			a.Equal(mock.expected, billNames(res))
+		})
+	}
+
+	t.Run("should order history by its month, newest first", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.CreateNewBill(bills[0]))
+		// Backfilled months are created out of order
+		for _, month := range []time.Month{time.March, time.January, time.April, time.February} {
+			_, err := db.StartNewMonth(time.Date(2024, month, 1, 0, 0, 0, 0, time.Local))
+			r.NoError(err)
+		}
+
+		history, err := db.QueryBillHistory(
+			QueryMap{WHERE_BILL_ID: 1},
+			OrderByMonth(DESC),
+			Limit(3),
+		)
+		r.NoError(err)
+
+		var monthIDs []int
+		for _, h := range history {
+			monthIDs = append(monthIDs, h.MonthID)
+		}
+		a.Equal([]int{3, 1, 4}, monthIDs)
+
+		months, err := db.QueryMonths(QueryMap{}, OrderByMonth(ASC))
+		r.NoError(err)
+		r.Len(months, 4)
+		a.Equal(1, months[0].Month)
+		a.Equal(4, months[3].Month)
+	})
+
+	t.Run("should build the same statement every time", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		fm := FieldMap{"name": "rent", "due_day": 1, "amount": 100, "period": MONTHLY}
+		options := newQueryOptions([]QueryOption{OrderBy(WHERE_NAME, DESC), Limit(5)})
+
+		first, args, err := buildQueryStr(BILLS, fm, options)
+		r.NoError(err)
+		a.Contains(first, "WHERE amount=? AND due_day=? AND name=? AND period=?")
+		a.Contains(first, "ORDER BY name DESC, id LIMIT ? OFFSET ?")
+		a.Equal([]any{100, 1, "rent", "monthly", 5, 0}, args)
+
+		for range 20 {
+			query, _, err := buildQueryStr(BILLS, fm, options)
+			r.NoError(err)
+			a.Equal(first, query)
+		}
+	})
+
+	t.Run("should error on invalid options", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+
+		_, err := db.QueryBills(QueryMap{}, Limit(-1))
+		a.ErrorIs(err, ErrInvalidOption)
+
+		_, err = db.QueryBills(QueryMap{}, Offset(-1))
+		a.ErrorIs(err, ErrInvalidOption)
+
+		_, err = db.QueryBills(QueryMap{}, OrderBy(WHERE_NAME, SortDirection("sideways")))
+		a.ErrorIs(err, ErrInvalidOption)
+
+		_, err = db.QueryBills(QueryMap{}, OrderBy(WHERE_YEAR, ASC))
+		a.ErrorIs(err, ErrFieldNotAllowed)
+
+		_, err = db.QueryBills(QueryMap{}, OrderByMonth(DESC))
+		a.ErrorIs(err, ErrFieldNotAllowed)
+	})
+}
+
+func newMockDb(t *testing.T) *SqliteDb {
+	db, err := NewSqliteDb(filepath.Join(t.TempDir(), "mock.db"), lib.USD)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, db.Close()) })
+	return db
+}
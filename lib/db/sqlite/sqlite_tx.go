@@ -0,0 +1,116 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+var (
+	ErrTxActive   = fmt.Errorf("not allowed within a transaction")
+	ErrNotFlushed = fmt.Errorf("committed, but not written to the database file")
+)
+
+// dbConn is what's shared by the database handle and its transactions
+type dbConn interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+/*
+conn is the transaction the database is bound to, or its handle otherwise.
+Writes made through the handle of an encrypted database are flushed as
+soon as they're made.
+*/
+func (sdb SqliteDb) conn() dbConn {
+	if sdb.tx != nil {
+		return sdb.tx
+	}
+	if sdb.file != nil {
+		return flushingConn{sdb}
+	}
+	return sdb.handle
+}
+
+// flushingConn flushes an encrypted database after every write to its handle
+type flushingConn struct {
+	sdb SqliteDb
+}
+
+func (c flushingConn) Exec(query string, args ...any) (sql.Result, error) {
+	res, err := c.sdb.handle.Exec(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.sdb.flushWrites(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c flushingConn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.sdb.handle.Query(query, args...)
+}
+
+func (c flushingConn) QueryRow(query string, args ...any) *sql.Row {
+	return c.sdb.handle.QueryRow(query, args...)
+}
+
+/*
+flushWrites flushes writes that were just committed to an encrypted
+database, so they're in the file before anything else happens. When that
+fails the writes are kept in memory for the next flush, and the error wraps
+ErrNotFlushed.
+*/
+func (sdb SqliteDb) flushWrites() error {
+	if err := sdb.Flush(); err != nil {
+		return fmt.Errorf("%w: %w", ErrNotFlushed, err)
+	}
+	return nil
+}
+
+/*
+WithTx runs fn with a copy of the database bound to a transaction, so that
+every write fn makes through it is committed together, or not at all. The
+transaction is rolled back when fn returns an error or panics, and the
+error is returned as is.
+
+An encrypted database is flushed once the transaction is committed. If
+that fails the error wraps ErrNotFlushed, and unlike any other error the
+writes were kept.
+
+Calling WithTx again on the copy joins the same transaction instead of
+starting another one. Setting or changing the password and flushing an
+encrypted database can't be rolled back, so they return ErrTxActive
+within fn.
+
+	err := db.WithTx(func(tx SqliteDb) error {
+		if err := tx.CreateNewBill(bill); err != nil {
+			return err
+		}
+		return tx.CreateBillHistory(history)
+	})
+*/
+func (sdb SqliteDb) WithTx(fn func(tx SqliteDb) error) error {
+	if sdb.tx != nil {
+		return fn(sdb)
+	}
+
+	tx, err := sdb.handle.Begin()
+	if err != nil {
+		return fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	// Does nothing once committed
+	defer func() { _ = tx.Rollback() }()
+
+	bound := sdb
+	bound.tx = tx
+	if err := fn(bound); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return sdb.flushWrites()
+}
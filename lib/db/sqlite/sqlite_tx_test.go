@@ -0,0 +1,202 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaeiya/billbank/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTx(t *testing.T) {
+	bill := BillsConfig{
+		Name:   "Mom's Rent",
+		Amount: lib.NewCurrency("1500", lib.USD),
+		DueDay: 1,
+		Period: MONTHLY,
+	}
+
+	history := func(monthID int) BillHistoryConfig {
+		return BillHistoryConfig{
+			BillID:  1,
+			MonthID: monthID,
+			Amount:  lib.NewCurrency("1500", lib.USD),
+			DueDay:  1,
+		}
+	}
+
+	requireNoBills := func(t *testing.T, db *SqliteDb) {
+		_, err := db.QueryBills(QueryMap{})
+		assert.ErrorIs(t, err, ErrNoRows)
+	}
+
+	t.Run("should commit every write together", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+		r.NoError(db.CreateMonth(time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)))
+
+		r.NoError(db.WithTx(func(tx SqliteDb) error {
+			if err := tx.CreateNewBill(bill); err != nil {
+				return err
+			}
+			return tx.CreateBillHistory(history(1))
+		}))
+
+		bills, err := db.QueryBills(QueryMap{})
+		r.NoError(err)
+		a.Len(bills, 1)
+
+		histories, err := db.QueryBillHistory(QueryMap{})
+		r.NoError(err)
+		a.Len(histories, 1)
+	})
+
+	t.Run("should roll back every write on error", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+
+		err := db.WithTx(func(tx SqliteDb) error {
+			if err := tx.CreateNewBill(bill); err != nil {
+				return err
+			}
+			// There's no month to add the history to
+			return tx.CreateBillHistory(history(99))
+		})
+		a.ErrorIs(err, ErrForeignKey)
+		requireNoBills(t, db)
+	})
+
+	t.Run("should check foreign keys on every connection", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		// The connection the database was opened on is kept busy, so the
+		// transaction has to open another one
+		held, err := db.handle.Conn(context.Background())
+		r.NoError(err)
+		defer held.Close()
+
+		err = db.WithTx(func(tx SqliteDb) error {
+			if err := tx.CreateNewBill(bill); err != nil {
+				return err
+			}
+			return tx.CreateBillHistory(history(99))
+		})
+		a.ErrorIs(err, ErrForeignKey)
+	})
+
+	t.Run("should return the error of fn as is", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+		errMock := errors.New("mock error")
+
+		err := db.WithTx(func(tx SqliteDb) error {
+			if err := tx.CreateNewBill(bill); err != nil {
+				return err
+			}
+			return errMock
+		})
+		a.Equal(errMock, err)
+		requireNoBills(t, db)
+	})
+
+	t.Run("should roll back on panic", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+
+		a.Panics(func() {
+			_ = db.WithTx(func(tx SqliteDb) error {
+				if err := tx.CreateNewBill(bill); err != nil {
+					return err
+				}
+				panic("mock panic")
+			})
+		})
+		requireNoBills(t, db)
+	})
+
+	t.Run("should join the outer transaction", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		db := newMockDb(t)
+
+		err := db.WithTx(func(tx SqliteDb) error {
+			if err := tx.WithTx(func(inner SqliteDb) error {
+				return inner.CreateNewBill(bill)
+			}); err != nil {
+				return err
+			}
+			// Encrypted fields are inserted in a transaction of their own
+			if err := tx.CreateBankAccount(BankAccountConfig{Name: "checking"}); err != nil {
+				return err
+			}
+			return tx.CreateBillHistory(history(99))
+		})
+		a.ErrorIs(err, ErrForeignKey)
+		requireNoBills(t, db)
+
+		_, err = db.QueryBankAccounts(QueryMap{})
+		a.ErrorIs(err, ErrNoRows)
+	})
+
+	t.Run("should not change the password within a transaction", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		db := newMockDb(t)
+
+		r.NoError(db.WithTx(func(tx SqliteDb) error {
+			a.ErrorIs(tx.SetPassword("password"), ErrTxActive)
+			return nil
+		}))
+		a.True(db.IsLocked())
+
+		r.NoError(db.SetPassword("password"))
+		r.NoError(db.WithTx(func(tx SqliteDb) error {
+			a.ErrorIs(tx.ChangePassword("password", "new password"), ErrTxActive)
+			return nil
+		}))
+		a.NoError(db.Unlock("password"))
+	})
+
+	t.Run("should read its own writes with a single connection", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+		path := filepath.Join(t.TempDir(), "encrypted.db")
+
+		db, err := NewEncryptedSqliteDb(path, "password", lib.USD)
+		r.NoError(err)
+		t.Cleanup(func() { assert.NoError(t, db.Close()) })
+
+		r.NoError(db.WithTx(func(tx SqliteDb) error {
+			if err := tx.CreateBankAccount(BankAccountConfig{
+				Name:          "checking",
+				AccountNumber: lib.NewPointer("282841"),
+			}); err != nil {
+				return err
+			}
+
+			accounts, err := tx.QueryBankAccounts(QueryMap{})
+			if err != nil {
+				return err
+			}
+			a.Equal(lib.NewSecret("282841"), accounts[0].AccountNumber)
+
+			a.ErrorIs(tx.Flush(), ErrTxActive)
+			return nil
+		}))
+		a.NoError(db.Flush())
+	})
+}
@@ -2,13 +2,10 @@ package lib
 
 import (
 	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
-	"io"
 
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/pbkdf2"
@@ -20,9 +17,12 @@ const (
 	threads    uint8  = 4
 	saltLength uint32 = 16
 	hashLength uint32 = 32
+
+	legacySaltLength = 16
+	legacyIterations = 100_000
 )
 
-var ErrEncryptWithoutPassword = fmt.Errorf("tried to encrypt data without password")
+var ErrPasswordHash = fmt.Errorf("malformed password hash")
 
 func HashPassword(password string) (string, error) {
 	salt := make([]byte, saltLength)
@@ -42,98 +42,46 @@ func ValidatePassword(password string, storedPass string) (bool, error) {
 		return false, err
 	}
 
-	salt := storedBytes[:16]
-	hash := storedBytes[16:]
-	newHash := argon2.IDKey([]byte(password), salt, timeCost, memoryCost, threads, hashLength)
-
-	return bytes.Equal(hash, newHash), nil
-}
-
-func EncryptData(data string, password string) string {
-	salt := make([]byte, 16)
-	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
-		panic(err)
-	}
-	key := deriveKey(password, salt)
-
-	cBlock, err := aes.NewCipher(key)
-	if err != nil {
-		panic(err)
+	if len(storedBytes) != int(saltLength+hashLength) {
+		return false, ErrPasswordHash
 	}
 
-	aesGCM, err := cipher.NewGCM(cBlock)
-	if err != nil {
-		panic(err)
-	}
-
-	nonce := make([]byte, aesGCM.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		panic(err)
-	}
-
-	cipherText := aesGCM.Seal(nonce, nonce, []byte(data), nil)
+	salt := storedBytes[:saltLength]
+	hash := storedBytes[saltLength:]
+	newHash := argon2.IDKey([]byte(password), salt, timeCost, memoryCost, threads, hashLength)
 
-	return base64.StdEncoding.EncodeToString(append(salt, cipherText...))
+	return bytes.Equal(hash, newHash), nil
 }
 
 /*
-EncryptNonNil encrypts data using the password and returns the encrypted
-string; however if data is nil it returns nil.
+DecryptLegacyData decrypts the base64 of salt, nonce and cipher text that
+fields were stored as before the Vault, where every field derived its own
+key from the password with PBKDF2. It's only kept so those fields can be
+moved into a vault.
 */
-func EncryptNonNil(data *string, password *string) any /* nil|string */ {
-	if data == nil {
-		return nil
-	}
-	if password == nil {
-		panic(ErrEncryptWithoutPassword)
-	}
-	return EncryptData(*data, *password)
-}
-
-func DecryptNonNil(data *string, password string) (*string, error) {
-	if data == nil {
-		return nil, nil
-	}
-
-	decData, err := DecryptData(*data, password)
-	if err != nil {
-		return nil, err
-	}
-
-	return &decData, err
-}
-
-func DecryptData(data string, password string) (string, error) {
+func DecryptLegacyData(data string, password string) (string, error) {
 	cipherText, err := base64.StdEncoding.DecodeString(data)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %w", ErrCipherText, err)
 	}
 
-	salt := cipherText[:16]
-	key := deriveKey(password, salt)
-
-	cBlock, err := aes.NewCipher(key)
-	if err != nil {
-		return "", err
+	if len(cipherText) < legacySaltLength+nonceLength+tagLength {
+		return "", ErrCipherTextLength
 	}
 
-	aesGCM, err := cipher.NewGCM(cBlock)
+	aesGCM, err := newGCM(deriveLegacyKey(password, cipherText[:legacySaltLength]))
 	if err != nil {
 		return "", err
 	}
 
-	nonceSize := aesGCM.NonceSize()
-	nonce := cipherText[16 : 16+nonceSize]
-
-	cipherText = cipherText[16+nonceSize:]
-
-	text, err := aesGCM.Open(nil, nonce, cipherText, nil)
+	nonce := cipherText[legacySaltLength : legacySaltLength+nonceLength]
+	text, err := aesGCM.Open(nil, nonce, cipherText[legacySaltLength+nonceLength:], nil)
 	if err != nil {
-		return "", err
+		return "", ErrDecrypt
 	}
 	return string(text), nil
 }
 
-func deriveKey(password string, salt []byte) []byte {
-	return pbkdf2.Key([]byte(password), salt, 100_000, 32, sha256.New)
+func deriveLegacyKey(password string, salt []byte) []byte {
+	return pbkdf2.Key([]byte(password), salt, legacyIterations, 32, sha256.New)
 }
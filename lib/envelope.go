@@ -0,0 +1,251 @@
+package lib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+/*
+KDF identifies how the key of an envelope is derived. It's stored in the
+envelope so the key derivation can change without breaking what's already
+been encrypted.
+*/
+type KDF byte
+
+const (
+	// The envelope is encrypted directly with a data key
+	KDF_NONE = KDF(iota)
+	// The key is derived from a password with Argon2id
+	KDF_ARGON2ID
+)
+
+const (
+	envelopeVersion byte = 1
+	nonceLength          = 12
+	tagLength            = 16
+	// Upper bound on the Argon2 memory an envelope may ask for, in KiB
+	maxMemoryCost uint32 = 1024 * 1024
+)
+
+var (
+	ErrCipherText        = fmt.Errorf("malformed cipher text")
+	ErrCipherTextLength  = fmt.Errorf("%w: too short", ErrCipherText)
+	ErrCipherTextVersion = fmt.Errorf("%w: unsupported version", ErrCipherText)
+	ErrCipherTextKDF     = fmt.Errorf("%w: unsupported key derivation", ErrCipherText)
+	ErrCipherTextParams  = fmt.Errorf("%w: invalid key derivation parameters", ErrCipherText)
+	ErrDecrypt           = fmt.Errorf("cannot decrypt: wrong key or mismatched additional data")
+)
+
+/*
+KDFParams are the parameters an envelope's key was derived with. They're
+unused with KDF_NONE.
+*/
+type KDFParams struct {
+	KDF     KDF
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	Salt    []byte
+}
+
+// newArgon2Params returns the current Argon2id costs with a random salt
+func newArgon2Params() (KDFParams, error) {
+	salt := make([]byte, saltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return KDFParams{}, err
+	}
+	return KDFParams{
+		KDF:     KDF_ARGON2ID,
+		Time:    timeCost,
+		Memory:  memoryCost,
+		Threads: threads,
+		Salt:    salt,
+	}, nil
+}
+
+func (p KDFParams) deriveKey(password string) ([]byte, error) {
+	if p.KDF != KDF_ARGON2ID {
+		return nil, fmt.Errorf("%w: %d", ErrCipherTextKDF, p.KDF)
+	}
+	return argon2.IDKey([]byte(password), p.Salt, p.Time, p.Memory, p.Threads, dataKeyLength), nil
+}
+
+/*
+header encodes everything in front of the nonce:
+
+	version | kdf | params
+
+where the params of KDF_ARGON2ID are time (4) | memory (4) | threads (1) |
+salt (16), and KDF_NONE has none.
+*/
+func (p KDFParams) header() []byte {
+	header := []byte{envelopeVersion, byte(p.KDF)}
+	if p.KDF == KDF_ARGON2ID {
+		header = binary.BigEndian.AppendUint32(header, p.Time)
+		header = binary.BigEndian.AppendUint32(header, p.Memory)
+		header = append(header, p.Threads)
+		header = append(header, p.Salt...)
+	}
+	return header
+}
+
+// parseHeader reads the header and returns its params along with its length
+func parseHeader(envelope []byte) (KDFParams, int, error) {
+	if len(envelope) < 2 {
+		return KDFParams{}, 0, ErrCipherTextLength
+	}
+	if envelope[0] != envelopeVersion {
+		return KDFParams{}, 0, fmt.Errorf("%w: %d", ErrCipherTextVersion, envelope[0])
+	}
+
+	params := KDFParams{KDF: KDF(envelope[1])}
+	switch params.KDF {
+	case KDF_NONE:
+		return params, 2, nil
+
+	case KDF_ARGON2ID:
+		length := 2 + 4 + 4 + 1 + int(saltLength)
+		if len(envelope) < length {
+			return KDFParams{}, 0, ErrCipherTextLength
+		}
+		params.Time = binary.BigEndian.Uint32(envelope[2:6])
+		params.Memory = binary.BigEndian.Uint32(envelope[6:10])
+		params.Threads = envelope[10]
+		params.Salt = envelope[11:length]
+
+		if params.Time == 0 || params.Threads == 0 ||
+			params.Memory < 8*uint32(params.Threads) || params.Memory > maxMemoryCost {
+			return KDFParams{}, 0, ErrCipherTextParams
+		}
+		return params, length, nil
+	}
+
+	return KDFParams{}, 0, fmt.Errorf("%w: %d", ErrCipherTextKDF, params.KDF)
+}
+
+/*
+sealEnvelope encrypts data with key into a versioned envelope:
+
+	header | nonce (12) | cipher text | tag (16)
+
+The header and aad are both authenticated, so the envelope can neither be
+tampered with nor opened with different additional data, which is how a
+field is bound to the row it belongs to.
+*/
+func sealEnvelope(key []byte, params KDFParams, data, aad []byte) ([]byte, error) {
+	aesGCM, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := params.header()
+	nonce := make([]byte, nonceLength)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	additional := append(append([]byte{}, header...), aad...)
+	envelope := append(header, nonce...)
+	return aesGCM.Seal(envelope, nonce, data, additional), nil
+}
+
+/*
+openEnvelope decrypts an envelope made by sealEnvelope, with the key that
+keyFor returns for the envelope's params.
+*/
+func openEnvelope(
+	envelope, aad []byte,
+	keyFor func(KDFParams) ([]byte, error),
+) ([]byte, error) {
+	params, headerLength, err := parseHeader(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(envelope) < headerLength+nonceLength+tagLength {
+		return nil, ErrCipherTextLength
+	}
+
+	key, err := keyFor(params)
+	if err != nil {
+		return nil, err
+	}
+
+	aesGCM, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := envelope[:headerLength]
+	nonce := envelope[headerLength : headerLength+nonceLength]
+	data, err := aesGCM.Open(
+		nil,
+		nonce,
+		envelope[headerLength+nonceLength:],
+		append(append([]byte{}, header...), aad...),
+	)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return data, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	cBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(cBlock)
+}
+
+/*
+PasswordKey is a key derived from a password along with the params it was
+derived with, so data can be sealed with it more than once without running
+the key derivation again.
+*/
+type PasswordKey struct {
+	key    []byte
+	params KDFParams
+}
+
+// NewPasswordKey derives a key from the password with a new random salt
+func NewPasswordKey(password string) (*PasswordKey, error) {
+	params, err := newArgon2Params()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := params.deriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	return &PasswordKey{key, params}, nil
+}
+
+/*
+OpenWithPassword opens an envelope sealed by a PasswordKey, deriving the key
+from the password with the params stored in the envelope. The key is
+returned as well, so the data can be sealed again once it changes.
+*/
+func OpenWithPassword(envelope, aad []byte, password string) ([]byte, *PasswordKey, error) {
+	var key PasswordKey
+	data, err := openEnvelope(envelope, aad, func(params KDFParams) (k []byte, err error) {
+		key.params = params
+		key.key, err = params.deriveKey(password)
+		return key.key, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, &key, nil
+}
+
+func (k *PasswordKey) Seal(data, aad []byte) ([]byte, error) {
+	return sealEnvelope(k.key, k.params, data, aad)
+}
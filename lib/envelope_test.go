@@ -0,0 +1,140 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelope(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, dataKeyLength)
+	aad := []byte("credit_cards.card_number:1")
+	keyFor := func(KDFParams) ([]byte, error) { return key, nil }
+
+	sealed, err := sealEnvelope(key, KDFParams{KDF: KDF_NONE}, []byte("5822"), aad)
+	require.NoError(t, err)
+
+	argon2Params := KDFParams{
+		KDF:     KDF_ARGON2ID,
+		Time:    1,
+		Memory:  64,
+		Threads: 1,
+		Salt:    bytes.Repeat([]byte{1}, int(saltLength)),
+	}
+	wrapped, err := sealEnvelope(key, argon2Params, []byte("5822"), aad)
+	require.NoError(t, err)
+
+	modify := func(envelope []byte, fn func([]byte)) []byte {
+		envelope = append([]byte{}, envelope...)
+		fn(envelope)
+		return envelope
+	}
+
+	type MockTable struct {
+		should   string
+		envelope []byte
+		aad      []byte
+		expected error
+	}
+
+	table := []MockTable{
+		{
+			should:   "error on an empty envelope",
+			envelope: nil,
+			aad:      aad,
+			expected: ErrCipherTextLength,
+		},
+		{
+			should:   "error when the cipher text is cut short",
+			envelope: sealed[:len(sealed)-tagLength],
+			aad:      aad,
+			expected: ErrCipherTextLength,
+		},
+		{
+			should:   "error when the argon2 params are cut short",
+			envelope: wrapped[:8],
+			aad:      aad,
+			expected: ErrCipherTextLength,
+		},
+		{
+			should:   "error on an unknown version",
+			envelope: modify(sealed, func(e []byte) { e[0] = 9 }),
+			aad:      aad,
+			expected: ErrCipherTextVersion,
+		},
+		{
+			should:   "error on an unknown key derivation",
+			envelope: modify(sealed, func(e []byte) { e[1] = 9 }),
+			aad:      aad,
+			expected: ErrCipherTextKDF,
+		},
+		{
+			should: "error on unreasonable argon2 params",
+			envelope: modify(wrapped, func(e []byte) {
+				binary.BigEndian.PutUint32(e[6:10], maxMemoryCost+1)
+			}),
+			aad:      aad,
+			expected: ErrCipherTextParams,
+		},
+		{
+			should:   "error when the argon2 params are tampered with",
+			envelope: modify(wrapped, func(e []byte) { e[2] = 2 }),
+			aad:      aad,
+			expected: ErrDecrypt,
+		},
+		{
+			should:   "error when moved to another row",
+			envelope: sealed,
+			aad:      []byte("credit_cards.card_number:2"),
+			expected: ErrDecrypt,
+		},
+		{
+			should:   "error when moved to another column",
+			envelope: sealed,
+			aad:      []byte("credit_cards.notes:1"),
+			expected: ErrDecrypt,
+		},
+	}
+
+	for _, mock := range table {
+		t.Run("should "+mock.should, func(t *testing.T) {
+			t.Parallel()
+			_, err := openEnvelope(mock.envelope, mock.aad, keyFor)
+			assert.ErrorIs(t, err, mock.expected)
+		})
+	}
+
+	t.Run("should open with the same key and additional data", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		data, err := openEnvelope(sealed, aad, keyFor)
+		r.NoError(err)
+		a.Equal([]byte("5822"), data)
+
+		var params KDFParams
+		data, err = openEnvelope(wrapped, aad, func(p KDFParams) ([]byte, error) {
+			params = p
+			return key, nil
+		})
+		r.NoError(err)
+		a.Equal([]byte("5822"), data)
+		a.Equal(argon2Params, params)
+	})
+}
+
+func TestDecryptLegacyData(t *testing.T) {
+	t.Run("should error instead of panicking on short cipher text", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		for _, data := range []string{"", "c2hvcnQ=", "not base64!"} {
+			_, err := DecryptLegacyData(data, "password")
+			a.ErrorIs(err, ErrCipherText, data)
+		}
+	})
+}
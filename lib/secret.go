@@ -0,0 +1,51 @@
+package lib
+
+import "strings"
+
+const (
+	maskVisible = 4
+	maskPrefix  = "••••"
+)
+
+/*
+Secret holds a decrypted value that shouldn't end up on screen or in a log
+by accident. It prints masked down to its last four characters, so it has
+to be revealed on purpose, and unlike a string its bytes can be wiped once
+it's no longer needed.
+*/
+type Secret struct {
+	value []byte
+}
+
+func NewSecret(value string) *Secret {
+	return &Secret{[]byte(value)}
+}
+
+// Reveal returns the value in full
+func (s *Secret) Reveal() string {
+	return string(s.value)
+}
+
+/*
+String masks all but the last four characters, ignoring spaces, so a card
+number like "2382 3812 4582 5822" prints as "•••• 5822".
+*/
+func (s Secret) String() string {
+	value := []rune(strings.ReplaceAll(string(s.value), " ", ""))
+	if len(value) <= maskVisible {
+		return maskPrefix
+	}
+	return maskPrefix + " " + string(value[len(value)-maskVisible:])
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// Wipe zeroes the value, leaving an empty secret
+func (s *Secret) Wipe() {
+	for i := range s.value {
+		s.value[i] = 0
+	}
+	s.value = nil
+}
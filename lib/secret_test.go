@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecret(t *testing.T) {
+	type MockTable struct {
+		should   string
+		value    string
+		expected string
+	}
+
+	table := []MockTable{
+		{
+			should:   "mask all but the last four digits",
+			value:    "2382 3812 4582 5822",
+			expected: "•••• 5822",
+		},
+		{
+			should:   "mask an account number",
+			value:    "282841",
+			expected: "•••• 2841",
+		},
+		{
+			should:   "mask short values completely",
+			value:    "5822",
+			expected: "••••",
+		},
+	}
+
+	for _, mock := range table {
+		t.Run("should "+mock.should, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+
+			secret := NewSecret(mock.value)
+			a.Equal(mock.expected, secret.String())
+			a.Equal(mock.expected, fmt.Sprintf("%v", secret))
+			a.Equal(mock.expected, fmt.Sprintf("%#v", secret))
+			a.Equal(mock.value, secret.Reveal())
+		})
+	}
+
+	t.Run("should wipe the value", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		secret := NewSecret("2382 3812 4582 5822")
+		value := secret.value
+		secret.Wipe()
+
+		a.Empty(secret.Reveal())
+		a.Equal(make([]byte, len(value)), value)
+	})
+}
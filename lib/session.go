@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Locker is anything that holds key material behind a password, like a
+database with a vault.
+*/
+type Locker interface {
+	Unlock(password string) error
+	Lock()
+	IsLocked() bool
+}
+
+/*
+Session keeps a Locker unlocked for as long as it's in use. Once nothing
+has touched it for the idle timeout it locks itself, which wipes the key,
+and it has to be unlocked with the password again.
+*/
+type Session struct {
+	mu      sync.Mutex
+	locker  Locker
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func NewSession(locker Locker, timeout time.Duration) *Session {
+	return &Session{locker: locker, timeout: timeout}
+}
+
+/*
+Unlock unlocks the locker and starts counting down the idle timeout. A
+session that's already unlocked is only touched.
+*/
+func (s *Session) Unlock(password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.locker.IsLocked() {
+		s.resetTimer()
+		return nil
+	}
+
+	if err := s.locker.Unlock(password); err != nil {
+		return err
+	}
+	s.resetTimer()
+	return nil
+}
+
+// Touch restarts the idle timeout, if the session is unlocked
+func (s *Session) Touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.resetTimer()
+	}
+}
+
+// Lock locks the session right away
+func (s *Session) Lock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopTimer()
+	s.locker.Lock()
+}
+
+func (s *Session) IsLocked() bool {
+	return s.locker.IsLocked()
+}
+
+// Callers must hold s.mu
+func (s *Session) resetTimer() {
+	s.stopTimer()
+
+	var timer *time.Timer
+	timer = time.AfterFunc(s.timeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// Touched again while this was waiting on the lock
+		if s.timer != timer {
+			return
+		}
+		s.timer = nil
+		s.locker.Lock()
+	})
+	s.timer = timer
+}
+
+// Callers must hold s.mu
+func (s *Session) stopTimer() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockLocker struct {
+	mu       sync.Mutex
+	unlocked bool
+}
+
+func (m *mockLocker) Unlock(password string) error {
+	if password != "password" {
+		return ErrWrongPassword
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.unlocked = true
+	return nil
+}
+
+func (m *mockLocker) Lock() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.unlocked = false
+}
+
+func (m *mockLocker) IsLocked() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.unlocked
+}
+
+func TestSession(t *testing.T) {
+	t.Run("should lock after being idle", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		session := NewSession(&mockLocker{}, 50*time.Millisecond)
+		a.True(session.IsLocked())
+
+		a.ErrorIs(session.Unlock("wrong"), ErrWrongPassword)
+		a.True(session.IsLocked())
+
+		r.NoError(session.Unlock("password"))
+		a.False(session.IsLocked())
+
+		a.Eventually(session.IsLocked, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should stay unlocked while touched", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		session := NewSession(&mockLocker{}, 100*time.Millisecond)
+		r.NoError(session.Unlock("password"))
+
+		for range 5 {
+			time.Sleep(40 * time.Millisecond)
+			session.Touch()
+			a.False(session.IsLocked())
+		}
+
+		session.Lock()
+		a.True(session.IsLocked())
+	})
+
+	t.Run("should not unlock when touched while locked", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		session := NewSession(&mockLocker{}, time.Minute)
+		session.Touch()
+		a.True(session.IsLocked())
+	})
+}
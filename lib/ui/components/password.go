@@ -0,0 +1,71 @@
+package components
+
+import (
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+/*
+PasswordModel asks for a password and quits once it's entered, for when a
+password is needed before anything else can be shown.
+*/
+type PasswordModel struct {
+	PasswordInput textinput.Model
+	title         string
+	err           error
+	password      string
+	cancelled     bool
+}
+
+func NewPasswordModel(title string) PasswordModel {
+	return PasswordModel{
+		PasswordInput: NewPasswordInput(),
+		title:         title,
+	}
+}
+
+// WithError shows why the password has to be entered again
+func (m PasswordModel) WithError(err error) PasswordModel {
+	m.err = err
+	return m
+}
+
+func (m PasswordModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m PasswordModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "ctrl+c", "esc":
+			m.cancelled = true
+			return m, tea.Quit
+
+		case "enter":
+			m.password = m.PasswordInput.Value()
+			m.PasswordInput.Reset()
+			return m, tea.Quit
+		}
+	}
+
+	var cmd tea.Cmd
+	m.PasswordInput, cmd = m.PasswordInput.Update(msg)
+	return m, cmd
+}
+
+func (m PasswordModel) View() string {
+	if m.password != "" || m.cancelled {
+		return ""
+	}
+
+	view := m.title + "\n\n" + m.PasswordInput.View() + "\n"
+	if m.err != nil {
+		view += "\n" + errorStyle.Render(m.err.Error()) + "\n"
+	}
+	return view
+}
+
+// Password returns the entered password, and false if it was cancelled
+func (m PasswordModel) Password() (string, bool) {
+	return m.password, !m.cancelled
+}
@@ -0,0 +1,90 @@
+package components
+
+import (
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jaeiya/billbank/lib"
+)
+
+const sessionCheckInterval = time.Second
+
+var errorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87"))
+
+type sessionTickMsg time.Time
+
+/*
+SessionModel shows its model only while the session is unlocked. Any key
+press keeps the session alive, and once it locks itself after being idle,
+the password is asked for again before the model is shown.
+*/
+type SessionModel struct {
+	PasswordInput textinput.Model
+	session       *lib.Session
+	model         tea.Model
+	err           error
+}
+
+func NewSessionModel(session *lib.Session, model tea.Model) SessionModel {
+	return SessionModel{
+		PasswordInput: NewPasswordInput(),
+		session:       session,
+		model:         model,
+	}
+}
+
+func (m SessionModel) Init() tea.Cmd {
+	return tea.Batch(m.model.Init(), textinput.Blink, sessionTick())
+}
+
+func (m SessionModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case sessionTickMsg:
+		// Re-rendered on every tick so the prompt shows up once the session locks
+		return m, sessionTick()
+
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			return m, tea.Quit
+		}
+
+		if !m.session.IsLocked() {
+			m.session.Touch()
+			break
+		}
+
+		if msg.String() == "enter" {
+			m.err = m.session.Unlock(m.PasswordInput.Value())
+			m.PasswordInput.Reset()
+			return m, nil
+		}
+
+		m.PasswordInput, cmd = m.PasswordInput.Update(msg)
+		return m, cmd
+	}
+
+	m.model, cmd = m.model.Update(msg)
+	return m, cmd
+}
+
+func (m SessionModel) View() string {
+	if !m.session.IsLocked() {
+		return m.model.View()
+	}
+
+	view := "Enter your password to unlock\n\n" + m.PasswordInput.View()
+	if m.err != nil {
+		view += "\n\n" + errorStyle.Render(m.err.Error())
+	}
+	return view
+}
+
+func sessionTick() tea.Cmd {
+	return tea.Tick(sessionCheckInterval, func(t time.Time) tea.Msg {
+		return sessionTickMsg(t)
+	})
+}
@@ -33,3 +33,15 @@ func NewCommanderInput() textinput.Model {
 	m.ShowSuggestions = true
 	return m
 }
+
+func NewPasswordInput() textinput.Model {
+	m := textinput.New()
+	m.Focus()
+	m.Cursor.Style = m.Cursor.Style.Foreground(lipgloss.Color("#00FFA2"))
+	m.PromptStyle = m.Cursor.Style.Foreground(lipgloss.Color("#00FFA2"))
+	m.Cursor.BlinkSpeed = blinkSpeed
+	m.EchoMode = textinput.EchoPassword
+	m.EchoCharacter = '•'
+	m.Prompt = "Password: "
+	return m
+}
@@ -0,0 +1,268 @@
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const dataKeyLength = 32
+
+var wrappedKeyAAD = []byte("billbank vault key")
+
+var (
+	ErrVaultLocked   = fmt.Errorf("vault is locked")
+	ErrWrongPassword = fmt.Errorf("wrong password")
+	ErrWrappedKey    = fmt.Errorf("cannot unwrap data key")
+	ErrEmptyPassword = fmt.Errorf("password cannot be empty")
+)
+
+/*
+VaultKeys is what a database keeps so its vault can be unlocked: an Argon2
+verifier for the master password, and the data key encrypted with a key
+derived from that same password.
+*/
+type VaultKeys struct {
+	Verifier   string
+	WrappedKey string
+}
+
+/*
+Vault encrypts fields with a single random data key. The expensive key
+derivation only runs when the vault is created or unlocked, instead of once
+for every encrypted field.
+
+The zero value is a locked vault.
+*/
+type Vault struct {
+	mu  sync.RWMutex
+	key []byte
+}
+
+/*
+Create generates a new data key protected by the password and unlocks the
+vault with it. The returned keys must be stored to unlock the vault again.
+
+Returns ErrEmptyPassword if the password is empty.
+*/
+func (v *Vault) Create(password string) (VaultKeys, error) {
+	if password == "" {
+		return VaultKeys{}, ErrEmptyPassword
+	}
+
+	key := make([]byte, dataKeyLength)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return VaultKeys{}, err
+	}
+
+	keys, err := wrapKeys(key, password)
+	if err != nil {
+		return VaultKeys{}, err
+	}
+
+	v.setKey(key)
+	return keys, nil
+}
+
+/*
+Unlock validates the password against the stored keys and unwraps the data
+key with it.
+
+Returns ErrWrongPassword if the password does not match.
+*/
+func (v *Vault) Unlock(password string, keys VaultKeys) error {
+	valid, err := ValidatePassword(password, keys.Verifier)
+	if err != nil {
+		return fmt.Errorf("cannot validate password: %w", err)
+	}
+	if !valid {
+		return ErrWrongPassword
+	}
+
+	key, err := unwrapKey(keys.WrappedKey, password)
+	if err != nil {
+		return err
+	}
+
+	v.setKey(key)
+	return nil
+}
+
+// Replace moves the data key of other into v, leaving other locked
+func (v *Vault) Replace(other *Vault) {
+	other.mu.Lock()
+	key := other.key
+	other.key = nil
+	other.mu.Unlock()
+
+	v.setKey(key)
+}
+
+// Lock wipes the data key from memory
+func (v *Vault) Lock() {
+	v.setKey(nil)
+}
+
+func (v *Vault) IsLocked() bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.key == nil
+}
+
+/*
+Encrypt encrypts data with the data key and returns the envelope as base64.
+The same aad has to be given to decrypt it, which binds the cipher text to
+wherever it's stored.
+*/
+func (v *Vault) Encrypt(data string, aad []byte) (string, error) {
+	var envelope []byte
+	err := v.withKey(func(key []byte) (err error) {
+		envelope, err = sealEnvelope(key, KDFParams{KDF: KDF_NONE}, []byte(data), aad)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(envelope), nil
+}
+
+func (v *Vault) Decrypt(data string, aad []byte) (string, error) {
+	text, err := v.open(data, aad)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+/*
+DecryptSecret decrypts data straight into a Secret, so the decrypted value
+never passes through a string that can't be wiped. Returns nil if data is
+nil.
+*/
+func (v *Vault) DecryptSecret(data *string, aad []byte) (*Secret, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	text, err := v.open(*data, aad)
+	if err != nil {
+		return nil, err
+	}
+	return &Secret{text}, nil
+}
+
+func (v *Vault) open(data string, aad []byte) ([]byte, error) {
+	envelope, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCipherText, err)
+	}
+
+	var text []byte
+	err = v.withKey(func(key []byte) (err error) {
+		text, err = openEnvelope(envelope, aad, func(params KDFParams) ([]byte, error) {
+			if params.KDF != KDF_NONE {
+				return nil, fmt.Errorf("%w: %d", ErrCipherTextKDF, params.KDF)
+			}
+			return key, nil
+		})
+		return err
+	})
+	return text, err
+}
+
+/*
+EncryptNonNil encrypts data with the data key and returns the encrypted
+string; however if data is nil it returns nil.
+*/
+func (v *Vault) EncryptNonNil(data *string, aad []byte) (any /* nil|string */, error) {
+	if data == nil {
+		return nil, nil
+	}
+	return v.Encrypt(*data, aad)
+}
+
+func (v *Vault) DecryptNonNil(data *string, aad []byte) (*string, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	decData, err := v.Decrypt(*data, aad)
+	if err != nil {
+		return nil, err
+	}
+	return &decData, nil
+}
+
+func (v *Vault) withKey(fn func(key []byte) error) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.key == nil {
+		return ErrVaultLocked
+	}
+	return fn(v.key)
+}
+
+// setKey replaces the data key, zeroing the old one first
+func (v *Vault) setKey(key []byte) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for i := range v.key {
+		v.key[i] = 0
+	}
+	v.key = key
+}
+
+/*
+wrapKeys hashes the password into a verifier and seals the data key in an
+envelope keyed from the password. The verifier and the wrapping key use
+different salts, so the stored verifier never reveals the wrapping key.
+*/
+func wrapKeys(key []byte, password string) (VaultKeys, error) {
+	verifier, err := HashPassword(password)
+	if err != nil {
+		return VaultKeys{}, err
+	}
+
+	params, err := newArgon2Params()
+	if err != nil {
+		return VaultKeys{}, err
+	}
+
+	wrappingKey, err := params.deriveKey(password)
+	if err != nil {
+		return VaultKeys{}, err
+	}
+
+	envelope, err := sealEnvelope(wrappingKey, params, key, wrappedKeyAAD)
+	if err != nil {
+		return VaultKeys{}, err
+	}
+
+	return VaultKeys{
+		Verifier:   verifier,
+		WrappedKey: base64.StdEncoding.EncodeToString(envelope),
+	}, nil
+}
+
+func unwrapKey(wrappedKey string, password string) ([]byte, error) {
+	envelope, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWrappedKey, err)
+	}
+
+	key, err := openEnvelope(envelope, wrappedKeyAAD, func(params KDFParams) ([]byte, error) {
+		return params.deriveKey(password)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWrappedKey, err)
+	}
+
+	if len(key) != dataKeyLength {
+		return nil, ErrWrappedKey
+	}
+	return key, nil
+}
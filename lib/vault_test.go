@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVault(t *testing.T) {
+	t.Run("should decrypt fields after unlocking with the same password", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		var created Vault
+		keys, err := created.Create("password")
+		r.NoError(err)
+
+		encrypted, err := created.Encrypt("2382 3812 4582 5822", []byte("card"))
+		r.NoError(err)
+		a.NotContains(encrypted, "2382")
+
+		var unlocked Vault
+		a.ErrorIs(unlocked.Unlock("wrong", keys), ErrWrongPassword)
+		a.True(unlocked.IsLocked())
+
+		r.NoError(unlocked.Unlock("password", keys))
+		decrypted, err := unlocked.Decrypt(encrypted, []byte("card"))
+		r.NoError(err)
+		a.Equal("2382 3812 4582 5822", decrypted)
+	})
+
+	t.Run("should refuse an empty password", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+
+		var vault Vault
+		_, err := vault.Create("")
+		a.ErrorIs(err, ErrEmptyPassword)
+		a.True(vault.IsLocked())
+	})
+
+	t.Run("should error while locked", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		var vault Vault
+		_, err := vault.Encrypt("notes", nil)
+		a.ErrorIs(err, ErrVaultLocked)
+
+		_, err = vault.Create("password")
+		r.NoError(err)
+		encrypted, err := vault.Encrypt("notes", nil)
+		r.NoError(err)
+
+		vault.Lock()
+		a.True(vault.IsLocked())
+		_, err = vault.Decrypt(encrypted, nil)
+		a.ErrorIs(err, ErrVaultLocked)
+	})
+
+	t.Run("should skip nil fields", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		var vault Vault
+		encrypted, err := vault.EncryptNonNil(nil, nil)
+		r.NoError(err)
+		a.Nil(encrypted)
+
+		decrypted, err := vault.DecryptNonNil(nil, nil)
+		r.NoError(err)
+		a.Nil(decrypted)
+	})
+
+	t.Run("should error on malformed cipher text", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		r := require.New(t)
+
+		var vault Vault
+		_, err := vault.Create("password")
+		r.NoError(err)
+
+		for _, data := range []string{"", "c2hvcnQ=", "not base64!"} {
+			_, err := vault.Decrypt(data, nil)
+			a.ErrorIs(err, ErrCipherText, data)
+		}
+	})
+}
@@ -0,0 +1,15 @@
+package lib
+
+// Set when building a release, see .goreleaser.yml
+var (
+	appVersion = "dev"
+	commitSha  = ""
+)
+
+// Version returns the version of the build along with its commit, if known
+func Version() string {
+	if commitSha == "" {
+		return appVersion
+	}
+	return appVersion + " (" + commitSha + ")"
+}